@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/transport"
+)
+
+// retryBuffer holds encoded samples that haven't reached Redis yet. Every tick the whole
+// buffer is published in one pipeline and only the messages that failed stay queued, in
+// their original order, so a partially failed flush neither drops nor resends samples.
+// When full, the oldest sample is dropped to make room.
+//
+// A large backlog (after an outage) is split into drainChunk-sized pipelines, and with
+// parallel > 1 up to that many are in flight at once. That drains faster but gives up
+// ordering between chunks; within a chunk messages still go out in order.
+type retryBuffer struct {
+	pending  []transport.Message
+	max      int
+	parallel int
+	dropped  uint64
+}
+
+// drainChunk is the number of messages per pipeline when draining a backlog.
+const drainChunk = 100
+
+func newRetryBuffer(max, parallel int) *retryBuffer {
+	return &retryBuffer{max: max, parallel: parallel}
+}
+
+func (b *retryBuffer) add(msg transport.Message) {
+	if len(b.pending) >= b.max {
+		n := copy(b.pending, b.pending[1:])
+		b.pending = b.pending[:n]
+		b.dropped++
+	}
+	b.pending = append(b.pending, msg)
+}
+
+func (b *retryBuffer) len() int { return len(b.pending) }
+
+// flush publishes everything pending and requeues only the failures. It returns how
+// many messages were sent and the first error seen, if any.
+func (b *retryBuffer) flush(ctx context.Context, tr transport.Transport) (sent int, err error) {
+	if len(b.pending) == 0 {
+		return 0, nil
+	}
+	errs := b.publish(ctx, tr)
+	failed := b.pending[:0]
+	for i, e := range errs {
+		if e == nil {
+			sent++
+			continue
+		}
+		if err == nil {
+			err = e
+		}
+		failed = append(failed, b.pending[i])
+	}
+	// Clear the tail so sent payloads can be collected.
+	clear(b.pending[len(failed):])
+	b.pending = failed
+	return sent, err
+}
+
+// publish sends pending in one pipeline, or for a backlog in chunks of drainChunk with
+// up to parallel pipelines at a time. It returns one error per message.
+func (b *retryBuffer) publish(ctx context.Context, tr transport.Transport) []error {
+	if b.parallel <= 1 || len(b.pending) <= drainChunk {
+		return tr.PublishBatch(ctx, b.pending)
+	}
+	errs := make([]error, len(b.pending))
+	sem := make(chan struct{}, b.parallel)
+	var wg sync.WaitGroup
+	for lo := 0; lo < len(b.pending); lo += drainChunk {
+		hi := min(lo+drainChunk, len(b.pending))
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			copy(errs[lo:hi], tr.PublishBatch(ctx, b.pending[lo:hi]))
+		}()
+	}
+	wg.Wait()
+	return errs
+}
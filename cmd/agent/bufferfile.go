@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/transport"
+)
+
+// maxBufferFileBytes caps the -buffer-file so a huge backlog can't fill the disk; the
+// oldest messages are left out to make it fit.
+const maxBufferFileBytes = 64 << 20
+
+// bufferFileMagic starts every buffer file and versions the layout.
+var bufferFileMagic = []byte("SSBUF1\n")
+
+// Each record is a 10-byte header (channel length u16, payload length u32, CRC-32 of
+// channel+payload u32, all big-endian) followed by the channel and the payload.
+const bufferRecordHeader = 10
+
+var errBufferFileCorrupt = errors.New("buffer file is corrupt")
+
+// save writes everything pending to path, replacing it atomically. With nothing
+// pending any old file is removed so it isn't replayed later.
+func (b *retryBuffer) save(path string) error {
+	if len(b.pending) == 0 {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	// Keep the newest messages that fit in maxBufferFileBytes.
+	first, size := len(b.pending), len(bufferFileMagic)
+	for first > 0 {
+		m := b.pending[first-1]
+		n := bufferRecordHeader + len(m.Channel) + len(m.Payload)
+		if size+n > maxBufferFileBytes {
+			break
+		}
+		size += n
+		first--
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+	w := bufio.NewWriter(tmp)
+	w.Write(bufferFileMagic)
+	for _, m := range b.pending[first:] {
+		writeBufferRecord(w, m)
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// load queues the messages saved in path ahead of anything new and removes the file,
+// so they are sent once. A missing file is not an error. Records after a corrupt or
+// truncated one are discarded; the ones before it are still restored.
+func (b *retryBuffer) load(path string) (int, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	restored, readErr := b.readRecords(bufio.NewReader(io.LimitReader(f, maxBufferFileBytes)))
+	f.Close()
+	if err := os.Remove(path); err != nil {
+		return restored, err
+	}
+	return restored, readErr
+}
+
+func (b *retryBuffer) readRecords(r *bufio.Reader) (int, error) {
+	if err := readBufferMagic(r); err != nil {
+		return 0, err
+	}
+	restored := 0
+	for {
+		m, _, err := readBufferRecord(r)
+		if err == io.EOF {
+			return restored, nil
+		}
+		if err != nil {
+			return restored, fmt.Errorf("%w after %d messages", err, restored)
+		}
+		b.add(m)
+		restored++
+	}
+}
+
+func readBufferMagic(r io.Reader) error {
+	magic := make([]byte, len(bufferFileMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != string(bufferFileMagic) {
+		return fmt.Errorf("%w: bad header", errBufferFileCorrupt)
+	}
+	return nil
+}
+
+// writeBufferRecord writes m as one record; errors surface on the writer's Flush.
+func writeBufferRecord(w *bufio.Writer, m transport.Message) {
+	var hdr [bufferRecordHeader]byte
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(m.Channel))
+	crc.Write(m.Payload)
+	binary.BigEndian.PutUint16(hdr[0:2], uint16(len(m.Channel)))
+	binary.BigEndian.PutUint32(hdr[2:6], uint32(len(m.Payload)))
+	binary.BigEndian.PutUint32(hdr[6:10], crc.Sum32())
+	w.Write(hdr[:])
+	w.WriteString(m.Channel)
+	w.Write(m.Payload)
+}
+
+// readBufferRecord reads the next record and returns it with its size in bytes. At a
+// clean end of input the error is io.EOF.
+func readBufferRecord(r *bufio.Reader) (transport.Message, int, error) {
+	var hdr [bufferRecordHeader]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		if err == io.EOF {
+			return transport.Message{}, 0, io.EOF
+		}
+		return transport.Message{}, 0, fmt.Errorf("%w: truncated", errBufferFileCorrupt)
+	}
+	rec := make([]byte, int(binary.BigEndian.Uint16(hdr[0:2]))+int(binary.BigEndian.Uint32(hdr[2:6])))
+	if len(rec) > maxBufferFileBytes {
+		return transport.Message{}, 0, fmt.Errorf("%w: oversized record", errBufferFileCorrupt)
+	}
+	if _, err := io.ReadFull(r, rec); err != nil {
+		return transport.Message{}, 0, fmt.Errorf("%w: truncated", errBufferFileCorrupt)
+	}
+	if crc32.ChecksumIEEE(rec) != binary.BigEndian.Uint32(hdr[6:10]) {
+		return transport.Message{}, 0, fmt.Errorf("%w: checksum mismatch", errBufferFileCorrupt)
+	}
+	n := binary.BigEndian.Uint16(hdr[0:2])
+	return transport.Message{Channel: string(rec[:n]), Payload: rec[n:]}, bufferRecordHeader + len(rec), nil
+}
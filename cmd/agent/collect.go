@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/collectors"
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/transport"
+)
+
+// splitList splits a comma-separated flag value, dropping blanks.
+func splitList(v string) []string {
+	var out []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// enableCollectors creates the named collectors, once each, in order. One that can't
+// run on this host is skipped with a log line unless it is required.
+func enableCollectors(names []string, required map[string]bool) ([]collectors.Collector, error) {
+	var enabled []collectors.Collector
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		c, err := collectors.New(name)
+		if errors.Is(err, collectors.ErrUnavailable) && !required[name] {
+			slog.Warn("Skipping collector", "collector", name, "err", err)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		enabled = append(enabled, c)
+	}
+	return enabled, nil
+}
+
+// parseRequired validates a -require list against the enabled collector names.
+func parseRequired(v string, enabled []string) (map[string]bool, error) {
+	required := make(map[string]bool)
+	for _, name := range splitList(v) {
+		if !slices.Contains(enabled, name) {
+			return nil, fmt.Errorf("collector %q is not enabled (see -collectors)", name)
+		}
+		required[name] = true
+	}
+	return required, nil
+}
+
+// collectMetrics runs every enabled collector and builds one Metric from their samples.
+// A failing required collector fails the whole sample; optional collectors are
+// best-effort and just leave their fields out.
+func collectMetrics(ctx context.Context, enabled []collectors.Collector, required map[string]bool) (*transport.Metric, error) {
+	m := &transport.Metric{Timestamp: time.Now().Unix()}
+	var samples []collectors.Sample
+	for _, c := range enabled {
+		s, err := c.Collect(ctx)
+		if err != nil {
+			if required[c.Name()] {
+				return nil, fmt.Errorf("%s: %w", c.Name(), err)
+			}
+			slog.Warn("Optional collector failed", "collector", c.Name(), "err", err)
+			continue
+		}
+		samples = append(samples, s...)
+	}
+	collectors.Fill(m, samples)
+	return m, nil
+}
@@ -2,36 +2,302 @@ package main
 
 import (
 	"context"
-	"fmt"
-	"log"
+	"flag"
+	"log/slog"
+	"math"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
-	"github.com/thomas-sabu-cs/sentinel-stream/internal/transport" 
-	"github.com/shirou/gopsutil/v3/cpu"
-	"github.com/shirou/gopsutil/v3/mem"
-)
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
-type Metric struct {
-	Timestamp int64   `json:"timestamp"`
-	CPUUsage  float64 `json:"cpu_usage"`
-	MemUsage  float64 `json:"mem_usage"`
-}
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/collectors"
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/config"
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/logging"
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/tracing"
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/transport"
+)
 
 func main() {
-	fmt.Println("🚀 Sentinel Agent starting...")
+	format := flag.String("format", transport.FormatJSON, "wire format: json, binary, proto or msgpack")
+	channel := flag.String("channel", "metrics", "Redis channel to publish to")
+	dualPublish := flag.Bool("dual-publish", false, "during a format migration, publish bare JSON to -channel and binary to -binary-channel (doubles Redis publish load)")
+	binaryChannel := flag.String("binary-channel", "metrics:binary", "Redis channel for the binary copy in -dual-publish mode")
+	collectorList := flag.String("collectors", "cpu,mem", "comma-separated collectors to run each interval; available: "+strings.Join(collectors.Names(), ", "))
+	require := flag.String("require", "cpu,mem", "comma-separated collectors that must work; the agent exits at startup if one fails")
+	gpu := flag.Bool("gpu", false, "collect per-GPU utilization and memory via nvidia-smi, or NVML in builds with -tags nvml (skipped without an NVIDIA driver); same as adding gpu to -collectors")
+	diskIO := flag.Bool("diskio", false, "collect per-device disk read/write bytes per second; same as adding diskio to -collectors")
+	binaryTags := flag.Bool("binary-tags", true, "with the binary format, send host, sequence number and -tags in a tagged frame; false sends the fixed 37-byte frame without them")
+	sendTime := flag.Bool("send-time", true, "stamp samples with the publish time so the server can measure end-to-end latency; false saves 8 bytes (binary) or a JSON field per sample")
+	extraTags := flag.String("tags", "", "comma-separated key=value tags sent with every sample, e.g. role=db,team=infra")
+	k8sTags := flag.Bool("k8s-tags", false, "tag samples with pod, namespace and node from POD_NAME, POD_NAMESPACE and NODE_NAME (Kubernetes downward API)")
+	maxFailures := flag.Int("max-failures", 0, "exit with status 1 after this many consecutive failed collections so an orchestrator restarts the agent; 0 disables")
+	bufferSize := flag.Int("buffer", 1800, "samples to hold while Redis is unreachable (one per channel per tick); the oldest are dropped beyond this")
+	drainParallelism := flag.Int("drain-parallelism", 1, "pipelines in flight when sending a backlog after an outage; above 1 drains faster but samples from different chunks may arrive out of order")
+	bufferFile := flag.String("buffer-file", "", "if set, save unsent samples to this file on shutdown and send them after the next start")
+	spoolPath := flag.String("spool", "", "if set, append unsent samples to this file as they fail (synced) and replay it oldest-first once publishing recovers; survives crashes, replaces -buffer-file")
+	spoolMaxMB := flag.Int("spool-max-mb", 64, "size cap for -spool in MiB; samples beyond it are dropped")
+	statusAddr := flag.String("status-addr", "", "if set (e.g. localhost:6061), serve the effective collector configuration as JSON on /config and collection and publish health on /healthz")
+	token := flag.String("token", os.Getenv("AGENT_TOKEN"), "pre-shared token announced on -control-channel for servers that set AGENT_TOKENS (default $AGENT_TOKEN)")
+	transportKind := flag.String("transport", transport.KindRedis, "message broker to publish to: redis, redis-streams, nats or kafka")
+	stream := flag.Bool("stream", false, "publish to Redis Streams (XADD) instead of Pub/Sub, for servers started with -stream; same as -transport=redis-streams")
+	brokerAddr := flag.String("addr", "", "broker address (default localhost:6379 for redis and redis-streams, localhost:4222 for nats, localhost:9092 for kafka)")
+	redisFlags := transport.AddRedisFlags(flag.CommandLine, "addr")
+	controlChannel := flag.String("control-channel", transport.DefaultControlChannel, "Redis channel for the -token handshake")
+	interval := flag.Duration("interval", 2*time.Second, "how often to collect and publish a sample")
+	batchSize := flag.Int("batch", 1, "publish samples in batches of this many, as one multi frame per channel, to cut broker publishes; 1 publishes each sample as it is collected")
+	batchInterval := flag.Duration("batch-interval", 0, "with -batch, also publish a partial batch once its first sample is this old (checked every -interval); 0 waits for a full batch")
+	configPath := flag.String("config", "", "YAML or TOML config file with an agent section; flags given on the command line override it")
+	otlpEndpoint := flag.String("otlp-endpoint", os.Getenv(tracing.EndpointEnv), "if set (e.g. http://localhost:4318), trace samples from collection to the server's writes and export the spans here over OTLP/HTTP (default $"+tracing.EndpointEnv+")")
+	traceRatio := flag.Float64("trace-sample-ratio", 1, "with -otlp-endpoint, the share of samples to trace, from 0 to 1")
+	logFlags := logging.AddFlags(flag.CommandLine)
+	collectors.AddFlags(flag.CommandLine)
+	flag.Parse()
+	if *configPath != "" {
+		file, err := config.Load(*configPath)
+		if err != nil {
+			logging.Fatal("Invalid -config", "err", err)
+		}
+		settings := file.Settings(file.Agent, "transport", "addr", "channel")
+		if os.Getenv("AGENT_TOKEN") != "" {
+			delete(settings, "token") // the environment wins over the file, as for the server
+		}
+		if err := config.Apply(flag.CommandLine, settings); err != nil {
+			logging.Fatal("Invalid -config", "err", err)
+		}
+	}
+	if err := logFlags.Setup("agent"); err != nil {
+		logging.Fatal("Invalid logging flags", "err", err)
+	}
+	if *interval <= 0 {
+		logging.Fatal("-interval must be positive")
+	}
+	if *batchSize < 1 || *batchInterval < 0 {
+		logging.Fatal("-batch must be positive and -batch-interval not negative")
+	}
+
+	slog.Info("Sentinel Agent starting")
+	stopTracing, err := tracing.Setup(context.Background(), "sentinel-agent", *otlpEndpoint, *traceRatio)
+	if err != nil {
+		logging.Fatal("Invalid -otlp-endpoint or -trace-sample-ratio", "err", err)
+	}
+	// flushTraces exports the spans still queued before the agent exits.
+	flushTraces := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := stopTracing(ctx); err != nil {
+			slog.Warn("Exporting the last spans failed", "err", err)
+		}
+	}
+	defer flushTraces()
+	tracer := tracing.Tracer("agent")
+
+	// Each target is one (channel, encoder) pair; dual-publish just adds a second one.
+	var targets []publishTarget
+	if *dualPublish {
+		targets = []publishTarget{
+			{channel: *channel, format: transport.FormatJSON, enc: transport.JSONEncoder{Bare: true}},
+			{channel: *binaryChannel, format: transport.FormatBinary, enc: transport.BinaryEncoder{Compact: !*sendTime, Tagged: *binaryTags}},
+		}
+	} else {
+		enc, err := transport.NewEncoder(*format)
+		if err != nil {
+			logging.Fatal("Invalid -format", "err", err)
+		}
+		if *format == transport.FormatBinary {
+			enc = transport.BinaryEncoder{Compact: !*sendTime, Tagged: *binaryTags}
+		}
+		targets = []publishTarget{{channel: *channel, format: *format, enc: enc}}
+	}
+	names := splitList(*collectorList)
+	if *gpu {
+		names = append(names, "gpu")
+	}
+	if *diskIO {
+		names = append(names, "diskio")
+	}
+	required, err := parseRequired(*require, names)
+	if err != nil {
+		logging.Fatal("Invalid -require", "err", err)
+	}
+	enabled, err := enableCollectors(names, required)
+	if err != nil {
+		logging.Fatal("Invalid -collectors", "err", err)
+	}
+	// Fail fast on platforms where a required collector isn't supported,
+	// instead of publishing half-populated metrics forever.
+	if _, err := collectMetrics(context.Background(), enabled, required); err != nil {
+		logging.Fatal("Required collector unavailable", "err", err)
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		slog.Warn("Could not resolve hostname", "err", err)
+	}
+	// Sequence numbers start at the boot time in nanoseconds so a restarted agent
+	// never reuses numbers the server's dedup window has already seen.
+	seq := uint64(time.Now().UnixNano())
+	tags, err := parseTags(*extraTags)
+	if err != nil {
+		logging.Fatal("Invalid -tags", "err", err)
+	}
+	if *k8sTags {
+		k8s := kubernetesTags()
+		if len(k8s) == 0 {
+			slog.Warn("-k8s-tags set but POD_NAME, POD_NAMESPACE and NODE_NAME are empty; sending no k8s tags")
+		}
+		for k, v := range k8s {
+			if tags == nil {
+				tags = make(map[string]string)
+			}
+			tags[k] = v
+		}
+	}
+
+	if *stream {
+		if *transportKind != transport.KindRedis && *transportKind != transport.KindRedisStreams {
+			logging.Fatal("-stream needs -transport=redis", "transport", *transportKind)
+		}
+		*transportKind = transport.KindRedisStreams
+	}
+	if *brokerAddr == "" {
+		*brokerAddr = transport.DefaultAddr(*transportKind)
+	}
+	cfg := newEffectiveConfig(*transportKind, *brokerAddr, interval.String(), targets, enabled, required)
+	cfg.log()
+	health := newAgentHealth(*interval)
+	if *statusAddr != "" {
+		go serveStatus(*statusAddr, cfg, health)
+	}
+
+	// 1. Connect to the broker (Redis in our Docker setup)
+	brokerOpts := transport.Options{Key: hostname}
+	if err := redisFlags.Apply(&brokerOpts); err != nil {
+		logging.Fatal("Invalid Redis flags", "err", err)
+	}
+	tr, err := transport.New(*transportKind, *brokerAddr, brokerOpts)
+	if err != nil {
+		logging.Fatal("Invalid -transport", "err", err)
+	}
+	defer tr.Close()
+	if *bufferSize <= 0 {
+		logging.Fatal("-buffer must be positive")
+	}
+	if *drainParallelism <= 0 {
+		logging.Fatal("-drain-parallelism must be positive")
+	}
+	buf := newRetryBuffer(*bufferSize, *drainParallelism)
+	if *bufferFile != "" {
+		n, err := buf.load(*bufferFile)
+		if err != nil {
+			slog.Warn("Restoring the buffer file failed", "file", *bufferFile, "err", err)
+		}
+		if n > 0 {
+			slog.Info("Restored buffered samples", "samples", n, "file", *bufferFile)
+		}
+	}
+	var sp *spool
+	if *spoolPath != "" {
+		if *bufferFile != "" {
+			logging.Fatal("-spool and -buffer-file are mutually exclusive")
+		}
+		if *spoolMaxMB <= 0 {
+			logging.Fatal("-spool-max-mb must be positive")
+		}
+		if sp, err = openSpool(*spoolPath, int64(*spoolMaxMB)<<20); err != nil {
+			logging.Fatal("Invalid -spool", "err", err)
+		}
+		defer sp.close()
+		if sp.pending() {
+			slog.Info("Spool holds samples from a previous run; replaying on the first tick", "file", *spoolPath, "bytes", sp.size-sp.readOff)
+		}
+	}
+	// saveBuffer keeps unsent samples across a restart during a Redis outage.
+	saveBuffer := func() {
+		if *bufferFile == "" {
+			return
+		}
+		if err := buf.save(*bufferFile); err != nil {
+			slog.Error("Saving the buffer file failed", "file", *bufferFile, "err", err)
+		} else if buf.len() > 0 {
+			slog.Info("Saved buffered samples", "samples", buf.len(), "file", *bufferFile)
+		}
+	}
+	if *maxFailures < 0 {
+		logging.Fatal("-max-failures must not be negative")
+	}
+	failures := 0
+	var helloDue time.Time // next handshake; sent on the first tick, then every helloEvery
 
-	// 1. Initialize Redis Client (connecting to our Docker container)
-	// In a real app, "localhost:6379" would come from an environment variable
-	rdb := transport.NewRedisClient("localhost:6379")
-	defer rdb.Close()
+	// pending holds the samples of the current -batch, collected since batchStart.
+	var pending []*transport.Metric
+	var batchStart time.Time
+	// publish encodes the pending samples, queues them behind anything still buffered,
+	// and sends in order. It returns how many messages went out.
+	publish := func(ctx context.Context) int {
+		// One publish span per traced sample, ended once the flush that sends it returns.
+		var spans []trace.Span
+		for _, m := range pending {
+			if sctx, ok := tracing.Extract(ctx, m.TraceParent); ok {
+				_, span := tracer.Start(sctx, "publish", trace.WithSpanKind(trace.SpanKindProducer),
+					trace.WithAttributes(attribute.String("messaging.system", *transportKind)))
+				spans = append(spans, span)
+			}
+		}
+		if len(pending) > 0 {
+			if *sendTime {
+				now := time.Now().UnixNano()
+				for _, m := range pending {
+					m.SendTimeUnixNano = now
+				}
+			}
+			for _, tgt := range targets {
+				payloads := make([][]byte, 0, len(pending))
+				for _, m := range pending {
+					payload, err := tgt.enc.Encode(m)
+					if err != nil {
+						slog.Error("Encoding failed", "channel", tgt.channel, "format", tgt.format, "err", err)
+						continue
+					}
+					payloads = append(payloads, payload)
+				}
+				switch {
+				case len(payloads) == 1:
+					buf.add(transport.Message{Channel: tgt.channel, Payload: payloads[0]})
+				case len(payloads) > 1:
+					buf.add(transport.Message{Channel: tgt.channel, Payload: transport.EncodeMultiFrame(payloads)})
+				}
+			}
+			pending = pending[:0]
+		}
+		var sent int
+		var err error
+		if sp != nil {
+			sent, err = sp.flush(ctx, tr, buf)
+			if err != nil {
+				slog.Warn("Publish failed", "transport", *transportKind, "err", err, "spooled_bytes", sp.size-sp.readOff, "dropped", sp.dropped)
+			}
+			health.published(err, sp.size-sp.readOff)
+		} else {
+			sent, err = buf.flush(ctx, tr)
+			if err != nil {
+				slog.Warn("Publish failed", "transport", *transportKind, "err", err, "buffered", buf.len(), "dropped", buf.dropped)
+			}
+			health.published(err, int64(buf.len()))
+		}
+		for _, span := range spans {
+			tracing.End(span, err)
+		}
+		return sent
+	}
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 
-	ticker := time.NewTicker(2 * time.Second)
+	ticker := time.NewTicker(*interval)
 	defer ticker.Stop()
 
 	// Context is used in Go to handle timeouts and cancellations
@@ -40,39 +306,71 @@ func main() {
 	for {
 		select {
 		case <-sigChan:
-			fmt.Println("\n🛑 Gracefully shutting down...")
+			slog.Info("Gracefully shutting down")
+			if len(pending) > 0 {
+				publish(ctx)
+			}
+			saveBuffer()
 			return
 
 		case t := <-ticker.C:
-			m, err := collectMetrics()
+			cctx, span := tracer.Start(ctx, "collect")
+			m, err := collectMetrics(cctx, enabled, required)
+			tracing.End(span, err)
+			health.collected(err)
 			if err != nil {
-				log.Printf("Error collecting: %v", err)
+				slog.Error("Collection failed", "err", err)
+				failures++
+				if *maxFailures > 0 && failures >= *maxFailures {
+					slog.Error("Collection failed too often in a row; exiting for restart", "failures", failures)
+					if len(pending) > 0 {
+						publish(ctx)
+					}
+					saveBuffer()
+					tr.Close()
+					flushTraces()
+					os.Exit(1)
+				}
 				continue
 			}
+			failures = 0
+			seq++
+			m.Host, m.Seq, m.Tags = hostname, seq, tags
+			m.TraceParent = tracing.Inject(cctx)
 
-			// 2. Publish to Redis
-			err = rdb.PublishMetric(ctx, "metrics", m)
-			if err != nil {
-				log.Printf("Error publishing to Redis: %v", err)
-			} else {
-				fmt.Printf("[%s] Sent to Redis: CPU: %.2f%% | MEM: %.2f%%\n", t.Format("15:04:05"), m.CPUUsage, m.MemUsage)
+			// Announce ourselves before the sample so a server enforcing AGENT_TOKENS
+			// accepts it; on failure the hello is retried next tick.
+			if *token != "" && !t.Before(helloDue) {
+				hello := transport.Hello{Host: hostname, Token: *token}
+				if err := transport.PublishJSON(ctx, tr, *controlChannel, hello); err != nil {
+					slog.Warn("Sending hello failed", "channel", *controlChannel, "err", err)
+				} else {
+					helloDue = t.Add(helloEvery)
+				}
+			}
+
+			// 2. Add to the batch; once it is full or old enough, publish it
+			if len(pending) == 0 {
+				batchStart = t
+			}
+			pending = append(pending, m)
+			if len(pending) < *batchSize && (*batchInterval == 0 || t.Sub(batchStart) < *batchInterval) {
+				continue
+			}
+			if sent := publish(ctx); sent > 0 {
+				slog.Info("Published", "messages", sent, "cpu", math.Round(m.CPUUsage*100)/100, "mem", math.Round(m.MemUsage*100)/100)
 			}
 		}
 	}
 }
 
-func collectMetrics() (*Metric, error) {
-	cpuPercent, err := cpu.Percent(0, false)
-	if err != nil {
-		return nil, err
-	}
-	vMem, err := mem.VirtualMemory()
-	if err != nil {
-		return nil, err
-	}
-	return &Metric{
-		Timestamp: time.Now().Unix(),
-		CPUUsage:  cpuPercent[0],
-		MemUsage:  vMem.UsedPercent,
-	}, nil
+// helloEvery is how often the agent repeats its -token handshake, so servers that start
+// or restart after it still learn about it.
+const helloEvery = 30 * time.Second
+
+// publishTarget is one channel the agent publishes to, with the encoding used there.
+type publishTarget struct {
+	channel string
+	format  string
+	enc     transport.Encoder
 }
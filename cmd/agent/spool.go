@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/transport"
+)
+
+// spool is the -spool write-ahead buffer: samples that can't be published are appended
+// to a file (in the -buffer-file record format) and synced before the agent moves on,
+// so an outage longer than the in-memory buffer, or a crash or power loss during one,
+// loses nothing. Once publishing works again the file is replayed oldest-first, ahead
+// of new samples, and truncated when it has all been sent.
+//
+// The file is append-only: when it reaches max bytes, further samples are dropped (and
+// counted) rather than the oldest. A record cut short by a crash mid-append is cut off
+// when the spool is opened again, so later samples are appended after the last whole
+// one. The replay position is kept in memory only, so an
+// agent restarted mid-replay sends the already replayed part again; run the server with
+// DEDUP_WINDOW to drop those.
+type spool struct {
+	path    string
+	max     int64
+	f       *os.File
+	size    int64 // bytes in the file, including the magic
+	readOff int64 // next record to replay
+	dropped uint64
+}
+
+func openSpool(path string, max int64) (*spool, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	s := &spool{path: path, max: max, f: f, readOff: int64(len(bufferFileMagic))}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if fi.Size() < int64(len(bufferFileMagic)) { // new, or the crash came mid-reset
+		if err := s.reset(); err != nil {
+			f.Close()
+			return nil, err
+		}
+		return s, nil
+	}
+	if err := readBufferMagic(f); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := s.recover(fi.Size()); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return s, nil
+}
+
+// recover sets size to the end of the last whole record in the file, size bytes long,
+// and truncates anything after it: a record a crash cut short, and whatever followed.
+func (s *spool) recover(size int64) error {
+	s.size = size
+	for s.readOff < size {
+		_, sizes, err := s.read(drainChunk)
+		for _, n := range sizes {
+			s.readOff += int64(n)
+		}
+		if err != nil || len(sizes) == 0 {
+			break
+		}
+	}
+	end := s.readOff
+	s.readOff = int64(len(bufferFileMagic))
+	if end == size {
+		return nil
+	}
+	slog.Warn("Spool ends in an unreadable record; truncating it", "file", s.path, "kept_bytes", end, "dropped_bytes", size-end)
+	if err := s.f.Truncate(end); err != nil {
+		return err
+	}
+	s.size = end
+	return s.f.Sync()
+}
+
+// pending reports whether there are samples waiting to be replayed.
+func (s *spool) pending() bool { return s.readOff < s.size }
+
+// reset truncates the file to just its magic.
+func (s *spool) reset() error {
+	if err := s.f.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := s.f.WriteAt(bufferFileMagic, 0); err != nil {
+		return err
+	}
+	s.size, s.readOff = int64(len(bufferFileMagic)), int64(len(bufferFileMagic))
+	return s.f.Sync()
+}
+
+// append writes msgs at the end of the file and syncs it.
+func (s *spool) append(msgs []transport.Message) error {
+	if _, err := s.f.Seek(s.size, io.SeekStart); err != nil {
+		return err
+	}
+	w := bufio.NewWriter(s.f)
+	written := int64(0)
+	for _, m := range msgs {
+		n := int64(bufferRecordHeader + len(m.Channel) + len(m.Payload))
+		if s.size+written+n > s.max {
+			s.dropped++
+			continue
+		}
+		writeBufferRecord(w, m)
+		written += n
+	}
+	if err := w.Flush(); err != nil {
+		s.f.Truncate(s.size) // drop a partial record; it would hide the next appends
+		return err
+	}
+	s.size += written
+	return s.f.Sync()
+}
+
+// replay publishes spooled samples in drainChunk pipelines, oldest first, until the
+// file is empty or a chunk fails. It returns how many were sent.
+func (s *spool) replay(ctx context.Context, tr transport.Transport) (int, error) {
+	sent := 0
+	for s.pending() {
+		chunk, sizes, readErr := s.read(drainChunk)
+		if len(chunk) > 0 {
+			errs := tr.PublishBatch(ctx, chunk)
+			for i, e := range errs {
+				if e != nil {
+					// Resume at the first failure; anything after it that did go out
+					// is sent again (DEDUP_WINDOW drops it on the server).
+					return sent, e
+				}
+				s.readOff += int64(sizes[i])
+				sent++
+			}
+		}
+		if readErr != nil {
+			// The records before the bad one are sent; nothing after it can be
+			// trusted, so start over rather than stall.
+			slog.Error("Spool record unreadable; discarding the rest", "file", s.path, "err", readErr)
+			return sent, s.reset()
+		}
+	}
+	return sent, s.reset()
+}
+
+// read returns up to n records from the replay position, with their sizes. If it hits
+// a bad record it returns the whole records before it along with the error.
+func (s *spool) read(n int) ([]transport.Message, []int, error) {
+	r := bufio.NewReader(io.NewSectionReader(s.f, s.readOff, s.size-s.readOff))
+	var msgs []transport.Message
+	var sizes []int
+	for len(msgs) < n {
+		m, size, err := readBufferRecord(r)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return msgs, sizes, err
+		}
+		msgs = append(msgs, m)
+		sizes = append(sizes, size)
+	}
+	return msgs, sizes, nil
+}
+
+// flush replays the spool, then publishes what is in buf. Whatever couldn't be sent is
+// moved from buf to the end of the spool, so buf is empty afterwards and the spool
+// holds every unsent sample in order. It returns how many samples were sent.
+func (s *spool) flush(ctx context.Context, tr transport.Transport, buf *retryBuffer) (int, error) {
+	sent, err := s.replay(ctx, tr)
+	if err == nil {
+		var n int
+		n, err = buf.flush(ctx, tr)
+		sent += n
+	}
+	if buf.len() > 0 {
+		if serr := s.append(buf.pending); serr != nil {
+			slog.Error("Spool append failed; keeping samples in memory", "file", s.path, "samples", buf.len(), "err", serr)
+			return sent, err
+		}
+		clear(buf.pending)
+		buf.pending = buf.pending[:0]
+	}
+	return sent, err
+}
+
+func (s *spool) close() error {
+	return s.f.Close()
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/transport"
+)
+
+// recordingTransport stores every message published to it.
+type recordingTransport struct {
+	published []string
+}
+
+func (t *recordingTransport) Publish(_ context.Context, channel string, payload []byte) error {
+	t.published = append(t.published, string(payload))
+	return nil
+}
+
+func (t *recordingTransport) PublishBatch(_ context.Context, msgs []transport.Message) []error {
+	for _, m := range msgs {
+		t.published = append(t.published, string(m.Payload))
+	}
+	return make([]error, len(msgs))
+}
+
+func (*recordingTransport) Subscribe(context.Context, ...string) transport.Subscription { return nil }
+func (*recordingTransport) Close() error                                                { return nil }
+
+func spoolMessages(payloads ...string) []transport.Message {
+	msgs := make([]transport.Message, len(payloads))
+	for i, p := range payloads {
+		msgs[i] = transport.Message{Channel: "metrics", Payload: []byte(p)}
+	}
+	return msgs
+}
+
+func openTestSpool(t *testing.T, path string) *spool {
+	t.Helper()
+	s, err := openSpool(path, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.close() })
+	return s
+}
+
+func replayAll(t *testing.T, s *spool) []string {
+	t.Helper()
+	tr := &recordingTransport{}
+	if _, err := s.replay(context.Background(), tr); err != nil {
+		t.Fatal(err)
+	}
+	return tr.published
+}
+
+func TestSpoolReplaysInOrder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spool")
+	s := openTestSpool(t, path)
+	if err := s.append(spoolMessages("a", "b")); err != nil {
+		t.Fatal(err)
+	}
+	s.close()
+
+	// Reopened, as after a restart.
+	s = openTestSpool(t, path)
+	if err := s.append(spoolMessages("c")); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := replayAll(t, s), []string{"a", "b", "c"}; !slices.Equal(got, want) {
+		t.Errorf("replayed %q, want %q", got, want)
+	}
+	if s.pending() {
+		t.Error("spool still pending after a full replay")
+	}
+}
+
+func TestSpoolTruncatesPartialRecordOnOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spool")
+	s := openTestSpool(t, path)
+	if err := s.append(spoolMessages("a", "b")); err != nil {
+		t.Fatal(err)
+	}
+	whole := s.size
+	s.close()
+
+	// A crash halfway through appending "c": only part of its record reached the disk.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte{0, 7, 0, 0, 0, 1, 0xde}); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	s = openTestSpool(t, path)
+	if s.size != whole {
+		t.Errorf("size after reopening = %d, want %d (the partial record cut off)", s.size, whole)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Size() != whole {
+		t.Errorf("file is %v bytes (%v), want %d", fi.Size(), err, whole)
+	}
+	// Appends go after the last whole record, so they are replayed.
+	if err := s.append(spoolMessages("d")); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := replayAll(t, s), []string{"a", "b", "d"}; !slices.Equal(got, want) {
+		t.Errorf("replayed %q, want %q", got, want)
+	}
+}
+
+func TestSpoolReplaysRecordsBeforeCorruptOne(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spool")
+	s := openTestSpool(t, path)
+	if err := s.append(spoolMessages("a", "b")); err != nil {
+		t.Fatal(err)
+	}
+	good := s.size
+	if err := s.append(spoolMessages("c", "d")); err != nil {
+		t.Fatal(err)
+	}
+	// Flip a payload byte of "c" on disk: its checksum no longer matches. The spool
+	// stays open, so this is found during replay, in the same chunk as "a" and "b".
+	if _, err := s.f.WriteAt([]byte("x"), good+bufferRecordHeader+int64(len("metrics"))); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := replayAll(t, s), []string{"a", "b"}; !slices.Equal(got, want) {
+		t.Errorf("replayed %q, want %q", got, want)
+	}
+	if s.pending() {
+		t.Error("spool still pending after discarding the corrupt rest")
+	}
+}
+
+func TestSpoolResetsMissingMagic(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spool")
+	// A crash between truncating and writing the magic again.
+	if err := os.WriteFile(path, bufferFileMagic[:3], 0o600); err != nil {
+		t.Fatal(err)
+	}
+	s := openTestSpool(t, path)
+	if s.pending() {
+		t.Error("pending after opening an empty spool")
+	}
+	if err := s.append(spoolMessages("1")); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := replayAll(t, s), []string{"1"}; !slices.Equal(got, want) {
+		t.Errorf("replayed %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/buildinfo"
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/collectors"
+)
+
+// collectorStatus is one enabled collector as reported at startup and on /config.
+type collectorStatus struct {
+	Name     string            `json:"name"`
+	Required bool              `json:"required"`
+	Settings map[string]string `json:"settings,omitempty"`
+}
+
+// targetStatus is one channel the agent publishes to and its wire format.
+type targetStatus struct {
+	Channel string `json:"channel"`
+	Format  string `json:"format"`
+}
+
+// effectiveConfig is what the agent actually runs with after flags are applied, so
+// partial data can be traced back to a collector that was never enabled.
+type effectiveConfig struct {
+	Build      buildinfo.Info    `json:"build"`
+	Transport  string            `json:"transport"`
+	Addr       string            `json:"addr"`
+	Interval   string            `json:"interval"`
+	Targets    []targetStatus    `json:"targets"`
+	Collectors []collectorStatus `json:"collectors"`
+}
+
+func newEffectiveConfig(transportKind, addr, interval string, targets []publishTarget, enabled []collectors.Collector, required map[string]bool) effectiveConfig {
+	cfg := effectiveConfig{Build: buildinfo.Get(), Transport: transportKind, Addr: addr, Interval: interval}
+	for _, t := range targets {
+		cfg.Targets = append(cfg.Targets, targetStatus{Channel: t.channel, Format: t.format})
+	}
+	for _, c := range enabled {
+		cfg.Collectors = append(cfg.Collectors, collectorStatus{Name: c.Name(), Required: required[c.Name()], Settings: collectors.Settings(c)})
+	}
+	return cfg
+}
+
+// log logs the effective configuration: one record per target and per collector.
+func (cfg effectiveConfig) log() {
+	slog.Info("Build", "version", cfg.Build.Version, "commit", cfg.Build.Commit, "go", cfg.Build.GoVersion)
+	for _, t := range cfg.Targets {
+		slog.Info("Publishing", "transport", cfg.Transport, "addr", cfg.Addr, "interval", cfg.Interval, "channel", t.Channel, "format", t.Format)
+	}
+	for _, c := range cfg.Collectors {
+		args := []any{"collector", c.Name, "required", c.Required}
+		if len(c.Settings) > 0 {
+			var settings []any
+			for _, k := range slices.Sorted(maps.Keys(c.Settings)) {
+				settings = append(settings, k, c.Settings[k])
+			}
+			args = append(args, slog.Group("settings", settings...))
+		}
+		slog.Info("Collector", args...)
+	}
+}
+
+// agentHealth is what GET /healthz reports: whether the agent is collecting, and
+// whether its publishes are getting through. The main loop records, the status server
+// reads.
+type agentHealth struct {
+	stallAfter time.Duration
+
+	mu              sync.Mutex
+	lastCollect     time.Time // last successful collection; the start time before one
+	collectErr      string
+	lastPublish     time.Time // last publish that got everything out
+	publishErr      string
+	publishFailures int   // consecutive
+	backlog         int64 // samples buffered, or bytes spooled with -spool
+}
+
+// minStallAfter is the shortest gap in collections /healthz calls stalled. A publish
+// can hold up the loop for several seconds while the client re-dials a broker that is
+// down, and that is degraded, not stuck.
+const minStallAfter = time.Minute
+
+func newAgentHealth(interval time.Duration) *agentHealth {
+	return &agentHealth{stallAfter: max(3*interval, minStallAfter), lastCollect: time.Now()}
+}
+
+func (h *agentHealth) collected(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err != nil {
+		h.collectErr = err.Error()
+		return
+	}
+	h.lastCollect, h.collectErr = time.Now(), ""
+}
+
+func (h *agentHealth) published(err error, backlog int64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.backlog = backlog
+	if err != nil {
+		h.publishErr = err.Error()
+		h.publishFailures++
+		return
+	}
+	h.lastPublish, h.publishErr, h.publishFailures = time.Now(), "", 0
+}
+
+// handler serves GET /healthz as JSON. status is "down", with 503, once nothing has
+// been collected for three intervals (at least minStallAfter), since then the agent is
+// stuck or every collection fails; "degraded" while publishes fail and samples are held
+// back for retry; "ok" otherwise.
+func (h *agentHealth) handler(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	resp := struct {
+		Status          string     `json:"status"`
+		LastCollect     time.Time  `json:"last_collect"`
+		CollectError    string     `json:"collect_error,omitempty"`
+		LastPublish     *time.Time `json:"last_publish,omitempty"` // nil before the first
+		PublishError    string     `json:"publish_error,omitempty"`
+		PublishFailures int        `json:"publish_failures,omitempty"`
+		Backlog         int64      `json:"backlog,omitempty"`
+	}{"ok", h.lastCollect, h.collectErr, nil, h.publishErr, h.publishFailures, h.backlog}
+	if !h.lastPublish.IsZero() {
+		t := h.lastPublish
+		resp.LastPublish = &t
+	}
+	stalled := time.Since(h.lastCollect) > h.stallAfter
+	h.mu.Unlock()
+	switch {
+	case stalled:
+		resp.Status = "down"
+	case resp.PublishFailures > 0:
+		resp.Status = "degraded"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if stalled {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// serveStatus exposes the effective configuration as JSON on GET /config, and health
+// on GET /healthz.
+func serveStatus(addr string, cfg effectiveConfig, health *agentHealth) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", health.handler)
+	mux.HandleFunc("/config", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(cfg)
+	})
+	slog.Info("Serving /config and /healthz", "addr", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		slog.Error("Status server failed", "err", err)
+	}
+}
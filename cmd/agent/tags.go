@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Downward API env vars and the tag each one becomes. In a DaemonSet they are set with
+// fieldRef (metadata.name, metadata.namespace, spec.nodeName).
+var kubernetesEnv = []struct{ env, tag string }{
+	{"POD_NAME", "pod"},
+	{"POD_NAMESPACE", "namespace"},
+	{"NODE_NAME", "node"},
+}
+
+// kubernetesTags returns a tag for each downward API variable that is set; outside
+// Kubernetes it returns nil.
+func kubernetesTags() map[string]string {
+	var tags map[string]string
+	for _, kv := range kubernetesEnv {
+		if v := os.Getenv(kv.env); v != "" {
+			if tags == nil {
+				tags = make(map[string]string, len(kubernetesEnv))
+			}
+			tags[kv.tag] = v
+		}
+	}
+	return tags
+}
+
+// parseTags reads a -tags list of key=value pairs; an empty list yields nil.
+func parseTags(v string) (map[string]string, error) {
+	var tags map[string]string
+	for _, entry := range strings.Split(v, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(entry, "=")
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if !ok || key == "" || value == "" {
+			return nil, fmt.Errorf("tag %q must be key=value", entry)
+		}
+		if tags == nil {
+			tags = make(map[string]string)
+		}
+		tags[key] = value
+	}
+	return tags, nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// A step must beat the best rate so far by this fraction, or the rate has plateaued.
+	autoMinGain = 0.05
+	// A step whose mean publish latency exceeds the single-worker mean by this factor
+	// counts as degraded, even if it is faster.
+	autoLatencyLimit = 2.0
+)
+
+// tuneStep is one measured step of -auto-workers.
+type tuneStep struct {
+	Workers       int     `json:"workers"`
+	RatePerSec    float64 `json:"rate_per_sec"`
+	MeanLatencyUs int64   `json:"mean_latency_us"`
+}
+
+// tuneCounters are the running totals the workers update atomically.
+type tuneCounters struct {
+	sent      *uint64 // metrics
+	latencyNs *uint64 // sum of publish latencies
+	publishes *uint64
+}
+
+// autoTune starts one worker, then doubles the count every step (up to maxWorkers) for
+// as long as throughput improves by autoMinGain without publish latency degrading. It
+// returns the best step (the recommended -workers) and every step measured. spawn starts
+// worker id; the workers keep running until ctx is cancelled.
+func autoTune(ctx context.Context, maxWorkers int, step time.Duration, spawn func(id int), c tuneCounters) (tuneStep, []tuneStep) {
+	var best tuneStep
+	var steps []tuneStep
+	var baseLatency int64
+	running, target := 0, 1
+	for {
+		for ; running < target; running++ {
+			spawn(running)
+		}
+		sent0, lat0, pub0 := atomic.LoadUint64(c.sent), atomic.LoadUint64(c.latencyNs), atomic.LoadUint64(c.publishes)
+		start := time.Now()
+		select {
+		case <-ctx.Done():
+			return best, steps
+		case <-time.After(step):
+		}
+		s := tuneStep{Workers: running, RatePerSec: float64(atomic.LoadUint64(c.sent)-sent0) / time.Since(start).Seconds()}
+		if pubs := atomic.LoadUint64(c.publishes) - pub0; pubs > 0 {
+			s.MeanLatencyUs = int64(atomic.LoadUint64(c.latencyNs)-lat0) / int64(pubs) / 1e3
+		}
+		steps = append(steps, s)
+		slog.Info("Auto-workers step", "workers", s.Workers, "rate", math.Round(s.RatePerSec), "mean_publish_us", s.MeanLatencyUs)
+
+		switch {
+		case len(steps) == 1:
+			best, baseLatency = s, max(s.MeanLatencyUs, 1)
+		case s.RatePerSec < best.RatePerSec*(1+autoMinGain):
+			slog.Info("Auto-workers: throughput plateaued", "workers", best.Workers)
+			return best, steps
+		case float64(s.MeanLatencyUs) > float64(baseLatency)*autoLatencyLimit:
+			slog.Info("Auto-workers: publish latency degraded beyond this", "workers", best.Workers)
+			return best, steps
+		default:
+			best = s
+		}
+		if running >= maxWorkers {
+			slog.Info("Auto-workers: reached the -workers limit", "workers", maxWorkers)
+			return best, steps
+		}
+		target = min(running*2, maxWorkers)
+	}
+}
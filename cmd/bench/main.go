@@ -2,10 +2,9 @@ package main
 
 import (
 	"context"
-	"encoding/binary"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"math"
 	"math/rand"
 	"os"
@@ -15,30 +14,122 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/buildinfo"
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/config"
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/logging"
 	"github.com/thomas-sabu-cs/sentinel-stream/internal/transport"
 )
 
-type Metric struct {
-	Timestamp        int64   `json:"timestamp"`
-	CPUUsage         float64 `json:"cpu_usage"`
-	MemUsage         float64 `json:"mem_usage"`
-	SendTimeUnixNano int64   `json:"send_time_unix_nano"`
-}
-
 func main() {
 	var (
 		workers  = flag.Int("workers", 32, "number of concurrent publisher goroutines")
 		duration = flag.Duration("duration", 60*time.Second, "how long to run the benchmark")
-		redis    = flag.String("redis", "localhost:6379", "Redis address")
+		redis    = flag.String("redis", "", "broker address (default localhost:6379 for redis, localhost:4222 for nats, localhost:9092 for kafka)")
+		transportKind = flag.String("transport", transport.KindRedis, "message broker to publish to: redis, redis-streams, nats or kafka; -redis is its address")
 		channel  = flag.String("channel", "metrics", "Redis Pub/Sub channel")
-		useBinary = flag.Bool("binary", true, "use binary protocol (32 bytes) instead of JSON for lower alloc")
+		redisFlags = transport.AddRedisFlags(flag.CommandLine, "redis")
+		logFlags   = logging.AddFlags(flag.CommandLine)
+		useBinary = flag.Bool("binary", true, "use binary protocol (37-byte frames) instead of JSON for lower alloc")
+		format    = flag.String("format", "", "wire format: json, binary, proto or msgpack (overrides -binary)")
+		out       = flag.String("out", "", "write a summary of the run to this file: JSON (e.g. results.json), or CSV for a .csv name, appended as a row if the file already has the same header")
+		webhook   = flag.String("report-webhook", "", "POST the JSON summary (as -out writes it) to this URL after the run; failures are logged only")
+		pack      = flag.Int("pack", 1, "pack this many binary metrics into each published message (binary format only)")
+		rate      = flag.Float64("rate", 0, "target messages per second across all workers, paced by a shared schedule; 0 publishes as fast as possible. With -pattern, the peak (or mean) rate")
+		pipeline  = flag.Int("pipeline", 1, "send this many messages per round-trip from each worker (a Redis pipeline, one NATS flush, one Kafka batch); 1 publishes one at a time")
+
+		autoWorkers = flag.Bool("auto-workers", false, "start with 1 worker and double every -auto-step while throughput improves and latency holds, up to -workers; reports the best count")
+		autoStep    = flag.Duration("auto-step", 5*time.Second, "how long each -auto-workers step is measured")
+
+		measure     = flag.Bool("measure", false, "subscribe to -echo-channel and report round-trip latency from publish until the server has written each sample (needs a server with ECHO_CHANNEL)")
+		echoChannel = flag.String("echo-channel", transport.DefaultEchoChannel, "channel the server echoes written samples on, for -measure")
+		measureWait = flag.Duration("measure-wait", 10*time.Second, "with -measure, how long to wait for the last echoes after publishing stops; cover the server's FLUSH_INTERVAL")
+
+		pattern      = flag.String("pattern", patternConstant, "shape of the -rate load: constant, ramp (from -rate-min to -rate over -duration), burst (square wave), sine or poisson (random arrivals averaging -rate)")
+		rateMin      = flag.Float64("rate-min", 0, "with -pattern, where a ramp starts and the low point of a burst or sine wave")
+		period       = flag.Duration("period", defaultPeriod, "length of one -pattern burst or sine cycle")
+		duty         = flag.Float64("duty", defaultDuty, "share of each -pattern burst cycle spent at -rate rather than -rate-min")
+		scenarioPath = flag.String("scenario", "", "YAML or TOML file of phases, each with its own pattern, duration and rates, run in order; replaces -duration, -rate and -pattern")
+
+		configPath = flag.String("config", "", "YAML or TOML config file with a bench section; flags given on the command line override it")
 	)
 	flag.Parse()
+	if *configPath != "" {
+		file, err := config.Load(*configPath)
+		if err != nil {
+			logging.Fatal("Invalid -config", "err", err)
+		}
+		if err := config.Apply(flag.CommandLine, file.Settings(file.Bench, "transport", "redis", "channel")); err != nil {
+			logging.Fatal("Invalid -config", "err", err)
+		}
+	}
+	if err := logFlags.Setup("bench"); err != nil {
+		logging.Fatal("Invalid logging flags", "err", err)
+	}
 
-	log.Printf("Starting load generator with %d workers for %s...\n", *workers, duration.String())
+	if *format == "" {
+		*format = transport.FormatJSON
+		if *useBinary {
+			*format = transport.FormatBinary
+		}
+	}
+	enc, err := transport.NewEncoder(*format)
+	if err != nil {
+		logging.Fatal("Invalid -format", "err", err)
+	}
+	if *pack < 1 || (*pack > 1 && *format != transport.FormatBinary) {
+		logging.Fatal("-pack must be at least 1, and above 1 only with the binary format")
+	}
 
-	rdb := transport.NewRedisClient(*redis)
-	defer rdb.Close()
+	if *pipeline < 1 {
+		logging.Fatal("-pipeline must be at least 1")
+	}
+	if *rate < 0 {
+		logging.Fatal("-rate must not be negative")
+	}
+	// sc is the target rate over the run, nil to publish as fast as possible.
+	var sc scenario
+	switch {
+	case *scenarioPath != "":
+		if sc, err = loadScenario(*scenarioPath); err != nil {
+			logging.Fatal("Invalid -scenario", "err", err)
+		}
+		*duration = sc.duration()
+		for i, p := range sc {
+			slog.Info("Phase", "phase", i+1, "shape", p.String())
+		}
+	case *rate > 0:
+		sc = scenario{{Pattern: *pattern, Duration: *duration, Rate: *rate, RateMin: *rateMin, Period: *period, Duty: *duty}}
+		if err := sc[0].validate(); err != nil {
+			logging.Fatal("Invalid -pattern", "err", err)
+		}
+		slog.Info("Pacing", "shape", sc[0].String())
+	case *pattern != patternConstant:
+		logging.Fatal("-pattern needs -rate")
+	}
+
+	if *autoWorkers && *autoStep <= 0 {
+		logging.Fatal("-auto-step must be positive")
+	}
+	if *autoWorkers && (*scenarioPath != "" || *pattern != patternConstant) {
+		logging.Fatal("-auto-workers needs a steady load; it can't be used with -pattern or -scenario")
+	}
+	build := buildinfo.Get()
+	slog.Info("Build", "version", build.Version, "commit", build.Commit, "go", build.GoVersion)
+	slog.Info("Starting load generator", "workers", *workers, "auto_workers", *autoWorkers, "duration", *duration,
+		"format", *format, "pack", *pack, "pipeline", *pipeline, "channel", *channel)
+
+	if *redis == "" {
+		*redis = transport.DefaultAddr(*transportKind)
+	}
+	var brokerOpts transport.Options
+	if err := redisFlags.Apply(&brokerOpts); err != nil {
+		logging.Fatal("Invalid Redis flags", "err", err)
+	}
+	tr, err := transport.New(*transportKind, *redis, brokerOpts)
+	if err != nil {
+		logging.Fatal("Invalid -transport", "err", err)
+	}
+	defer tr.Close()
 
 	ctx, cancel := context.WithTimeout(context.Background(), *duration)
 	defer cancel()
@@ -47,61 +138,132 @@ func main() {
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 	go func() {
 		<-sigChan
-		log.Println("Signal received, stopping load generator...")
+		slog.Info("Signal received, stopping load generator")
 		cancel()
 	}()
 
 	var (
 		wg        sync.WaitGroup
-		totalSent uint64
+		totalSent uint64 // metrics
+		publishes uint64 // Redis messages
+		latencyNs uint64 // sum of publish latencies, for -auto-workers
+		encodeErrs  uint64 // messages that failed to encode
+		publishErrs uint64 // messages the broker didn't accept
+		pace        *pacer // paces to sc from the start of the run
+		// One slice per worker so recording a sample needs no locking.
+		latencies = make([][]time.Duration, *workers)
 	)
 
 	rand.Seed(time.Now().UnixNano())
 
-	for i := 0; i < *workers; i++ {
+	// nextPayload is one message's worth of mock samples stamped with now.
+	nextPayload := func(now time.Time) ([]byte, error) {
+		if *pack > 1 {
+			batch := make([]*transport.Metric, *pack)
+			for j := range batch {
+				batch[j] = randomMetric(now)
+			}
+			return transport.EncodeBinaryBatch(batch), nil
+		}
+		return enc.Encode(randomMetric(now))
+	}
+
+	spawn := func(i int) {
 		wg.Add(1)
 		go func(id int) {
 			defer wg.Done()
 
+			msgs := make([]transport.Message, 0, *pipeline)
 			for {
 				select {
 				case <-ctx.Done():
 					return
 				default:
+					if pace != nil && pace.wait(ctx, *pipeline) != nil {
+						return
+					}
 					now := time.Now()
-					timestamp := now.Unix()
-					cpu := 20 + 60*rand.Float64()
-					mem := 10 + 70*rand.Float64()
-					sendTimeNano := now.UnixNano()
-
-					if *useBinary {
-						var buf [32]byte
-						binary.LittleEndian.PutUint64(buf[0:8], uint64(timestamp))
-						binary.LittleEndian.PutUint64(buf[8:16], math.Float64bits(cpu))
-						binary.LittleEndian.PutUint64(buf[16:24], math.Float64bits(mem))
-						binary.LittleEndian.PutUint64(buf[24:32], uint64(sendTimeNano))
-						if err := rdb.PublishBytes(context.Background(), *channel, buf[:]); err != nil {
-							log.Printf("worker=%d publish error: %v", id, err)
-							time.Sleep(10 * time.Millisecond)
-							continue
+					msgs = msgs[:0]
+					for len(msgs) < *pipeline {
+						payload, err := nextPayload(now)
+						if err != nil {
+							atomic.AddUint64(&encodeErrs, 1)
+							slog.Error("Encode failed", "worker", id, "err", err)
+							break
+						}
+						msgs = append(msgs, transport.Message{Channel: *channel, Payload: payload})
+					}
+					if len(msgs) == 0 {
+						continue
+					}
+					ok, err := publish(tr, msgs)
+					if err != nil {
+						atomic.AddUint64(&publishErrs, uint64(len(msgs)-ok))
+						slog.Warn("Publish failed", "worker", id, "channel", *channel, "failed", len(msgs)-ok, "of", len(msgs), "err", err)
+						// Back off, but don't hold up wg.Wait() once the run is over.
+						if !backOff(ctx, publishErrorBackoff) {
+							return
 						}
-					} else {
-						m := &Metric{Timestamp: timestamp, CPUUsage: cpu, MemUsage: mem, SendTimeUnixNano: sendTimeNano}
-						if err := rdb.PublishMetric(context.Background(), *channel, m); err != nil {
-							log.Printf("worker=%d publish error: %v", id, err)
-							time.Sleep(10 * time.Millisecond)
+						if ok == 0 {
 							continue
 						}
 					}
 
-					atomic.AddUint64(&totalSent, 1)
+					// Every message in a pipeline waits for the whole round-trip, so that
+					// is the latency recorded for each, once per round-trip for percentiles.
+					took := time.Since(now)
+					latencies[id] = append(latencies[id], took)
+					atomic.AddUint64(&latencyNs, uint64(took)*uint64(ok))
+					atomic.AddUint64(&totalSent, uint64(ok)*uint64(*pack))
+					atomic.AddUint64(&publishes, uint64(ok))
 				}
 			}
 		}(i)
 	}
 
+	// With -measure, listen for echoes before the first publish, on a context that
+	// outlives the run so the last batches' echoes still arrive.
+	var rtt *rttRecorder
+	var stopMeasure context.CancelFunc = func() {}
+	if *measure {
+		var measureCtx context.Context
+		measureCtx, stopMeasure = context.WithCancel(context.Background())
+		defer stopMeasure()
+		rtt = newRTTRecorder(time.Now())
+		sub := tr.Subscribe(measureCtx, *echoChannel)
+		defer sub.Close()
+		go rtt.receive(measureCtx, sub)
+		slog.Info("Measuring round trips from echoes", "channel", *echoChannel)
+	}
+
+	start := time.Now()
+	if sc != nil {
+		pace = newPacer(sc, start)
+	}
+	// With -auto-workers the tuner adds workers itself and ends the run when it settles.
+	type tuneResult struct {
+		best  tuneStep
+		steps []tuneStep
+	}
+	tuned := make(chan tuneResult, 1)
+	if *autoWorkers {
+		go func() {
+			best, steps := autoTune(ctx, *workers, *autoStep, spawn, tuneCounters{sent: &totalSent, latencyNs: &latencyNs, publishes: &publishes})
+			tuned <- tuneResult{best, steps}
+			cancel()
+		}()
+	} else {
+		for i := 0; i < *workers; i++ {
+			spawn(i)
+		}
+	}
 	ticker := time.NewTicker(1 * time.Second)
 	defer ticker.Stop()
+	// With a target rate: messages published by the last tick, when it was, and the
+	// seconds that fell short.
+	var lastPublishes uint64
+	var lastTick time.Duration
+	secondsBelow := 0
 
 loop:
 	for {
@@ -110,12 +272,106 @@ loop:
 			break loop
 		case <-ticker.C:
 			sent := atomic.LoadUint64(&totalSent)
-			log.Printf("Progress: total_sent=%d", sent)
+			if pace == nil {
+				slog.Info("Progress", "total_sent", sent)
+				continue
+			}
+			pubs, now := atomic.LoadUint64(&publishes), time.Since(start)
+			got := float64(pubs-lastPublishes) / (now - lastTick).Seconds()
+			want := sc.expected(lastTick, now) / (now - lastTick).Seconds()
+			lastPublishes, lastTick = pubs, now
+			attained := got / want
+			switch {
+			case want < 1:
+				// A pause or the start of a ramp from zero: nothing to fall short of.
+				slog.Info("Progress", "total_sent", sent, "rate", math.Round(got), "target", math.Round(want))
+			case attained < attainmentFloor:
+				secondsBelow++
+				slog.Warn("Progress below target", "total_sent", sent, "rate", math.Round(got), "target", math.Round(want), "attained_pct", math.Round(100*attained))
+			default:
+				slog.Info("Progress", "total_sent", sent, "rate", math.Round(got), "target", math.Round(want), "attained_pct", math.Round(100*attained))
+			}
 		}
 	}
 
+	var tune tuneResult
+	if *autoWorkers {
+		tune = <-tuned // before Wait: the tuner may still be spawning workers
+	}
 	wg.Wait()
+	elapsed := time.Since(start)
 	sent := atomic.LoadUint64(&totalSent)
 	fmt.Printf("✅ Load generator finished. Total messages sent: %d\n", sent)
+	if rtt != nil {
+		slog.Info("Waiting for echoes", "timeout", *measureWait)
+		rtt.wait(sent, *measureWait)
+		stopMeasure()
+	}
+
+	var all []time.Duration
+	for _, l := range latencies {
+		all = append(all, l...)
+	}
+	ran := *workers
+	if *autoWorkers && len(tune.steps) > 0 {
+		ran = tune.steps[len(tune.steps)-1].Workers
+	}
+	sum := newSummary(ran, *format, *pipeline, elapsed, sent, atomic.LoadUint64(&publishes), all)
+	sum.EncodeErrors, sum.PublishErrors = atomic.LoadUint64(&encodeErrs), atomic.LoadUint64(&publishErrs)
+	if rtt != nil {
+		r := rtt.summary()
+		sum.rttSummary = &r
+		fmt.Printf("Round trip (publish → written → echo): %d of %d samples echoed | p50_us=%d p90_us=%d p99_us=%d p99.9_us=%d max_us=%d\n",
+			r.Samples, sent, r.P50Us, r.P90Us, r.P99Us, r.P999Us, r.MaxUs)
+		if r.Samples == 0 {
+			slog.Warn("No echoes received; is the server running with ECHO_CHANNEL set to this channel?", "channel", *echoChannel)
+		}
+	}
+	if pace != nil {
+		// Over the run, the target is the mean of the scenario's rate.
+		sum.TargetRate, sum.SecondsBelowTarget = sc.expected(0, elapsed)/elapsed.Seconds(), secondsBelow
+		sum.Attainment = sum.PublishRatePerSec / sum.TargetRate
+		fmt.Printf("Target: %.0f messages/sec, attained %.1f%%; %d of %.0f seconds below %.0f%% of target\n",
+			sum.TargetRate, 100*sum.Attainment, secondsBelow, elapsed.Seconds(), 100*attainmentFloor)
+	}
+	if *autoWorkers {
+		sum.AutoWorkers, sum.AutoSteps = tune.best.Workers, tune.steps
+		fmt.Printf("Auto-workers: best -workers=%d (%.0f metrics/sec)\n", tune.best.Workers, tune.best.RatePerSec)
+	}
+	fmt.Printf("Rate: %.0f metrics/sec (%d per publish, %.0f publishes/sec, %d per round-trip) | publish p50_us=%d p90_us=%d p99_us=%d\n",
+		sum.RatePerSec, *pack, sum.PublishRatePerSec, *pipeline, sum.PublishP50Us, sum.PublishP90Us, sum.PublishP99Us)
+	if sum.EncodeErrors > 0 || sum.PublishErrors > 0 {
+		fmt.Printf("Errors: %d encode, %d publish\n", sum.EncodeErrors, sum.PublishErrors)
+	}
+
+	host, _ := os.Hostname()
+	report := runReport{summary: sum, Time: start.UTC(), GitSHA: gitSHA(), Host: host, Build: buildinfo.Get(), Config: make(map[string]string)}
+	flag.VisitAll(func(f *flag.Flag) {
+		if f.Name != "report-webhook" && f.Name != "redis-password" {
+			report.Config[f.Name] = f.Value.String()
+		}
+	})
+	if *out != "" {
+		if err := writeReport(*out, report); err != nil {
+			logging.Fatal("Writing the summary failed", "file", *out, "err", err)
+		}
+		slog.Info("Summary written", "file", *out)
+	}
+	if *webhook != "" {
+		if err := postReport(*webhook, report); err != nil {
+			slog.Error("Posting the summary failed", "url", *webhook, "err", err)
+		} else {
+			slog.Info("Summary posted", "url", *webhook)
+		}
+	}
 }
 
+// randomMetric is one mock sample stamped with now.
+func randomMetric(now time.Time) *transport.Metric {
+	return &transport.Metric{
+		Timestamp:        now.Unix(),
+		CPUUsage:         20 + 60*rand.Float64(),
+		MemUsage:         10 + 70*rand.Float64(),
+		SendTimeUnixNano: now.UnixNano(),
+	}
+}
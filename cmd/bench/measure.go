@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/HdrHistogram/hdrhistogram-go"
+
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/transport"
+)
+
+// rttMax is the longest round trip the histogram records; longer ones are clamped to it.
+const rttMax = time.Minute
+
+// rttRecorder measures round trips with -measure: from the send time the bench stamps
+// on each sample until the server's echo of it arrives, after the batch holding it was
+// written. Both ends are read on the bench's clock. Values are kept in an HDR histogram
+// in microseconds with three significant digits, so the tail is as accurate as the
+// median however many samples there are.
+type rttRecorder struct {
+	since int64 // echoes of samples sent before the run started are ignored
+
+	mu     sync.Mutex
+	hist   *hdrhistogram.Histogram
+	echoed atomic.Uint64
+}
+
+func newRTTRecorder(since time.Time) *rttRecorder {
+	return &rttRecorder{since: since.UnixNano(), hist: hdrhistogram.New(1, rttMax.Microseconds(), 3)}
+}
+
+// receive records the echoes on sub until ctx is done.
+func (r *rttRecorder) receive(ctx context.Context, sub transport.Subscription) {
+	acker, _ := sub.(transport.Acker)
+	for {
+		d, err := sub.Receive(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			time.Sleep(100 * time.Millisecond) // Receive reconnects on the next call
+			continue
+		}
+		now := time.Now().UnixNano()
+		if acker != nil {
+			_ = acker.Ack(ctx, d)
+		}
+		e, err := transport.DecodeEcho([]byte(d.Payload))
+		if err != nil {
+			continue
+		}
+		r.mu.Lock()
+		for _, t := range e.SendTimes {
+			if t < r.since {
+				continue
+			}
+			us := max((now-t)/1e3, 1)
+			_ = r.hist.RecordValue(min(us, rttMax.Microseconds()))
+			r.echoed.Add(1)
+		}
+		r.mu.Unlock()
+	}
+}
+
+// wait returns once want samples have been echoed or timeout has passed.
+func (r *rttRecorder) wait(want uint64, timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for r.echoed.Load() < want && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// rttSummary is the -measure part of the summary.
+type rttSummary struct {
+	Samples uint64 `json:"rtt_samples"`
+	P50Us   int64  `json:"rtt_p50_us"`
+	P90Us   int64  `json:"rtt_p90_us"`
+	P99Us   int64  `json:"rtt_p99_us"`
+	P999Us  int64  `json:"rtt_p999_us"`
+	MaxUs   int64  `json:"rtt_max_us"`
+}
+
+func (r *rttRecorder) summary() rttSummary {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return rttSummary{
+		Samples: uint64(r.hist.TotalCount()),
+		P50Us:   r.hist.ValueAtQuantile(50),
+		P90Us:   r.hist.ValueAtQuantile(90),
+		P99Us:   r.hist.ValueAtQuantile(99),
+		P999Us:  r.hist.ValueAtQuantile(99.9),
+		MaxUs:   r.hist.Max(),
+	}
+}
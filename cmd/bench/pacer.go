@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// attainmentFloor is the share of the target rate below which a second counts as
+// missing it.
+const attainmentFloor = 0.95
+
+// pacer schedules publishes to follow a scenario's target rate. It is shared by all
+// workers, so the rate holds for the run as a whole however many workers there are.
+// Each wait reserves the next slot in the schedule and moves the schedule on by as much
+// time as its messages take at the target rate, so workers are served in turn instead
+// of racing. A schedule that falls behind restarts from now rather than catching up in
+// a burst. With Poisson arrivals, the gap is drawn from an exponential distribution
+// instead.
+type pacer struct {
+	sc    scenario
+	start time.Time
+
+	mu   sync.Mutex
+	next time.Duration // into the run
+}
+
+func newPacer(sc scenario, start time.Time) *pacer {
+	return &pacer{sc: sc, start: start}
+}
+
+// wait reserves the next slot for n messages, blocking until it comes or ctx is done.
+func (p *pacer) wait(ctx context.Context, n int) error {
+	p.mu.Lock()
+	at := max(p.next, time.Since(p.start))
+	// need is how many messages' worth of the target rate must pass before the slot
+	// after this one. Integrating the rate over time handles ramps and pauses, and with
+	// exponential draws gives Poisson arrivals even while the rate changes.
+	need := float64(n)
+	if p.sc.poisson(at) {
+		need = 0
+		for range n {
+			need += rand.ExpFloat64()
+		}
+	}
+	t := at
+	for {
+		r := p.sc.rateAt(t)
+		if r > 0 && r*shapeStep.Seconds() >= need {
+			t += time.Duration(need / r * float64(time.Second))
+			break
+		}
+		need -= r * shapeStep.Seconds()
+		t += shapeStep
+	}
+	p.next = t
+	p.mu.Unlock()
+
+	d := time.Until(p.start.Add(at))
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
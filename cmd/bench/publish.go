@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/transport"
+)
+
+// publishErrorBackoff is how long a worker waits after a failed publish.
+const publishErrorBackoff = 10 * time.Millisecond
+
+// publish sends msgs, all for one channel, in one round-trip, and returns how many the
+// broker accepted and the first error.
+func publish(tr transport.Transport, msgs []transport.Message) (int, error) {
+	if len(msgs) == 1 {
+		if err := tr.Publish(context.Background(), msgs[0].Channel, msgs[0].Payload); err != nil {
+			return 0, err
+		}
+		return 1, nil
+	}
+	var ok int
+	var err error
+	for _, e := range tr.PublishBatch(context.Background(), msgs) {
+		if e == nil {
+			ok++
+		} else if err == nil {
+			err = e
+		}
+	}
+	return ok, err
+}
+
+// backOff waits d after a failed publish. It returns false as soon as ctx is done, so
+// workers stuck on a failing broker don't hold up the end of the run.
+func backOff(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
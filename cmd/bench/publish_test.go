@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/transport"
+)
+
+var errBrokerDown = errors.New("broker down")
+
+// failingTransport fails every publish, calling onPublish first.
+type failingTransport struct {
+	onPublish func()
+}
+
+func (t failingTransport) Publish(context.Context, string, []byte) error {
+	t.onPublish()
+	return errBrokerDown
+}
+
+func (t failingTransport) PublishBatch(_ context.Context, msgs []transport.Message) []error {
+	t.onPublish()
+	errs := make([]error, len(msgs))
+	for i := range errs {
+		errs[i] = errBrokerDown
+	}
+	return errs
+}
+
+func (failingTransport) Subscribe(context.Context, ...string) transport.Subscription { return nil }
+func (failingTransport) Close() error                                                { return nil }
+
+func TestWorkerStopsWhenCancelledDuringPublishError(t *testing.T) {
+	for _, pipeline := range []int{1, 4} {
+		ctx, cancel := context.WithCancel(context.Background())
+		// The run is cancelled while the publish is failing, so the worker's next step
+		// is the error backoff. An hour-long one shows it isn't waited out.
+		tr := failingTransport{onPublish: cancel}
+		msgs := make([]transport.Message, pipeline)
+		for i := range msgs {
+			msgs[i] = transport.Message{Channel: "metrics", Payload: []byte("{}")}
+		}
+		done := make(chan int)
+		go func() {
+			attempts := 0
+			for {
+				attempts++
+				ok, err := publish(tr, msgs)
+				if ok != 0 || !errors.Is(err, errBrokerDown) {
+					t.Errorf("pipeline %d: publish = %d, %v; want 0, %v", pipeline, ok, err, errBrokerDown)
+				}
+				if !backOff(ctx, time.Hour) {
+					done <- attempts
+					return
+				}
+			}
+		}()
+		select {
+		case attempts := <-done:
+			if attempts != 1 {
+				t.Errorf("pipeline %d: %d publish attempts, want 1", pipeline, attempts)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("pipeline %d: worker still backing off after cancellation", pipeline)
+		}
+	}
+}
+
+func TestBackOffWaitsWhileRunning(t *testing.T) {
+	start := time.Now()
+	if !backOff(context.Background(), 20*time.Millisecond) {
+		t.Fatal("backOff = false without cancellation")
+	}
+	if took := time.Since(start); took < 20*time.Millisecond {
+		t.Errorf("backOff returned after %v, want at least 20ms", took)
+	}
+}
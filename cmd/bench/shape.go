@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Traffic patterns for -pattern and scenario phases.
+const (
+	patternConstant = "constant"
+	patternRamp     = "ramp"
+	patternBurst    = "burst"
+	patternSine     = "sine"
+	patternPoisson  = "poisson"
+)
+
+const (
+	defaultPeriod = 10 * time.Second
+	defaultDuty   = 0.5
+	// shapeStep is the resolution the target rate is integrated at.
+	shapeStep = 10 * time.Millisecond
+)
+
+// phase is a stretch of the run with one traffic pattern. Rate is in messages per
+// second: the constant rate, the end of a ramp, the peak of a burst or sine wave, or the
+// mean of Poisson arrivals. RateMin is where a ramp starts (above Rate, it ramps down)
+// and the trough of a burst or sine wave. Period is the length of one burst or sine
+// cycle; a burst spends Duty of it at Rate and the rest at RateMin.
+type phase struct {
+	Pattern  string
+	Duration time.Duration
+	Rate     float64
+	RateMin  float64
+	Period   time.Duration
+	Duty     float64
+}
+
+func (p phase) validate() error {
+	switch p.Pattern {
+	case patternConstant, patternRamp, patternPoisson:
+	case patternBurst, patternSine:
+		if p.Period <= 0 {
+			return errors.New("period must be positive")
+		}
+		if p.RateMin > p.Rate {
+			return errors.New("rate-min must not be above rate")
+		}
+		if p.Pattern == patternBurst && (p.Duty <= 0 || p.Duty >= 1) {
+			return errors.New("duty must be between 0 and 1")
+		}
+	default:
+		return fmt.Errorf("unknown pattern %q (want constant, ramp, burst, sine or poisson)", p.Pattern)
+	}
+	if p.Duration <= 0 {
+		return errors.New("duration must be positive")
+	}
+	if p.Rate <= 0 || p.RateMin < 0 {
+		return errors.New("rate must be positive and rate-min not negative")
+	}
+	return nil
+}
+
+// rateAt is the target rate t into the phase. A sine wave starts at its trough.
+func (p phase) rateAt(t time.Duration) float64 {
+	switch p.Pattern {
+	case patternRamp:
+		return p.RateMin + (p.Rate-p.RateMin)*min(1, t.Seconds()/p.Duration.Seconds())
+	case patternBurst:
+		if math.Mod(t.Seconds(), p.Period.Seconds()) < p.Duty*p.Period.Seconds() {
+			return p.Rate
+		}
+		return p.RateMin
+	case patternSine:
+		return p.RateMin + (p.Rate-p.RateMin)*(1-math.Cos(2*math.Pi*t.Seconds()/p.Period.Seconds()))/2
+	default:
+		return p.Rate
+	}
+}
+
+func (p phase) String() string {
+	switch p.Pattern {
+	case patternRamp:
+		return fmt.Sprintf("ramp %.0f→%.0f/s for %s", p.RateMin, p.Rate, p.Duration)
+	case patternBurst:
+		return fmt.Sprintf("burst %.0f/s for %.0f%% of every %s, else %.0f/s, for %s", p.Rate, 100*p.Duty, p.Period, p.RateMin, p.Duration)
+	case patternSine:
+		return fmt.Sprintf("sine %.0f–%.0f/s every %s for %s", p.RateMin, p.Rate, p.Period, p.Duration)
+	default:
+		return fmt.Sprintf("%s %.0f/s for %s", p.Pattern, p.Rate, p.Duration)
+	}
+}
+
+// scenario is the phases of a run, in order. Past the last phase its pattern carries on.
+type scenario []phase
+
+func (s scenario) duration() time.Duration {
+	var d time.Duration
+	for _, p := range s {
+		d += p.Duration
+	}
+	return d
+}
+
+// at returns the phase t into the run and how far into that phase t is.
+func (s scenario) at(t time.Duration) (phase, time.Duration) {
+	for _, p := range s[:len(s)-1] {
+		if t < p.Duration {
+			return p, t
+		}
+		t -= p.Duration
+	}
+	return s[len(s)-1], t
+}
+
+// rateAt is the target rate t into the run.
+func (s scenario) rateAt(t time.Duration) float64 {
+	p, t := s.at(t)
+	return p.rateAt(t)
+}
+
+// poisson reports whether the phase t into the run has Poisson arrivals.
+func (s scenario) poisson(t time.Duration) bool {
+	p, _ := s.at(t)
+	return p.Pattern == patternPoisson
+}
+
+// expected is how many messages the scenario calls for between from and to into the run.
+func (s scenario) expected(from, to time.Duration) float64 {
+	var n float64
+	for t := max(from, 0); t < to; t += shapeStep {
+		step := min(shapeStep, to-t)
+		n += s.rateAt(t+step/2) * step.Seconds()
+	}
+	return n
+}
+
+// rawPhase is a phase as written in a scenario file. Durations are strings such as
+// "30s", as for flags; period and duty default to those of -period and -duty.
+type rawPhase struct {
+	Pattern  string  `yaml:"pattern" toml:"pattern"`
+	Duration string  `yaml:"duration" toml:"duration"`
+	Rate     float64 `yaml:"rate" toml:"rate"`
+	RateMin  float64 `yaml:"rate-min" toml:"rate-min"`
+	Period   string  `yaml:"period" toml:"period"`
+	Duty     float64 `yaml:"duty" toml:"duty"`
+}
+
+// loadScenario reads a -scenario file: YAML, or TOML if its name ends in .toml, with a
+// list of phases run in order:
+//
+//	phases:
+//	  - pattern: ramp
+//	    duration: 1m
+//	    rate-min: 1000
+//	    rate: 20000
+//	  - pattern: sine
+//	    duration: 10m
+//	    rate-min: 5000
+//	    rate: 20000
+//	    period: 2m
+func loadScenario(path string) (scenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var r struct {
+		Phases []rawPhase `yaml:"phases" toml:"phases"`
+	}
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		md, err := toml.Decode(string(data), &r)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return nil, fmt.Errorf("%s: unknown key %q", path, undecoded[0].String())
+		}
+	} else {
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(&r); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	if len(r.Phases) == 0 {
+		return nil, fmt.Errorf("%s: no phases", path)
+	}
+	s := make(scenario, len(r.Phases))
+	for i, rp := range r.Phases {
+		p := phase{Pattern: rp.Pattern, Rate: rp.Rate, RateMin: rp.RateMin, Period: defaultPeriod, Duty: rp.Duty}
+		if p.Pattern == "" {
+			p.Pattern = patternConstant
+		}
+		if p.Duty == 0 {
+			p.Duty = defaultDuty
+		}
+		if p.Duration, err = time.ParseDuration(rp.Duration); err != nil {
+			return nil, fmt.Errorf("%s: phase %d: duration: %w", path, i+1, err)
+		}
+		if rp.Period != "" {
+			if p.Period, err = time.ParseDuration(rp.Period); err != nil {
+				return nil, fmt.Errorf("%s: phase %d: period: %w", path, i+1, err)
+			}
+		}
+		if err := p.validate(); err != nil {
+			return nil, fmt.Errorf("%s: phase %d: %w", path, i+1, err)
+		}
+		s[i] = p
+	}
+	return s, nil
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/buildinfo"
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/stats"
+)
+
+// summary is the machine-readable result written by -out so CI can compare runs.
+type summary struct {
+	Workers         int     `json:"workers"`
+	Format          string  `json:"format"`
+	DurationSeconds float64 `json:"duration_seconds"`
+	TotalSent       uint64  `json:"total_sent"` // metrics, not messages
+	RatePerSec      float64 `json:"rate_per_sec"`
+	// With -pack, each publish carries several metrics.
+	Publishes         uint64  `json:"publishes"`
+	MetricsPerPublish float64 `json:"metrics_per_publish"`
+	PublishRatePerSec float64 `json:"publish_rate_per_sec"`
+	// With -pipeline, each round-trip to the broker carries several publishes.
+	Pipeline int `json:"pipeline"`
+	// Publish latency is measured client-side: encode + PUBLISH round-trip (the whole
+	// pipeline's with -pipeline).
+	PublishP50Us int64 `json:"publish_p50_us"`
+	PublishP90Us int64 `json:"publish_p90_us"`
+	PublishP99Us int64 `json:"publish_p99_us"`
+	// Messages that failed to encode or that the broker didn't accept; neither is in
+	// TotalSent.
+	EncodeErrors  uint64 `json:"encode_errors"`
+	PublishErrors uint64 `json:"publish_errors"`
+	// With -rate: the target in messages per second, the share of it reached over the
+	// run, and the seconds that reached less than attainmentFloor of it.
+	TargetRate         float64 `json:"target_rate,omitempty"`
+	Attainment         float64 `json:"attainment,omitempty"`
+	SecondsBelowTarget int     `json:"seconds_below_target,omitempty"`
+	// With -measure: round-trip latency from publish until the server's echo.
+	*rttSummary
+	// With -auto-workers: the recommended worker count and every step measured.
+	AutoWorkers int        `json:"auto_workers,omitempty"`
+	AutoSteps   []tuneStep `json:"auto_steps,omitempty"`
+}
+
+func newSummary(workers int, format string, pipeline int, elapsed time.Duration, sent, publishes uint64, latencies []time.Duration) summary {
+	pct := stats.Summarize(latencies)
+	s := summary{
+		Workers:         workers,
+		Format:          format,
+		Pipeline:        pipeline,
+		DurationSeconds: elapsed.Seconds(),
+		TotalSent:       sent,
+		Publishes:       publishes,
+		PublishP50Us:    pct.P50.Microseconds(),
+		PublishP90Us:    pct.P90.Microseconds(),
+		PublishP99Us:    pct.P99.Microseconds(),
+	}
+	if publishes > 0 {
+		s.MetricsPerPublish = float64(sent) / float64(publishes)
+	}
+	if elapsed > 0 {
+		s.RatePerSec = float64(sent) / elapsed.Seconds()
+		s.PublishRatePerSec = float64(publishes) / elapsed.Seconds()
+	}
+	return s
+}
+
+// runReport is what -out and -report-webhook receive: the summary plus enough context
+// for CI to match the run to a commit, machine and configuration.
+type runReport struct {
+	summary
+	Time   time.Time         `json:"time"` // when the run started
+	GitSHA string            `json:"git_sha,omitempty"`
+	Host   string            `json:"host,omitempty"`
+	Build  buildinfo.Info    `json:"build"`
+	Config map[string]string `json:"config"` // every flag, as given or defaulted
+}
+
+// writeReport writes r to path as JSON, or as CSV if path ends in .csv.
+func writeReport(path string, r runReport) error {
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		return appendCSVReport(path, r)
+	}
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// csvHeader names the columns of a CSV report, one run per row. -auto-workers steps are
+// left out, and the flags go in one column as space-separated name=value pairs.
+var csvHeader = []string{
+	"time", "git_sha", "host", "version", "workers", "format", "pipeline", "duration_seconds",
+	"total_sent", "rate_per_sec", "publishes", "metrics_per_publish", "publish_rate_per_sec",
+	"publish_p50_us", "publish_p90_us", "publish_p99_us", "encode_errors", "publish_errors",
+	"target_rate", "attainment", "seconds_below_target",
+	"rtt_samples", "rtt_p50_us", "rtt_p90_us", "rtt_p99_us", "rtt_p999_us", "rtt_max_us",
+	"auto_workers", "config",
+}
+
+// appendCSVReport adds r as a row to the CSV file at path, so CI can keep one file of
+// runs across commits. A missing or empty file, or one with another header (from an
+// older bench), is started afresh with the header.
+func appendCSVReport(path string, r runReport) error {
+	header := strings.Join(csvHeader, ",")
+	flags := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	writeHeader := false
+	if f, err := os.Open(path); err == nil {
+		first, _ := bufio.NewReader(f).ReadString('\n')
+		f.Close()
+		if strings.TrimRight(first, "\r\n") != header {
+			flags, writeHeader = os.O_WRONLY|os.O_CREATE|os.O_TRUNC, true
+		}
+	} else {
+		writeHeader = true
+	}
+	f, err := os.OpenFile(path, flags, 0o644)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(f)
+	if writeHeader {
+		_ = w.Write(csvHeader)
+	}
+	rtt := rttSummary{}
+	if r.rttSummary != nil {
+		rtt = *r.rttSummary
+	}
+	names := make([]string, 0, len(r.Config))
+	for name := range r.Config {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	config := make([]string, len(names))
+	for i, name := range names {
+		config[i] = name + "=" + r.Config[name]
+	}
+	u := func(v uint64) string { return strconv.FormatUint(v, 10) }
+	i := func(v int64) string { return strconv.FormatInt(v, 10) }
+	g := func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+	_ = w.Write([]string{
+		r.Time.Format(time.RFC3339), r.GitSHA, r.Host, r.Build.Version, strconv.Itoa(r.Workers), r.Format,
+		strconv.Itoa(r.Pipeline), g(r.DurationSeconds),
+		u(r.TotalSent), g(r.RatePerSec), u(r.Publishes), g(r.MetricsPerPublish), g(r.PublishRatePerSec),
+		i(r.PublishP50Us), i(r.PublishP90Us), i(r.PublishP99Us), u(r.EncodeErrors), u(r.PublishErrors),
+		g(r.TargetRate), g(r.Attainment), strconv.Itoa(r.SecondsBelowTarget),
+		u(rtt.Samples), i(rtt.P50Us), i(rtt.P90Us), i(rtt.P99Us), i(rtt.P999Us), i(rtt.MaxUs),
+		strconv.Itoa(r.AutoWorkers), strings.Join(config, " "),
+	})
+	w.Flush()
+	if err := w.Error(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// postReport sends the report to url. Errors are returned for logging only; a failing
+// webhook must not fail the benchmark.
+func postReport(url string, r runReport) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook status: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// gitSHA reads the commit under test from the environment CI usually provides.
+func gitSHA() string {
+	for _, key := range []string{"GIT_SHA", "GITHUB_SHA", "CI_COMMIT_SHA"} {
+		if v := os.Getenv(key); v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,197 @@
+// Command dlq inspects the server's dead-letter queue (DLQ_KEY) and re-injects its
+// entries once whatever stopped them is fixed: undecodable messages are published to
+// their channel again, and batches InfluxDB rejected are written to Influx again.
+//
+//	dlq list [-n 20] [-json]
+//	dlq replay [-n 0] [-transport redis -addr localhost:6379]
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/dlq"
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/sink"
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/transport"
+)
+
+func main() {
+	var (
+		redisAddr     = flag.String("redis", "localhost:6379", "Redis holding the dead-letter queue (the server's DLQ_ADDR)")
+		redisFlags    = transport.AddRedisFlags(flag.CommandLine, "redis")
+		key           = flag.String("key", dlq.DefaultKey, "dead-letter list (the server's DLQ_KEY)")
+		n             = flag.Int("n", 0, "list: entries to show, newest first (default 20); replay: entries to re-inject, oldest first (default all)")
+		asJSON        = flag.Bool("json", false, "list: print whole entries, payloads included, as JSON lines")
+		transportKind = flag.String("transport", transport.KindRedis, "replay: broker to publish decode entries to: redis, redis-streams, nats or kafka")
+		addr          = flag.String("addr", "", "replay: broker address (default -redis for redis, the broker's usual local port otherwise)")
+		channel       = flag.String("channel", "", "replay: publish decode entries to this channel instead of the one they arrived on")
+	)
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] list|replay\n\nreplay writes rejected batches to INFLUX_URL, INFLUX_TOKEN, INFLUX_ORG and INFLUX_BUCKET, as the server does.\n\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx := context.Background()
+	// The same connection settings apply to -redis and, for replay, to -addr.
+	var redisOpts transport.Options
+	if err := redisFlags.Apply(&redisOpts); err != nil {
+		log.Fatal(err)
+	}
+	if redisOpts.RedisMaster != "" && redisOpts.RedisCluster {
+		log.Fatal("-redis-master and -redis-cluster can't be combined")
+	}
+	q := dlq.New(*redisAddr, *key, 0, redisOpts)
+	defer q.Close()
+
+	switch flag.Arg(0) {
+	case "list":
+		if *n == 0 {
+			*n = 20
+		}
+		if err := list(ctx, q, *n, *asJSON); err != nil {
+			log.Fatal(err)
+		}
+	case "replay":
+		if *addr == "" {
+			*addr = *redisAddr
+			if *transportKind != transport.KindRedis && *transportKind != transport.KindRedisStreams {
+				*addr = transport.DefaultAddr(*transportKind)
+			}
+		}
+		tr, err := transport.New(*transportKind, *addr, redisOpts)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer tr.Close()
+		r := &replayer{tr: tr, channel: *channel, influx: newInfluxFromEnv()}
+		replayed, err := r.replay(ctx, q, *n)
+		log.Printf("Replayed %d entries from %q", replayed, *key)
+		if err != nil {
+			log.Fatal(err)
+		}
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+// list prints the newest n entries, one line each, or as JSON.
+func list(ctx context.Context, q *dlq.Queue, n int, asJSON bool) error {
+	total, err := q.Len(ctx)
+	if err != nil {
+		return err
+	}
+	entries, err := q.List(ctx, n)
+	if err != nil {
+		return err
+	}
+	if asJSON {
+		enc := jsoniter.NewEncoder(os.Stdout)
+		for _, e := range entries {
+			if err := enc.Encode(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	fmt.Printf("%d entries in %q, newest first\n", total, q.Key())
+	for _, e := range entries {
+		var what string
+		switch e.Reason {
+		case dlq.ReasonDecode:
+			what = fmt.Sprintf("%s (%s) %d bytes %q", e.Channel, e.Source, len(e.Payload), preview(e.Payload))
+		case dlq.ReasonWrite:
+			what = fmt.Sprintf("%d points", e.Points)
+		}
+		fmt.Printf("%s %-6s %s: %s\n", e.Time.Format(time.RFC3339), e.Reason, what, e.Error)
+	}
+	return nil
+}
+
+// preview is the start of a payload, enough to tell what produced it.
+func preview(payload []byte) []byte {
+	const max = 48
+	if len(payload) > max {
+		return payload[:max]
+	}
+	return payload
+}
+
+type replayer struct {
+	tr      transport.Transport
+	channel string           // overrides the entries' channel if set
+	influx  *sink.InfluxSink // nil without INFLUX_URL
+}
+
+// replay re-injects up to n entries (0 means all), oldest first. Each entry is only
+// removed once it has been re-injected, so one that can't be stays where it was and
+// replay stops, and running it again after fixing the problem carries on in order. A
+// crash between the two re-injects the entry again on the next run instead of losing
+// it. Run one replay per queue at a time.
+func (r *replayer) replay(ctx context.Context, q *dlq.Queue, n int) (int, error) {
+	done := 0
+	for n == 0 || done < n {
+		h, err := q.Head(ctx)
+		if errors.Is(err, dlq.ErrEmpty) {
+			return done, nil
+		}
+		if err != nil {
+			return done, err
+		}
+		if err := r.inject(ctx, h.Entry); err != nil {
+			return done, err
+		}
+		if err := q.Remove(ctx, h); err != nil {
+			return done, fmt.Errorf("entry re-injected but not removed, so the next replay repeats it: %w", err)
+		}
+		done++
+	}
+	return done, nil
+}
+
+func (r *replayer) inject(ctx context.Context, e dlq.Entry) error {
+	switch e.Reason {
+	case dlq.ReasonDecode:
+		channel := e.Channel
+		if r.channel != "" {
+			channel = r.channel
+		}
+		if channel == "" {
+			return fmt.Errorf("decode entry from %s has no channel; set -channel", e.Time.Format(time.RFC3339))
+		}
+		return r.tr.Publish(ctx, channel, e.Payload)
+	case dlq.ReasonWrite:
+		if r.influx == nil {
+			return errors.New("write entries need INFLUX_URL, INFLUX_TOKEN, INFLUX_ORG and INFLUX_BUCKET")
+		}
+		return r.influx.WriteLines(ctx, []byte(e.Lines))
+	default:
+		return fmt.Errorf("unknown entry reason %q", e.Reason)
+	}
+}
+
+// newInfluxFromEnv returns a sink for the Influx the server's environment names, with
+// the server's default retries, or nil without INFLUX_URL.
+func newInfluxFromEnv() *sink.InfluxSink {
+	base := os.Getenv("INFLUX_URL")
+	if base == "" {
+		return nil
+	}
+	writeURL := sink.InfluxWriteURL(base, os.Getenv("INFLUX_ORG"), os.Getenv("INFLUX_BUCKET"))
+	return sink.NewInfluxSink(writeURL, os.Getenv("INFLUX_TOKEN"), sink.Precision{}, sink.InfluxOptions{
+		ContentType: os.Getenv("INFLUX_CONTENT_TYPE"),
+		Retry:       sink.DefaultRetryPolicy,
+	})
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"log/slog"
+	"sync/atomic"
+
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/transport"
+)
+
+// ackStats counts acknowledgements for transports whose subscriptions ack (-stream).
+type ackStats struct {
+	acked    atomic.Uint64
+	failures atomic.Uint64
+}
+
+func newAckStats() *ackStats {
+	s := &ackStats{}
+	expvar.Publish("stream_acked", expvar.Func(func() any { return s.acked.Load() }))
+	expvar.Publish("stream_ack_failures", expvar.Func(func() any { return s.failures.Load() }))
+	return s
+}
+
+// ackSet holds the deliveries behind one batch, per subscription, until the batch has
+// been written. Every message the batcher handled is in it, including ones it dropped
+// (decode errors, dedup, auth), so those aren't redelivered forever either.
+type ackSet map[transport.Acker][]transport.Delivery
+
+// add records in if it came from an acking subscription.
+func (a ackSet) add(in inbound) {
+	if in.acker != nil {
+		a[in.acker] = append(a[in.acker], transport.Delivery{Channel: in.channel, ID: in.id})
+	}
+}
+
+// heldAck is a delivery that also fed the rollup (ROLLUP_INTERVAL). It joins an ackSet
+// only when the batcher is done with it and every rollup interval it fed has been
+// emitted into a batch, so it is never acked before its aggregate is written.
+type heldAck struct {
+	in   inbound
+	refs int // the batcher's own while it handles in, plus one per rollup interval
+}
+
+// release drops one reference to h and adds it to a once none are left.
+func (h *heldAck) release(a ackSet) {
+	if h.refs--; h.refs == 0 {
+		a.add(h.in)
+	}
+}
+
+// size is the number of deliveries in a.
+func (a ackSet) size() int {
+	n := 0
+	for _, ds := range a {
+		n += len(ds)
+	}
+	return n
+}
+
+// ack acknowledges everything in a. A failed ack only means the entries are delivered
+// again after a restart, so it is logged and counted rather than retried.
+func (a ackSet) ack(ctx context.Context, stats *ackStats) {
+	for acker, ds := range a {
+		if err := acker.Ack(ctx, ds...); err != nil {
+			stats.failures.Add(1)
+			slog.Error("Stream ack failed", "entries", len(ds), "err", err)
+			continue
+		}
+		stats.acked.Add(uint64(len(ds)))
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// requireToken guards an admin endpoint: requests must send "Authorization: Bearer
+// <token>" (ADMIN_TOKEN). With no token configured the endpoint refuses every request,
+// since it changes what the server does and the debug port has no other protection.
+func requireToken(token string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if token == "" {
+			http.Error(w, "disabled: set ADMIN_TOKEN to enable", http.StatusForbidden)
+			return
+		}
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// flushHandler serves POST /flush: the batcher flushes its partial batch right away and
+// the response is the number of points flushed, so tests needn't wait for a trigger.
+func flushHandler(b *batcher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		reply := make(chan int, 1)
+		select {
+		case b.flushReq <- reply:
+		case <-r.Context().Done():
+			return
+		}
+		fmt.Fprintln(w, <-reply)
+	}
+}
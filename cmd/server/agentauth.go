@@ -0,0 +1,87 @@
+package main
+
+import (
+	"crypto/subtle"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/transport"
+)
+
+// agentAuth tracks which agents have completed the control-channel handshake
+// (AGENT_TOKENS). Only samples whose host is an accepted identity are written; the rest
+// are dropped and counted. Hellos arrive on the receive goroutines and lookups happen on
+// the batcher, hence the lock.
+type agentAuth struct {
+	channel string
+	tokens  map[string]string // identity -> pre-shared token
+
+	mu       sync.RWMutex
+	accepted map[string]bool
+
+	rejected atomic.Uint64 // hellos with an unknown identity or a wrong token
+	dropped  atomic.Uint64 // samples from agents that haven't authenticated
+}
+
+// newAgentAuth reads a comma-separated list of identity=token pairs; empty means agents
+// aren't authenticated (nil).
+func newAgentAuth(v, channel string) (*agentAuth, error) {
+	tokens := make(map[string]string)
+	for _, entry := range strings.Split(v, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		id, token, ok := strings.Cut(entry, "=")
+		id, token = strings.TrimSpace(id), strings.TrimSpace(token)
+		if !ok || id == "" || token == "" {
+			return nil, fmt.Errorf("want identity=token, got %q", entry)
+		}
+		tokens[id] = token
+	}
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+	a := &agentAuth{channel: channel, tokens: tokens, accepted: make(map[string]bool)}
+	expvar.Publish("agent_auth_rejected", expvar.Func(func() any { return a.rejected.Load() }))
+	expvar.Publish("unauthenticated_dropped", expvar.Func(func() any { return a.dropped.Load() }))
+	return a, nil
+}
+
+// hello handles one control-channel message from src.
+func (a *agentAuth) hello(src *source, payload []byte) {
+	h, err := transport.DecodeHello(payload)
+	if err != nil {
+		a.rejected.Add(1)
+		slog.Warn("Bad agent hello", "source", src.String(), "channel", a.channel, "err", err)
+		return
+	}
+	want, known := a.tokens[h.Host]
+	if !known || subtle.ConstantTimeCompare([]byte(h.Token), []byte(want)) != 1 {
+		a.rejected.Add(1)
+		slog.Warn("Rejected agent: unknown identity or wrong token", "agent", h.Host, "source", src.String())
+		return
+	}
+	a.mu.Lock()
+	first := !a.accepted[h.Host]
+	a.accepted[h.Host] = true
+	a.mu.Unlock()
+	if first {
+		slog.Info("Agent authenticated", "agent", h.Host, "source", src.String())
+	}
+}
+
+// allows reports whether samples from host may be written, counting the ones that may not.
+func (a *agentAuth) allows(host string) bool {
+	a.mu.RLock()
+	ok := a.accepted[host]
+	a.mu.RUnlock()
+	if !ok {
+		a.dropped.Add(1)
+	}
+	return ok
+}
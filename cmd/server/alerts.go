@@ -0,0 +1,288 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/clock"
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/sink"
+)
+
+// alertFieldAliases are the names agents send the core fields as (transport.Metric's
+// JSON keys), accepted in rules for the system_stats fields.
+var alertFieldAliases = map[string]string{"cpu_usage": "cpu", "mem_usage": "mem"}
+
+// alertRule is one ALERT_RULES entry: [measurement.]field op threshold [for duration].
+type alertRule struct {
+	text        string
+	measurement string
+	field       string
+	op          string
+	threshold   float64
+	forDur      time.Duration
+}
+
+func (r *alertRule) matches(v float64) bool {
+	switch r.op {
+	case ">":
+		return v > r.threshold
+	case ">=":
+		return v >= r.threshold
+	case "<":
+		return v < r.threshold
+	case "<=":
+		return v <= r.threshold
+	case "==":
+		return v == r.threshold
+	default: // "!="
+		return v != r.threshold
+	}
+}
+
+// parseAlertRules reads ALERT_RULES: rules separated by semicolons or newlines, e.g.
+// "cpu_usage > 90 for 5m; gpu_stats.util_percent >= 95 for 1m". A field without a
+// measurement is a system_stats field (cpu_usage or cpu, mem_usage or mem).
+func parseAlertRules(v string) ([]alertRule, error) {
+	var rules []alertRule
+	for _, text := range strings.FieldsFunc(v, func(r rune) bool { return r == ';' || r == '\n' }) {
+		words := strings.Fields(text)
+		if len(words) == 0 {
+			continue
+		}
+		text = strings.Join(words, " ")
+		if len(words) != 3 && len(words) != 5 {
+			return nil, fmt.Errorf("rule %q: want [measurement.]field op threshold [for duration]", text)
+		}
+		r := alertRule{text: text, op: words[1]}
+		r.measurement, r.field = parseAlertField(words[0])
+		if r.measurement == "" || r.field == "" {
+			return nil, fmt.Errorf("rule %q: empty measurement or field", text)
+		}
+		if !slices.Contains([]string{">", ">=", "<", "<=", "==", "!="}, r.op) {
+			return nil, fmt.Errorf("rule %q: unknown operator %q", text, r.op)
+		}
+		var err error
+		if r.threshold, err = strconv.ParseFloat(words[2], 64); err != nil {
+			return nil, fmt.Errorf("rule %q: bad threshold %q", text, words[2])
+		}
+		if len(words) == 5 {
+			if words[3] != "for" {
+				return nil, fmt.Errorf("rule %q: want \"for duration\" after the threshold", text)
+			}
+			if r.forDur, err = time.ParseDuration(words[4]); err != nil || r.forDur < 0 {
+				return nil, fmt.Errorf("rule %q: bad duration %q", text, words[4])
+			}
+		}
+		rules = append(rules, r)
+	}
+	if len(rules) == 0 {
+		return nil, fmt.Errorf("no rules")
+	}
+	return rules, nil
+}
+
+// parseAlertField splits [measurement.]field; a field without a measurement is a
+// system_stats field. Either result may be empty.
+func parseAlertField(v string) (measurement, field string) {
+	if i := strings.LastIndexByte(v, '.'); i >= 0 {
+		return v[:i], v[i+1:]
+	}
+	if alias, ok := alertFieldAliases[v]; ok {
+		v = alias
+	}
+	return sink.Measurement, v
+}
+
+// alertState is one series (a rule and a set of tags) whose condition holds.
+type alertState struct {
+	since    time.Time // first sample in the current run that matched
+	firing   bool
+	notified time.Time // when firing was last sent, for ALERT_REPEAT_INTERVAL
+	value    float64
+	tags     []sink.Tag
+}
+
+// alertEvent is what notifiers are given when an alert fires, is still firing after
+// ALERT_REPEAT_INTERVAL, or resolves, and when an anomaly starts or ends.
+type alertEvent struct {
+	Status string `json:"status"` // firing or resolved
+	// Fingerprint identifies the alert (rule and tags) across its events, so receivers
+	// can deduplicate repeats and match a resolve to its firing.
+	Fingerprint string  `json:"fingerprint"`
+	Repeat      bool    `json:"repeat,omitempty"` // a reminder that it is still firing
+	Rule        string  `json:"rule"`
+	Measurement string  `json:"measurement"`
+	Field       string  `json:"field"`
+	Value       float64 `json:"value"`
+	Threshold   float64 `json:"threshold"`
+	// Baseline and StdDev are the learned mean and standard deviation, for anomalies.
+	Baseline float64           `json:"baseline,omitempty"`
+	StdDev   float64           `json:"stddev,omitempty"`
+	Tags     map[string]string `json:"tags"`
+	Since    time.Time         `json:"since"` // when the condition started to hold
+	Time     time.Time         `json:"time"`
+}
+
+// alerter evaluates ALERT_RULES against every decoded point as it is batched. A series
+// whose condition holds on every sample for the rule's duration fires; the first
+// sample on which it no longer holds resolves it. Both are logged and sent to the
+// configured notifiers, once per firing: a series that stays firing is only sent
+// again every repeat interval, if one is set. Time is the server's receive time, not
+// the agents' clocks. observe is called from the batcher goroutine; the mutex is only
+// for /debug/vars.
+type alerter struct {
+	clock  clock.Clock
+	rules  []alertRule
+	repeat time.Duration // ALERT_REPEAT_INTERVAL; 0 never repeats
+	notify alertNotifiers
+
+	mu     sync.Mutex
+	states []map[string]*alertState // per rule, by seriesKey
+}
+
+func newAlerter(clk clock.Clock, rules []alertRule, repeat time.Duration, notify alertNotifiers) *alerter {
+	a := &alerter{clock: clk, rules: rules, repeat: repeat, notify: notify, states: make([]map[string]*alertState, len(rules))}
+	for i := range a.states {
+		a.states[i] = make(map[string]*alertState)
+	}
+	expvar.Publish("alerts_firing", expvar.Func(func() any { return a.firing() }))
+	return a
+}
+
+// observe evaluates p, and the extra points decoded from the same sample (which take
+// p's time and tags as the batch does).
+func (a *alerter) observe(p sink.Point, extra []sink.Point) {
+	now := a.clock.Now()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.evaluate(&p, now)
+	for _, e := range extra {
+		e.Tags = mergeTags(e.Tags, p.Tags)
+		a.evaluate(&e, now)
+	}
+}
+
+// evaluate is called with a.mu held.
+func (a *alerter) evaluate(p *sink.Point, now time.Time) {
+	name := p.Name()
+	var key string
+	keyed := false
+	for i := range a.rules {
+		r := &a.rules[i]
+		if r.measurement != name {
+			continue
+		}
+		p.EachField(func(field string, v float64) {
+			if field != r.field {
+				return
+			}
+			if !keyed {
+				key, keyed = seriesKey(p.Tags), true
+			}
+			states := a.states[i]
+			s := states[key]
+			switch {
+			case r.matches(v):
+				if s == nil {
+					s = &alertState{since: now, tags: p.Tags}
+					states[key] = s
+				}
+				s.value = v
+				switch {
+				case !s.firing && now.Sub(s.since) >= r.forDur:
+					s.firing = true
+					a.emit("firing", false, r, s, now)
+				case s.firing && a.repeat > 0 && now.Sub(s.notified) >= a.repeat:
+					a.emit("firing", true, r, s, now)
+				}
+			case s != nil:
+				delete(states, key)
+				if s.firing {
+					s.value = v
+					a.emit("resolved", false, r, s, now)
+				}
+			}
+		})
+	}
+}
+
+// formatTags writes tags as {k=v,...} for log lines.
+func formatTags(tags []sink.Tag) string {
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, t := range tags {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(t.Key)
+		b.WriteByte('=')
+		b.WriteString(t.Value)
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func (a *alerter) emit(status string, repeat bool, r *alertRule, s *alertState, now time.Time) {
+	switch {
+	case status == "resolved":
+		slog.Info("Alert resolved", "rule", r.text, "tags", formatTags(s.tags), "value", s.value)
+	case !repeat:
+		slog.Warn("Alert firing", "rule", r.text, "tags", formatTags(s.tags), "value", s.value, "since", s.since)
+	}
+	if status == "firing" {
+		s.notified = now
+	}
+	if len(a.notify) == 0 {
+		return
+	}
+	a.notify.add(alertEvent{
+		Status:      status,
+		Fingerprint: alertFingerprint(r.text, s.tags),
+		Repeat:      repeat,
+		Rule:        r.text,
+		Measurement: r.measurement,
+		Field:       r.field,
+		Value:       s.value,
+		Threshold:   r.threshold,
+		Tags:        tagMap(s.tags),
+		Since:       s.since,
+		Time:        now,
+	})
+}
+
+// alertFingerprint identifies the alert for rule on the series with tags.
+func alertFingerprint(rule string, tags []sink.Tag) string {
+	sum := sha256.Sum256([]byte(rule + "\x00" + seriesKey(tags)))
+	return hex.EncodeToString(sum[:8])
+}
+
+func tagMap(tags []sink.Tag) map[string]string {
+	m := make(map[string]string, len(tags))
+	for _, t := range tags {
+		m[t.Key] = t.Value
+	}
+	return m
+}
+
+// firing lists the alerts currently firing, for /debug/vars.
+func (a *alerter) firing() []map[string]any {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var out []map[string]any
+	for i, states := range a.states {
+		for _, s := range states {
+			if s.firing {
+				out = append(out, map[string]any{"rule": a.rules[i].text, "tags": formatTags(s.tags), "value": s.value, "since": s.since})
+			}
+		}
+	}
+	return out
+}
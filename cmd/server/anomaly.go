@@ -0,0 +1,201 @@
+package main
+
+import (
+	"expvar"
+	"fmt"
+	"log/slog"
+	"math"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/clock"
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/sink"
+)
+
+const (
+	defaultAnomalyAlpha  = 0.05
+	defaultAnomalyWarmup = 30
+)
+
+// anomalyField is an ANOMALY_FIELDS entry.
+type anomalyField struct {
+	measurement, field string
+}
+
+// parseAnomalyFields reads ANOMALY_FIELDS: comma-separated [measurement.]field names,
+// written as in ALERT_RULES.
+func parseAnomalyFields(v string) ([]anomalyField, error) {
+	var fields []anomalyField
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		var f anomalyField
+		f.measurement, f.field = parseAlertField(s)
+		if f.measurement == "" || f.field == "" {
+			return nil, fmt.Errorf("%q: empty measurement or field", s)
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+// baseline is what has been learned about one series: one field of one measurement
+// with one set of tags.
+type baseline struct {
+	mean, variance float64
+	n              int // samples seen, up to the warmup
+	anomalous      bool
+	since          time.Time // when the current anomaly started
+	tags           []sink.Tag
+}
+
+// anomalyDetector learns a baseline for every series as points are batched: an
+// exponentially weighted moving average of its values and of their variance, with
+// weight alpha for each new sample. Once a series has seen warmup samples, a value more
+// than sigma standard deviations from the average starts an anomaly, and the first
+// value back within range ends it. Both are logged and sent to the alert notifiers.
+// Every value, anomalous or not, updates the baseline, so a lasting change of level
+// becomes the new normal instead of staying anomalous forever. observe is called from
+// the batcher goroutine; the mutex is only for /debug/vars.
+type anomalyDetector struct {
+	clock  clock.Clock
+	sigma  float64
+	alpha  float64
+	warmup int
+	fields []anomalyField // nil watches every field
+	notify alertNotifiers
+
+	mu       sync.Mutex
+	series   map[string]*baseline
+	detected atomic.Uint64
+}
+
+func newAnomalyDetector(clk clock.Clock, sigma, alpha float64, warmup int, fields []anomalyField, notify alertNotifiers) *anomalyDetector {
+	d := &anomalyDetector{
+		clock:  clk,
+		sigma:  sigma,
+		alpha:  alpha,
+		warmup: warmup,
+		fields: fields,
+		notify: notify,
+		series: make(map[string]*baseline),
+	}
+	expvar.Publish("anomalies_detected", expvar.Func(func() any { return d.detected.Load() }))
+	expvar.Publish("anomalies_active", expvar.Func(func() any { return d.active() }))
+	return d
+}
+
+// observe checks p, and the extra points decoded from the same sample (which take p's
+// tags as the batch does).
+func (d *anomalyDetector) observe(p sink.Point, extra []sink.Point) {
+	now := d.clock.Now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.check(&p, now)
+	for _, e := range extra {
+		e.Tags = mergeTags(e.Tags, p.Tags)
+		d.check(&e, now)
+	}
+}
+
+func (d *anomalyDetector) watches(measurement, field string) bool {
+	if d.fields == nil {
+		return true
+	}
+	for _, f := range d.fields {
+		if f.measurement == measurement && f.field == field {
+			return true
+		}
+	}
+	return false
+}
+
+// check is called with d.mu held.
+func (d *anomalyDetector) check(p *sink.Point, now time.Time) {
+	name := p.Name()
+	var tagKey string
+	keyed := false
+	p.EachField(func(field string, v float64) {
+		if math.IsNaN(v) || math.IsInf(v, 0) || !d.watches(name, field) {
+			return
+		}
+		if !keyed {
+			tagKey, keyed = seriesKey(p.Tags), true
+		}
+		key := name + "\x00" + field + "\x00" + tagKey
+		b := d.series[key]
+		if b == nil {
+			d.series[key] = &baseline{mean: v, n: 1, tags: p.Tags}
+			return
+		}
+		mean, std := b.mean, math.Sqrt(b.variance)
+		// An anomaly needs a baseline that has settled and that varies at all; a series
+		// that has only ever had one value has no scale to measure deviations by.
+		out := b.n >= d.warmup && std > 0 && math.Abs(v-mean) > d.sigma*std
+		switch {
+		case out && !b.anomalous:
+			b.anomalous, b.since = true, now
+			d.detected.Add(1)
+			d.emit("firing", name, field, v, mean, std, b, now)
+		case !out && b.anomalous:
+			b.anomalous = false
+			d.emit("resolved", name, field, v, mean, std, b, now)
+		}
+		diff := v - mean
+		incr := d.alpha * diff
+		b.mean += incr
+		b.variance = (1 - d.alpha) * (b.variance + diff*incr)
+		if b.n < d.warmup {
+			b.n++
+		}
+	})
+}
+
+func (d *anomalyDetector) emit(status, measurement, field string, v, mean, std float64, b *baseline, now time.Time) {
+	z := (v - mean) / std
+	if status == "firing" {
+		slog.Warn("Anomaly", "measurement", measurement, "field", field, "tags", formatTags(b.tags), "value", v, "sigmas", math.Round(z*10)/10, "baseline", mean, "stddev", std)
+	} else {
+		slog.Info("Anomaly over", "measurement", measurement, "field", field, "tags", formatTags(b.tags), "value", v, "baseline", mean, "stddev", std)
+	}
+	if len(d.notify) == 0 {
+		return
+	}
+	rule := fmt.Sprintf("%s.%s anomaly beyond %gσ", measurement, field, d.sigma)
+	threshold := mean + d.sigma*std
+	if v < mean {
+		threshold = mean - d.sigma*std
+	}
+	d.notify.add(alertEvent{
+		Status:      status,
+		Fingerprint: alertFingerprint(rule, b.tags),
+		Rule:        rule,
+		Measurement: measurement,
+		Field:       field,
+		Value:       v,
+		Threshold:   threshold,
+		Baseline:    mean,
+		StdDev:      std,
+		Tags:        tagMap(b.tags),
+		Since:       b.since,
+		Time:        now,
+	})
+}
+
+// active lists the series currently anomalous, for /debug/vars.
+func (d *anomalyDetector) active() []map[string]any {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var out []map[string]any
+	for key, b := range d.series {
+		if b.anomalous {
+			name, rest, _ := strings.Cut(key, "\x00")
+			field, _, _ := strings.Cut(rest, "\x00")
+			out = append(out, map[string]any{"series": name + "." + field, "tags": formatTags(b.tags), "baseline": b.mean, "since": b.since})
+		}
+	}
+	return out
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"sort"
+	"time"
+
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/sink"
+)
+
+const (
+	availabilityMeasurement = "host_availability"
+
+	// availabilityReportEvery is how often host_availability points are written.
+	availabilityReportEvery   = time.Minute
+	defaultAvailabilityWindow = 5 * time.Minute
+)
+
+// availability estimates, per host, the fraction of expected reporting intervals in
+// which at least one sample arrived, over a rolling window. Time is cut into slots of
+// the agents' expected interval (AVAILABILITY_INTERVAL) and each host keeps a ring of
+// seen flags for the last window's worth of slots. Only completed slots since a host
+// was first seen count, so a new host isn't penalised for the time before it existed.
+// It is owned by the consumer goroutine.
+type availability struct {
+	interval int64 // ns per slot
+	slots    int64 // slots per window
+	hosts    map[string]*hostSlots
+}
+
+type hostSlots struct {
+	seen  []bool // indexed by slot % slots
+	first int64  // first slot with a sample
+	last  int64  // latest slot with a sample
+}
+
+func newAvailability(interval, window time.Duration) *availability {
+	return &availability{
+		interval: int64(interval),
+		slots:    max(int64(window/interval), 1),
+		hosts:    make(map[string]*hostSlots),
+	}
+}
+
+// observe records a sample from host received at t.
+func (a *availability) observe(host string, t time.Time) {
+	slot := t.UnixNano() / a.interval
+	h := a.hosts[host]
+	if h == nil {
+		h = &hostSlots{seen: make([]bool, a.slots), first: slot, last: slot}
+		a.hosts[host] = h
+	}
+	if slot < h.last {
+		return // out of order receive times only happen across sources; the slot is old
+	}
+	// Clear ring entries for the slots skipped since the last sample.
+	for s := h.last + 1; s < slot && s <= h.last+a.slots; s++ {
+		h.seen[s%a.slots] = false
+	}
+	h.last = slot
+	h.seen[slot%a.slots] = true
+}
+
+// points returns one host_availability point per host as of now. Hosts that reported
+// nothing during the whole window (and not since) get a final zero and are forgotten.
+func (a *availability) points(now time.Time) []sink.Point {
+	cur := now.UnixNano() / a.interval // in progress, so not counted
+	hosts := make([]string, 0, len(a.hosts))
+	for host := range a.hosts {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	var out []sink.Point
+	for _, host := range hosts {
+		h := a.hosts[host]
+		lo := max(h.first, cur-a.slots)
+		expected, reported := 0, 0
+		for s := lo; s < cur; s++ {
+			expected++
+			if s <= h.last && s > h.last-a.slots && h.seen[s%a.slots] {
+				reported++
+			}
+		}
+		if expected == 0 {
+			continue
+		}
+		out = append(out, sink.Point{
+			Measurement: availabilityMeasurement,
+			TimeNano:    now.UnixNano(),
+			Fields: []sink.Field{
+				{Key: "availability", Value: float64(reported) / float64(expected)},
+				{Key: "expected", Value: float64(expected)},
+				{Key: "reported", Value: float64(reported)},
+			},
+			Tags: []sink.Tag{{Key: "host", Value: host}},
+		})
+		if reported == 0 && h.last < cur {
+			delete(a.hosts, host)
+		}
+	}
+	return out
+}
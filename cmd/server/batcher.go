@@ -0,0 +1,469 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/clock"
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/dlq"
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/sink"
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/stats"
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/transport"
+)
+
+// batcher is the single decode/batch stage: it drains the receive queue, decodes and
+// batches points, hands full batches to the sinks, and keeps the latency stats.
+// All of its state is owned by the run goroutine.
+type batcher struct {
+	clock        clock.Clock
+	queue        *recvQueues
+	influx       *influxWriter // nil when INFLUX_URL is unset
+	sinks        []namedSink
+	dedup        *seqDedup
+	ingestTime   bool
+	inputFormat  string           // -input-format: inputAuto or a transport format to decode strictly
+	legacyBinary bool             // -legacy-binary: read unframed 32-byte records with inputAuto
+	raw          bool             // write every point as received (OUTPUT_RAW)
+	rollup       *rollup          // nil unless ROLLUP_INTERVAL is set
+	tagFilter    *tagFilter       // nil unless TAG_ALLOWLIST is set
+	partition    *partition       // nil unless REPLICA_COUNT > 1
+	auth         *agentAuth       // nil unless AGENT_TOKENS is set
+	avail        *availability    // nil unless AVAILABILITY_INTERVAL is set
+	alerts       *alerter         // nil unless ALERT_RULES is set
+	anomalies    *anomalyDetector // nil unless ANOMALY_SIGMA is set
+	echo         *echoer          // nil unless ECHO_CHANNEL is set
+	traces       *sampleTracer    // nil unless OTEL_EXPORTER_OTLP_ENDPOINT is set
+	dlq          *deadLetters     // nil unless DLQ_KEY is set
+	acks         ackSet           // messages behind the pending batch; nil unless the transport acks (-stream)
+	held         *heldAck         // the message being handled, when acks wait for the rollup
+	ackStats     *ackStats
+
+	metrics     *pipelineMetrics
+	latency     *stats.Window // E2E: producer send → point batched
+	internal    *stats.Window // Core engine: Redis recv → point batched
+	sinceReport int
+	flushes     batchStats
+	decodeTime  *decodeTimer
+	byFormat    *formatStats // nil unless STATS_BY_FORMAT is set
+	live        *liveHub     // nil unless -ui is set
+	points      *pointHub    // nil unless -live is set
+	batch       []sink.Point
+	batchStart  time.Time // when the oldest point in batch was added
+
+	// Timer flushes (FLUSH_INTERVAL) skip batches smaller than minBatch until their
+	// oldest point is maxAge old, so quiet periods don't send one-point requests.
+	flushEvery time.Duration // 0 disables the timer
+	minBatch   int
+	maxAge     time.Duration
+
+	flushReq chan chan int    // POST /flush; replies with the number of points flushed
+	dumpReq  <-chan os.Signal // SIGUSR1; logs a stats snapshot without resetting it
+	drainReq chan struct{}    // closed at shutdown, once the receivers have stopped
+	done     chan struct{}    // closed when run returns after draining
+}
+
+func (b *batcher) run(ctx context.Context) {
+	defer close(b.done)
+	var tick <-chan time.Time // nil (never fires) without FLUSH_INTERVAL
+	if b.flushEvery > 0 {
+		t := b.clock.NewTicker(b.flushEvery)
+		defer t.Stop()
+		tick = t.C()
+	}
+	var rollupTick <-chan time.Time
+	if b.rollup != nil {
+		t := b.clock.NewTicker(time.Duration(b.rollup.interval))
+		defer t.Stop()
+		rollupTick = t.C()
+	}
+	var availTick <-chan time.Time
+	if b.avail != nil {
+		t := b.clock.NewTicker(availabilityReportEvery)
+		defer t.Stop()
+		availTick = t.C()
+	}
+	for {
+		select {
+		case <-b.queue.ready:
+			b.handle(ctx, b.queue.next())
+			if len(b.batch) == 0 && b.acks.size() >= influxBatchSize {
+				b.flush(ctx, triggerSize) // acks messages that were all dropped
+			}
+		case <-tick:
+			if b.timerFlushDue() {
+				b.flush(ctx, triggerTimer)
+			}
+		case reply := <-b.flushReq:
+			n := len(b.batch)
+			b.flush(ctx, triggerManual)
+			reply <- n
+		case <-rollupTick:
+			b.sweepRollup(ctx)
+		case <-availTick:
+			b.batch = append(b.batch, b.avail.points(b.clock.Now())...)
+			if len(b.batch) >= influxBatchSize {
+				b.flush(ctx, triggerSize)
+			}
+		case <-b.dumpReq:
+			b.dump()
+		case <-b.drainReq:
+			b.drain(ctx)
+			return
+		}
+	}
+}
+
+// drain handles everything still in the receive queue and flushes the final batch.
+// The receivers have stopped by now, so the queue only shrinks.
+func (b *batcher) drain(ctx context.Context) {
+	n := 0
+	for {
+		select {
+		case <-b.queue.ready:
+			b.handle(ctx, b.queue.next())
+			n++
+		default:
+			if b.rollup != nil {
+				for _, rp := range b.rollup.flushAll() {
+					b.emitRollup(rp)
+				}
+			}
+			slog.Info("Drained queued messages", "messages", n, "points", len(b.batch))
+			b.flush(ctx, triggerShutdown)
+			return
+		}
+	}
+}
+
+// sweepRollup writes the rollup intervals of series that went quiet.
+func (b *batcher) sweepRollup(ctx context.Context) {
+	for _, rp := range b.rollup.expire(b.clock.Now()) {
+		b.emitRollup(rp)
+	}
+	if len(b.batch) >= influxBatchSize {
+		b.flush(ctx, triggerSize)
+	}
+}
+
+// emitRollup adds a finished rollup interval to the batch. The messages held for it
+// that aren't waiting for another interval are acked with that batch.
+func (b *batcher) emitRollup(rp rollupPoint) {
+	b.batch = append(b.batch, rp.Point)
+	for _, h := range rp.held {
+		h.release(b.acks)
+	}
+}
+
+func (b *batcher) handle(ctx context.Context, in inbound) {
+	switch {
+	case b.acks != nil && b.rollup != nil:
+		// With a rollup, in is also held until every interval its points went into has
+		// been written (see heldAck). Only the delivery is kept meanwhile.
+		h := &heldAck{in: in, refs: 1}
+		h.in.payload = ""
+		b.held = h
+		defer func() {
+			b.held = nil
+			h.release(b.acks)
+		}()
+	case b.acks != nil:
+		// A batch or multi frame can fill the batch partway through, so in is only
+		// recorded after its last point: it is acked with the batch that holds it, not
+		// with one that holds just its first points.
+		defer b.acks.add(in)
+	}
+	b.metrics.consumed.Add(1)
+	payload := []byte(in.payload)
+	// A multi frame is only an envelope, so it is accepted whatever -input-format says;
+	// the payloads in it must be in that format.
+	if transport.IsMultiFrame(payload) {
+		payloads, err := transport.MultiFramePayloads(payload)
+		if err != nil {
+			b.decodeFailed(in, payload, err)
+			return
+		}
+		for _, p := range payloads {
+			b.handlePayload(ctx, in, p)
+		}
+		return
+	}
+	b.handlePayload(ctx, in, payload)
+}
+
+// handlePayload decodes one payload of in, which is all of it unless it came in a
+// multi frame, and adds the points.
+func (b *batcher) handlePayload(ctx context.Context, in inbound, payload []byte) {
+	if transport.IsBinaryBatchFrame(payload) && (b.inputFormat == inputAuto || b.inputFormat == transport.FormatBinary) {
+		recs, n, err := transport.BinaryBatchRecords(payload)
+		if err != nil {
+			b.decodeFailed(in, payload, err)
+			return
+		}
+		for i := 0; i < n; i++ {
+			b.add(ctx, in, decodeBinary(recs[i*transport.BinarySize:(i+1)*transport.BinarySize]))
+		}
+		return
+	}
+	if b.traces != nil {
+		in.decodeAt = b.clock.Now()
+	}
+	var d decoded
+	var err error
+	if b.decodeTime.sample() {
+		start := b.clock.Now()
+		d, err = decodePayload(payload, b.inputFormat, b.legacyBinary)
+		if err == nil {
+			b.decodeTime.windows[d.format].Add(b.clock.Since(start))
+		}
+	} else {
+		d, err = decodePayload(payload, b.inputFormat, b.legacyBinary)
+	}
+	if err != nil {
+		b.decodeFailed(in, payload, err)
+		return
+	}
+	b.add(ctx, in, d)
+}
+
+// decodeFailed counts and logs a payload of in that couldn't be decoded, and
+// dead-letters it.
+func (b *batcher) decodeFailed(in inbound, payload []byte, err error) {
+	b.metrics.decodeErrors.Add(1)
+	slog.Warn("Decode error", "source", in.src.String(), "channel", in.channel, "err", err)
+	if b.dlq != nil {
+		b.dlq.add(dlq.Entry{
+			Time:    b.clock.Now(),
+			Reason:  dlq.ReasonDecode,
+			Error:   err.Error(),
+			Channel: in.channel,
+			Source:  in.src.String(),
+			Payload: payload,
+		})
+	}
+}
+
+// add authenticates, dedups, tags and batches one decoded sample and updates the latency stats.
+func (b *batcher) add(ctx context.Context, in inbound, d decoded) {
+	if b.auth != nil && !b.auth.allows(d.host) {
+		return
+	}
+	if b.partition != nil && !b.partition.owns(&d) {
+		return
+	}
+	if b.dedup != nil && b.dedup.duplicate(d.host, d.seq) {
+		return
+	}
+	if b.avail != nil && d.host != "" {
+		b.avail.observe(d.host, in.recvAt)
+	}
+	p := d.point
+	p.Tags = in.src.tags
+	if len(d.tags) > 0 {
+		p.Tags = mergeTags(in.src.tags, d.tags)
+	}
+	if b.tagFilter != nil {
+		p.Tags = b.tagFilter.filter(p.Tags)
+	}
+	if d.host != "" {
+		p.Tags = withHost(p.Tags, d.host)
+	}
+	if b.ingestTime {
+		p.TimeNano = in.recvAt.UnixNano()
+	}
+	if len(b.batch) == 0 {
+		b.batchStart = b.clock.Now()
+	}
+	if b.raw {
+		b.batch = append(b.batch, p)
+		for _, e := range d.extra {
+			if b.tagFilter != nil {
+				e.Tags = b.tagFilter.filter(e.Tags)
+			}
+			e.Tags = mergeTags(e.Tags, p.Tags) // the point's own tags (gpu, device, a sample's) win
+			e.TimeNano = p.TimeNano
+			b.batch = append(b.batch, e)
+		}
+	}
+	if b.rollup != nil {
+		if rp, ok := b.rollup.add(p, b.held); ok {
+			b.emitRollup(rp)
+		}
+	}
+	if b.live != nil {
+		b.live.publish(liveSample{TimeMs: p.TimeNano / 1e6, CPU: p.CPU, Mem: p.Mem, Host: d.host})
+	}
+	if b.points != nil {
+		b.points.publish(p, d.extra)
+	}
+	if b.alerts != nil {
+		b.alerts.observe(p, d.extra)
+	}
+	if b.anomalies != nil {
+		b.anomalies.observe(p, d.extra)
+	}
+	// Core engine: Redis recv → point created (batch entry), including time spent queued.
+	internal := b.clock.Since(in.recvAt)
+	b.internal.Add(internal)
+	b.sinceReport++
+	if b.traces != nil && d.traceParent != "" {
+		b.traces.add(ctx, in, d.traceParent, b.clock.Now())
+	}
+
+	// Recorded before a size flush, so the sample is echoed with the batch it is in.
+	if b.echo != nil && d.sendTimeNano != 0 {
+		b.echo.add(in.src, d.sendTimeNano)
+	}
+
+	if len(b.batch) >= influxBatchSize {
+		b.flush(ctx, triggerSize)
+	}
+
+	var e2e time.Duration
+	if d.sendTimeNano != 0 {
+		e2e = b.clock.Since(time.Unix(0, d.sendTimeNano))
+		b.latency.Add(e2e)
+		b.metrics.e2e.observe(e2e)
+	}
+	if b.byFormat != nil {
+		b.byFormat.record(d.format, internal, e2e)
+	}
+	if b.sinceReport >= statsReportEvery {
+		b.report()
+	}
+}
+
+// timerFlushDue reports whether a timer tick should flush the pending batch.
+func (b *batcher) timerFlushDue() bool {
+	if len(b.batch) == 0 {
+		return false
+	}
+	return len(b.batch) >= b.minBatch || b.clock.Since(b.batchStart) >= b.maxAge
+}
+
+// flush hands the current batch to Influx and the extra sinks, then reuses it. With
+// -stream the messages behind the batch are acked once it is written to Influx, or
+// without Influx, to every extra sink; a failed write leaves them pending for the next
+// start.
+func (b *batcher) flush(ctx context.Context, trigger flushTrigger) {
+	var acks ackSet
+	if len(b.acks) > 0 {
+		acks, b.acks = b.acks, make(ackSet)
+	}
+	ackCtx := context.WithoutCancel(ctx) // acks still go out while shutting down
+	var echoed echoBatch
+	if b.echo != nil {
+		echoed = b.echo.take()
+	}
+	var traced writeSpans
+	if b.traces != nil {
+		traced = b.traces.take(b.clock.Now(), len(b.batch))
+	}
+	if len(b.batch) == 0 {
+		if acks != nil {
+			acks.ack(ackCtx, b.ackStats) // everything handled since the last flush was dropped
+		}
+		if echoed != nil {
+			b.echo.send(echoed) // samples that only fed the rollup
+		}
+		return
+	}
+	b.flushes.record(len(b.batch), trigger)
+	b.metrics.flushes.Add(1)
+	// done runs once the batch is stored, or dropped with err: by an Influx writer, or
+	// here after the extra sinks when Influx isn't configured.
+	var done func(err error)
+	if acks != nil || echoed != nil || traced != nil {
+		done = func(err error) {
+			traced.end(err)
+			if err != nil {
+				return
+			}
+			if acks != nil {
+				acks.ack(ackCtx, b.ackStats)
+			}
+			if echoed != nil {
+				b.echo.send(echoed)
+			}
+		}
+	}
+	if b.influx != nil {
+		b.influx.submit(b.batch, done)
+	}
+	var sinkErrs []error
+	for _, s := range b.sinks {
+		if err := s.WriteBatch(ctx, b.batch); err != nil {
+			slog.Error("Sink write failed", "sink", s.name, "err", err)
+			sinkErrs = append(sinkErrs, fmt.Errorf("%s sink: %w", s.name, err))
+		}
+	}
+	if b.influx == nil && done != nil {
+		done(errors.Join(sinkErrs...))
+	}
+	b.batch = b.batch[:0]
+}
+
+// report logs the stats windows and resets them.
+func (b *batcher) report() {
+	printLatencyStats("E2E", b.latency.Samples())
+	printLatencyStats("INTERNAL", b.internal.Samples())
+	if b.byFormat != nil {
+		b.byFormat.report()
+	}
+	b.flushes.report()
+	b.decodeTime.report()
+	if b.influx != nil {
+		b.influx.report()
+	}
+	b.queue.report()
+	b.latency.Reset()
+	b.internal.Reset()
+	b.sinceReport = 0
+}
+
+// dump logs the current latency windows and counters without resetting anything, so
+// the regular report still covers its full window.
+func (b *batcher) dump() {
+	slog.Info("STATS_SNAPSHOT", "pending_points", len(b.batch), "samples_since_report", b.sinceReport)
+	printLatencyStats("E2E", b.latency.Samples())
+	printLatencyStats("INTERNAL", b.internal.Samples())
+	if b.influx != nil {
+		b.influx.report()
+	}
+	b.queue.report()
+}
+
+// mergeTags combines the source's tags with a sample's own, sorted by key (the order
+// Influx prefers). Source tags are server configuration, so they win on a clash.
+func mergeTags(src, agent []sink.Tag) []sink.Tag {
+	out := append(make([]sink.Tag, 0, len(src)+len(agent)), src...)
+	for _, t := range agent {
+		if !slices.ContainsFunc(src, func(s sink.Tag) bool { return s.Key == t.Key }) {
+			out = append(out, t)
+		}
+	}
+	slices.SortFunc(out, func(a, b sink.Tag) int { return strings.Compare(a.Key, b.Key) })
+	return out
+}
+
+// hostTag is the tag a sample's Host is written as.
+const hostTag = "host"
+
+// withHost returns tags plus host, in key order, so samples from different agents are
+// separate series. The sample's own Host replaces any host tag from the source or the
+// agent's -tags, and isn't subject to TAG_ALLOWLIST. tags is not modified.
+func withHost(tags []sink.Tag, host string) []sink.Tag {
+	out := make([]sink.Tag, 0, len(tags)+1)
+	for _, t := range tags {
+		if t.Key != hostTag {
+			out = append(out, t)
+		}
+	}
+	i, _ := slices.BinarySearchFunc(out, hostTag, func(t sink.Tag, key string) int { return strings.Compare(t.Key, key) })
+	return slices.Insert(out, i, sink.Tag{Key: hostTag, Value: host})
+}
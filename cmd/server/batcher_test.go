@@ -0,0 +1,477 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/clock"
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/sink"
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/stats"
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/transport"
+)
+
+var testStart = time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+// newTestBatcher returns a batcher on a fake clock that writes raw points to sinks,
+// without Influx, and acks like -stream. Its constructors don't publish expvars, so
+// tests can build as many as they like.
+func newTestBatcher(t *testing.T, clk clock.Clock, sinks ...namedSink) *batcher {
+	t.Helper()
+	return &batcher{
+		clock:       clk,
+		queue:       newTestQueue(),
+		sinks:       sinks,
+		inputFormat: inputAuto,
+		raw:         true,
+		acks:        make(ackSet),
+		ackStats:    &ackStats{},
+		metrics:     &pipelineMetrics{},
+		latency:     stats.NewWindow(16),
+		internal:    stats.NewWindow(16),
+		decodeTime:  newDecodeTimer(0, 16),
+		flushReq:    make(chan chan int),
+		drainReq:    make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+}
+
+// newTestQueue is a receive queue for the "metrics" channel. Unlike newRecvQueues it
+// publishes nothing.
+func newTestQueue() *recvQueues {
+	q := &recvQueue{ch: make(chan inbound, 64)}
+	return &recvQueues{
+		levels:    []*recvQueue{q},
+		byChannel: map[string]*recvQueue{"metrics": q},
+		ready:     make(chan struct{}, 64),
+		lastRecv:  map[string]*atomic.Int64{"metrics": new(atomic.Int64)},
+	}
+}
+
+// setBatchSize sets influxBatchSize for the test.
+func setBatchSize(t *testing.T, n int) {
+	old := influxBatchSize
+	influxBatchSize = n
+	t.Cleanup(func() { influxBatchSize = old })
+}
+
+// events is the order writes and acks happened in, shared by a fakeSink and fakeAcker.
+type events struct {
+	mu  sync.Mutex
+	log []string
+}
+
+func (e *events) add(s ...string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.log = append(e.log, s...)
+}
+
+func (e *events) get() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return slices.Clone(e.log)
+}
+
+// fakeAcker is a subscription that records acked IDs as "ack:<id>".
+type fakeAcker struct{ ev *events }
+
+func (a fakeAcker) Ack(_ context.Context, ds ...transport.Delivery) error {
+	for _, d := range ds {
+		a.ev.add("ack:" + d.ID)
+	}
+	return nil
+}
+
+// fakeSink records each batch it stores as "write", or fails while fail is set.
+type fakeSink struct {
+	ev   *events
+	mu   sync.Mutex
+	fail bool
+	n    int // points written
+}
+
+func (s *fakeSink) WriteBatch(_ context.Context, points []sink.Point) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fail {
+		return errors.New("sink down")
+	}
+	s.n += len(points)
+	s.ev.add("write")
+	return nil
+}
+
+func (s *fakeSink) Close() error { return nil }
+
+func (s *fakeSink) setFail(fail bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fail = fail
+}
+
+func (s *fakeSink) points() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.n
+}
+
+// message returns a delivery of payload with ID id from acker.
+func message(acker transport.Acker, id string, payload []byte) inbound {
+	return inbound{payload: string(payload), recvAt: testStart, src: &source{addr: "test"}, channel: "metrics", acker: acker, id: id}
+}
+
+func binarySample(ts int64) []byte {
+	return transport.EncodeMetric(&transport.Metric{Timestamp: ts, CPUUsage: 1, MemUsage: 2})
+}
+
+func TestAckOnlyAfterWrite(t *testing.T) {
+	setBatchSize(t, 100)
+	ev := &events{}
+	snk := &fakeSink{ev: ev}
+	b := newTestBatcher(t, clock.NewFake(testStart), namedSink{"fake", snk})
+	acker := fakeAcker{ev}
+	ctx := context.Background()
+
+	b.handle(ctx, message(acker, "1-0", binarySample(1)))
+	b.handle(ctx, message(acker, "2-0", binarySample(2)))
+	// The server crashing here loses nothing: neither entry is acked, so both stay
+	// pending in the group and are read again on restart.
+	if got := ev.get(); len(got) != 0 {
+		t.Fatalf("before the flush: %v, want nothing written or acked", got)
+	}
+
+	b.flush(ctx, triggerManual)
+	want := []string{"write", "ack:1-0", "ack:2-0"}
+	if got := ev.get(); !slices.Equal(got, want) {
+		t.Errorf("after the flush: %v, want %v", got, want)
+	}
+	if got := b.ackStats.acked.Load(); got != 2 {
+		t.Errorf("stream_acked = %d, want 2", got)
+	}
+}
+
+func TestFailedWriteLeavesEntriesPending(t *testing.T) {
+	setBatchSize(t, 100)
+	ev := &events{}
+	snk := &fakeSink{ev: ev, fail: true}
+	b := newTestBatcher(t, clock.NewFake(testStart), namedSink{"fake", snk})
+	acker := fakeAcker{ev}
+	ctx := context.Background()
+
+	b.handle(ctx, message(acker, "1-0", binarySample(1)))
+	b.flush(ctx, triggerManual)
+	if got := ev.get(); len(got) != 0 {
+		t.Fatalf("after a failed write: %v, want nothing acked", got)
+	}
+
+	// The next batch is written, but the failed one's entry stays pending for the next
+	// start rather than being acked with it.
+	snk.setFail(false)
+	b.handle(ctx, message(acker, "2-0", binarySample(2)))
+	b.flush(ctx, triggerManual)
+	want := []string{"write", "ack:2-0"}
+	if got := ev.get(); !slices.Equal(got, want) {
+		t.Errorf("after the next write: %v, want %v", got, want)
+	}
+}
+
+func TestDroppedMessagesAckedWithNextFlush(t *testing.T) {
+	setBatchSize(t, 100)
+	ev := &events{}
+	b := newTestBatcher(t, clock.NewFake(testStart), namedSink{"fake", &fakeSink{ev: ev}})
+	acker := fakeAcker{ev}
+	ctx := context.Background()
+
+	b.handle(ctx, message(acker, "1-0", []byte("not a metric")))
+	if got := ev.get(); len(got) != 0 {
+		t.Fatalf("before the flush: %v, want nothing acked", got)
+	}
+	b.flush(ctx, triggerTimer)
+	want := []string{"ack:1-0"}
+	if got := ev.get(); !slices.Equal(got, want) {
+		t.Errorf("after the flush: %v, want %v", got, want)
+	}
+}
+
+func TestFrameSplitAcrossBatchesAckedWithItsLastPoint(t *testing.T) {
+	setBatchSize(t, 3)
+	ev := &events{}
+	snk := &fakeSink{ev: ev}
+	b := newTestBatcher(t, clock.NewFake(testStart), namedSink{"fake", snk})
+	acker := fakeAcker{ev}
+	ctx := context.Background()
+
+	b.handle(ctx, message(acker, "1-0", binarySample(1)))
+	b.handle(ctx, message(acker, "2-0", binarySample(2)))
+	// Three records: the first fills the batch, the other two go into the next one.
+	frame := transport.EncodeBinaryBatch([]*transport.Metric{{Timestamp: 3}, {Timestamp: 4}, {Timestamp: 5}})
+	b.handle(ctx, message(acker, "3-0", frame))
+	want := []string{"write", "ack:1-0", "ack:2-0"}
+	if got := ev.get(); !slices.Equal(got, want) {
+		t.Fatalf("after the size flush: %v, want %v (3-0 still has points pending)", got, want)
+	}
+
+	// A crash now must leave 3-0 pending. A failed write of its last points does too.
+	snk.setFail(true)
+	b.flush(ctx, triggerManual)
+	if got := ev.get(); !slices.Equal(got, want) {
+		t.Fatalf("after a failed write: %v, want %v", got, want)
+	}
+
+	snk.setFail(false)
+	b.handle(ctx, message(acker, "4-0", binarySample(6)))
+	b.flush(ctx, triggerManual)
+	want = append(want, "write", "ack:4-0")
+	if got := ev.get(); !slices.Equal(got, want) {
+		t.Errorf("after the next write: %v, want %v", got, want)
+	}
+}
+
+// waitFor polls cond until it holds, failing the test after a few seconds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestTimerFlushDue(t *testing.T) {
+	setBatchSize(t, 100)
+	clk := clock.NewFake(testStart)
+	b := newTestBatcher(t, clk)
+	b.minBatch, b.maxAge = 3, 5*time.Second
+	ctx := context.Background()
+
+	if b.timerFlushDue() {
+		t.Error("due with an empty batch")
+	}
+	b.handle(ctx, message(nil, "", binarySample(1)))
+	clk.Advance(4 * time.Second)
+	if b.timerFlushDue() {
+		t.Error("due with 1 point, 4s old")
+	}
+	clk.Advance(time.Second)
+	if !b.timerFlushDue() {
+		t.Error("not due with 1 point, 5s old")
+	}
+
+	b.flush(ctx, triggerTimer)
+	for i := int64(0); i < 3; i++ {
+		b.handle(ctx, message(nil, "", binarySample(i)))
+	}
+	if !b.timerFlushDue() {
+		t.Error("not due with minBatch points")
+	}
+}
+
+func TestFlushTimerOnFakeClock(t *testing.T) {
+	setBatchSize(t, 100)
+	clk := clock.NewFake(testStart)
+	snk := &fakeSink{ev: &events{}}
+	b := newTestBatcher(t, clk, namedSink{"fake", snk})
+	b.flushEvery, b.minBatch, b.maxAge = time.Second, 1, time.Minute
+	go b.run(context.Background())
+	defer func() {
+		close(b.drainReq)
+		<-b.done
+	}()
+
+	b.queue.push("metrics", message(nil, "", binarySample(1)))
+	waitFor(t, "the message to be handled", func() bool { return b.metrics.consumed.Load() == 1 })
+	if n := snk.points(); n != 0 {
+		t.Fatalf("%d points written before the tick", n)
+	}
+	clk.Advance(time.Second)
+	waitFor(t, "the timer flush", func() bool { return snk.points() == 1 })
+}
+
+func TestLegacyBinaryIsOptIn(t *testing.T) {
+	// A record as agents sent it before framing: the fixed layout with no header.
+	rec := transport.EncodeMetric(&transport.Metric{Timestamp: 7, CPUUsage: 1.5, MemUsage: 2.5})
+	rec = rec[len(rec)-transport.BinarySize:]
+
+	if d, err := decodePayload(rec, inputAuto, false); err == nil {
+		t.Errorf("unframed record decoded as %+v without -legacy-binary", d.point)
+	}
+	for _, tt := range []struct {
+		inputFormat string
+		legacy      bool
+	}{{inputAuto, true}, {transport.FormatBinary, false}} {
+		d, err := decodePayload(rec, tt.inputFormat, tt.legacy)
+		if err != nil || d.point.TimeNano != 7e9 || d.point.CPU != 1.5 || d.point.Mem != 2.5 {
+			t.Errorf("-input-format=%s -legacy-binary=%v: decoded %+v, %v", tt.inputFormat, tt.legacy, d.point, err)
+		}
+	}
+}
+
+func TestEchoIncludesSampleThatFillsBatch(t *testing.T) {
+	setBatchSize(t, 2)
+	clk := clock.NewFake(testStart)
+	b := newTestBatcher(t, clk, namedSink{"fake", &fakeSink{ev: &events{}}})
+	b.echo = &echoer{out: make(chan echoBatch, 4)}
+	src := &source{addr: "test"}
+	ctx := context.Background()
+
+	sent := []int64{testStart.Add(-30 * time.Millisecond).UnixNano(), testStart.Add(-20 * time.Millisecond).UnixNano()}
+	for i, st := range sent {
+		in := message(nil, "", transport.EncodeMetric(&transport.Metric{Timestamp: int64(i + 1), SendTimeUnixNano: st}))
+		in.src = src
+		b.handle(ctx, in)
+	}
+
+	// The second sample filled the batch, so it must be echoed with it rather than
+	// left for the next one.
+	select {
+	case got := <-b.echo.out:
+		if !slices.Equal(got[src], sent) {
+			t.Fatalf("echoed send times %v, want %v", got[src], sent)
+		}
+		// What cmd/bench -measure reports for the last sample of the batch.
+		if rtt := clk.Since(time.Unix(0, got[src][1])); rtt != 20*time.Millisecond {
+			t.Errorf("round trip = %v, want 20ms", rtt)
+		}
+	default:
+		t.Fatal("nothing echoed after the size flush")
+	}
+	if left := b.echo.take(); left != nil {
+		t.Errorf("left for the next batch: %v", left)
+	}
+}
+
+// pointSink keeps every point it is given.
+type pointSink struct{ points []sink.Point }
+
+func (s *pointSink) WriteBatch(_ context.Context, points []sink.Point) error {
+	s.points = append(s.points, points...)
+	return nil
+}
+
+func (s *pointSink) Close() error { return nil }
+
+func TestTagAllowlistFiltersSampleTags(t *testing.T) {
+	setBatchSize(t, 100)
+	snk := &pointSink{}
+	b := newTestBatcher(t, clock.NewFake(testStart), namedSink{"points", snk})
+	b.tagFilter = &tagFilter{clock: b.clock, allowed: map[string]bool{"by": true, "rank": true}, pending: make(map[string]uint64)}
+	ctx := context.Background()
+
+	payload, err := transport.JSONEncoder{}.Encode(&transport.Metric{
+		Timestamp: 1,
+		Samples: []transport.Sample{{
+			Measurement: "process_stats",
+			Tags:        map[string]string{"by": "cpu", "rank": "1", "name": "postgres"}, // as the proc collector sends it
+			Fields:      map[string]float64{"cpu_percent": 80},
+		}},
+		GPUs: []transport.GPU{{Index: 0, UtilPercent: 50}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.handle(ctx, message(nil, "", payload))
+	b.flush(ctx, triggerManual)
+
+	tags := make(map[string][]sink.Tag)
+	for _, p := range snk.points {
+		tags[p.Measurement] = p.Tags
+	}
+	if want := []sink.Tag{{Key: "by", Value: "cpu"}, {Key: "rank", Value: "1"}}; !slices.Equal(tags["process_stats"], want) {
+		t.Errorf("process_stats tags = %v, want %v", tags["process_stats"], want)
+	}
+	if want := []sink.Tag{{Key: "gpu", Value: "0"}}; !slices.Equal(tags[gpuMeasurement], want) {
+		t.Errorf("%s tags = %v, want %v (exempt)", gpuMeasurement, tags[gpuMeasurement], want)
+	}
+	if got := b.tagFilter.dropped.Load(); got != 1 {
+		t.Errorf("tags_dropped = %d, want 1", got)
+	}
+}
+
+// rollupSample is a fixed binary frame timestamped offset after testStart.
+func rollupSample(offset time.Duration) []byte {
+	return transport.EncodeMetric(&transport.Metric{Timestamp: testStart.Add(offset).Unix(), CPUUsage: 10, MemUsage: 20})
+}
+
+// newRollupBatcher is a test batcher that writes only one-minute rollups (OUTPUT_RAW=false).
+func newRollupBatcher(t *testing.T, clk clock.Clock, snk sink.Sink) *batcher {
+	b := newTestBatcher(t, clk, namedSink{"fake", snk})
+	b.raw = false
+	b.rollup = newRollup(time.Minute)
+	return b
+}
+
+func TestRollupHoldsAcksUntilIntervalWritten(t *testing.T) {
+	setBatchSize(t, 100)
+	ev := &events{}
+	snk := &fakeSink{ev: ev}
+	b := newRollupBatcher(t, clock.NewFake(testStart), snk)
+	acker := fakeAcker{ev}
+	ctx := context.Background()
+
+	// testStart is 03:04:05, so these fall in the 03:04 interval.
+	b.handle(ctx, message(acker, "1-0", rollupSample(0)))
+	b.handle(ctx, message(acker, "2-0", rollupSample(10*time.Second)))
+	b.flush(ctx, triggerTimer)
+	// Nothing is written yet, so a crash here must leave both entries pending.
+	if got := ev.get(); len(got) != 0 {
+		t.Fatalf("with the interval still open: %v, want nothing written or acked", got)
+	}
+
+	b.handle(ctx, message(acker, "3-0", rollupSample(time.Minute))) // closes 03:04
+	b.flush(ctx, triggerTimer)
+	want := []string{"write", "ack:1-0", "ack:2-0"}
+	if got := ev.get(); !slices.Equal(got, want) {
+		t.Fatalf("after the interval was written: %v, want %v", got, want)
+	}
+
+	// Shutdown writes the open 03:05 interval, and only then acks its entry.
+	b.drain(ctx)
+	want = append(want, "write", "ack:3-0")
+	if got := ev.get(); !slices.Equal(got, want) {
+		t.Errorf("after shutdown: %v, want %v", got, want)
+	}
+	if got := snk.points(); got != 2 {
+		t.Errorf("wrote %d rollup points, want 2", got)
+	}
+}
+
+func TestRollupSweepsQuietSeries(t *testing.T) {
+	setBatchSize(t, 100)
+	ev := &events{}
+	snk := &fakeSink{ev: ev}
+	clk := clock.NewFake(testStart)
+	b := newRollupBatcher(t, clk, snk)
+	acker := fakeAcker{ev}
+	ctx := context.Background()
+
+	b.handle(ctx, message(acker, "1-0", rollupSample(0)))
+	b.handle(ctx, message(nil, "", rollupSample(0)))
+	b.handle(ctx, message(acker, "2-0", []byte("not a metric")))
+	b.sweepRollup(ctx)
+	b.flush(ctx, triggerTimer)
+	// The dropped entry has nothing to wait for; the sample's interval is still open.
+	want := []string{"ack:2-0"}
+	if got := ev.get(); !slices.Equal(got, want) {
+		t.Fatalf("before the interval expired: %v, want %v", got, want)
+	}
+
+	// The series sends nothing more. Once the interval has been over for another
+	// interval, the sweep writes it anyway.
+	clk.Advance(2 * time.Minute)
+	b.sweepRollup(ctx)
+	b.flush(ctx, triggerTimer)
+	want = append(want, "write", "ack:1-0")
+	if got := ev.get(); !slices.Equal(got, want) {
+		t.Errorf("after the sweep: %v, want %v", got, want)
+	}
+	if len(b.rollup.series) != 0 {
+		t.Errorf("%d series still open", len(b.rollup.series))
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"log/slog"
+	"math"
+	"sort"
+)
+
+// flushTrigger records why a batch was flushed.
+type flushTrigger int
+
+const (
+	triggerSize     flushTrigger = iota // batch reached influxBatchSize
+	triggerTimer                        // flush interval elapsed with a partial batch
+	triggerManual                       // requested via POST /flush
+	triggerShutdown                     // final flush at shutdown (counted as manual)
+)
+
+// batchStats tracks batch sizes at flush time so influxBatchSize and the flush
+// interval can be tuned from real traffic. It is owned by the consumer goroutine.
+type batchStats struct {
+	sizes    []int
+	bySize   int
+	byTimer  int
+	byManual int
+}
+
+func (b *batchStats) record(size int, trigger flushTrigger) {
+	b.sizes = append(b.sizes, size)
+	switch trigger {
+	case triggerTimer:
+		b.byTimer++
+	case triggerManual, triggerShutdown:
+		b.byManual++
+	default:
+		b.bySize++
+	}
+}
+
+// report logs BATCH_STATS for the flushes since the last report and resets.
+func (b *batchStats) report() {
+	n := len(b.sizes)
+	if n == 0 {
+		return
+	}
+	sort.Ints(b.sizes)
+	total := 0
+	for _, s := range b.sizes {
+		total += s
+	}
+	avg := float64(total) / float64(n)
+	slog.Info("BATCH_STATS", "flushes", n, "size_triggered", b.bySize, "timer_triggered", b.byTimer, "manual_triggered", b.byManual,
+		"avg_size", math.Round(avg*10)/10, "fill_ratio", math.Round(100*avg/float64(influxBatchSize))/100,
+		"p50", b.sizes[rank(n, 0.50)], "p90", b.sizes[rank(n, 0.90)], "p99", b.sizes[rank(n, 0.99)])
+	b.sizes = b.sizes[:0]
+	b.bySize, b.byTimer, b.byManual = 0, 0, 0
+}
+
+// rank is the nearest-rank index for percentile p of n sorted values.
+func rank(n int, p float64) int {
+	r := int(math.Ceil(p*float64(n))) - 1
+	return max(0, min(r, n-1))
+}
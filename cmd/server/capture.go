@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime/pprof"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const maxCaptureSeconds = 300
+
+// captureBusy bounds on-disk CPU captures to one at a time.
+var captureBusy atomic.Bool
+
+// captureHandler serves POST /debug/capture?seconds=N: it records a CPU profile for N seconds
+// (default 30) into dir and responds with the file name, so profiles can be grabbed during an
+// incident without a pprof scraper attached.
+func captureHandler(dir string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		seconds := 30
+		if s := r.URL.Query().Get("seconds"); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n <= 0 || n > maxCaptureSeconds {
+				http.Error(w, fmt.Sprintf("seconds must be between 1 and %d", maxCaptureSeconds), http.StatusBadRequest)
+				return
+			}
+			seconds = n
+		}
+		if !captureBusy.CompareAndSwap(false, true) {
+			http.Error(w, "capture already in progress", http.StatusConflict)
+			return
+		}
+		defer captureBusy.Store(false)
+
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		name := filepath.Join(dir, fmt.Sprintf("cpu-%s.pb", time.Now().Format("20060102-150405")))
+		f, err := os.Create(name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer f.Close()
+		// Fails if /debug/pprof/profile is already sampling.
+		if err := pprof.StartCPUProfile(f); err != nil {
+			os.Remove(name)
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		slog.Info("CPU capture started", "file", name, "seconds", seconds)
+		select {
+		case <-time.After(time.Duration(seconds) * time.Second):
+		case <-r.Context().Done():
+		}
+		pprof.StopCPUProfile()
+		slog.Info("CPU capture written", "file", name)
+		fmt.Fprintln(w, name)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"log/slog"
+
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/stats"
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/transport"
+)
+
+// decodeTimer samples per-message decode time, split by wire format, to show what the
+// binary path actually saves over JSON under real traffic. Only every Nth message is
+// timed so the clock reads stay off the common path.
+type decodeTimer struct {
+	every   int
+	counter int
+	windows map[string]*stats.Window
+}
+
+// newDecodeTimer samples one in every messages; every == 0 disables sampling.
+func newDecodeTimer(every, sampleCap int) *decodeTimer {
+	t := &decodeTimer{every: every, windows: make(map[string]*stats.Window)}
+	for _, format := range transport.Formats {
+		t.windows[format] = stats.NewWindow(sampleCap)
+	}
+	return t
+}
+
+// sample reports whether the next message should be timed.
+func (t *decodeTimer) sample() bool {
+	if t.every == 0 {
+		return false
+	}
+	t.counter++
+	if t.counter < t.every {
+		return false
+	}
+	t.counter = 0
+	return true
+}
+
+// report logs DECODE_STATS per format (in nanoseconds) and resets the windows.
+func (t *decodeTimer) report() {
+	for _, format := range transport.Formats {
+		w := t.windows[format]
+		if w.Len() == 0 {
+			continue
+		}
+		pct := stats.Summarize(w.Samples())
+		slog.Info("DECODE_STATS", "format", format, "sampled", w.Len(), "p50_ns", pct.P50.Nanoseconds(), "p90_ns", pct.P90.Nanoseconds(), "p99_ns", pct.P99.Nanoseconds())
+		w.Reset()
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"expvar"
+	"sync/atomic"
+)
+
+// seqDedup drops messages whose (host, seq) was already seen, so samples resent by an
+// agent's retry buffer aren't counted twice. Each host keeps only its last window
+// sequence numbers, which bounds memory per host at the cost of missing duplicates
+// that arrive more than window messages late. Messages without a host or seq pass
+// through untouched. It is owned by the consumer goroutine.
+type seqDedup struct {
+	window  int
+	hosts   map[string]*seqWindow
+	dropped atomic.Uint64
+}
+
+// seqWindow is a FIFO set of the most recent sequence numbers from one host.
+type seqWindow struct {
+	seen  map[uint64]struct{}
+	order []uint64
+	next  int
+}
+
+func newSeqDedup(window int) *seqDedup {
+	d := &seqDedup{window: window, hosts: make(map[string]*seqWindow)}
+	expvar.Publish("dedup_dropped", expvar.Func(func() any { return d.dropped.Load() }))
+	return d
+}
+
+// duplicate records (host, seq) and reports whether it had already been seen.
+func (d *seqDedup) duplicate(host string, seq uint64) bool {
+	if host == "" || seq == 0 {
+		return false
+	}
+	w := d.hosts[host]
+	if w == nil {
+		w = &seqWindow{seen: make(map[uint64]struct{}, d.window), order: make([]uint64, 0, d.window)}
+		d.hosts[host] = w
+	}
+	if _, ok := w.seen[seq]; ok {
+		d.dropped.Add(1)
+		return true
+	}
+	if len(w.order) < d.window {
+		w.order = append(w.order, seq)
+	} else {
+		delete(w.seen, w.order[w.next])
+		w.order[w.next] = seq
+		w.next = (w.next + 1) % d.window
+	}
+	w.seen[seq] = struct{}{}
+	return false
+}
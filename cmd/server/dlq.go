@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/dlq"
+)
+
+const (
+	// deadLetterQueueDepth bounds the entries waiting to be pushed to Redis.
+	deadLetterQueueDepth = 1024
+	// deadLetterPushMax is the most entries sent in one round-trip.
+	deadLetterPushMax = 100
+	// deadLetterPushTimeout bounds one push, so a hung Redis can't stall close.
+	deadLetterPushTimeout = 5 * time.Second
+	// deadLetterErrLogEvery rate-limits the log line for failed pushes.
+	deadLetterErrLogEvery = time.Minute
+)
+
+// deadLetters pushes DLQ entries (DLQ_KEY) from its own goroutine, so neither the
+// batcher nor the Influx writers ever wait on the dead-letter Redis. Entries that
+// don't fit in its queue, or that Redis doesn't take, are dropped and counted.
+type deadLetters struct {
+	queue   *dlq.Queue
+	entries chan dlq.Entry
+	done    chan struct{}
+	lastErr time.Time // owned by run
+
+	pushed  atomic.Uint64
+	dropped atomic.Uint64
+}
+
+func newDeadLetters(q *dlq.Queue) *deadLetters {
+	d := &deadLetters{queue: q, entries: make(chan dlq.Entry, deadLetterQueueDepth), done: make(chan struct{})}
+	expvar.Publish("dlq_pushed", expvar.Func(func() any { return d.pushed.Load() }))
+	expvar.Publish("dlq_dropped", expvar.Func(func() any { return d.dropped.Load() }))
+	go d.run()
+	return d
+}
+
+// add queues e without blocking. It is safe to call from any goroutine until close.
+func (d *deadLetters) add(e dlq.Entry) {
+	select {
+	case d.entries <- e:
+	default:
+		d.dropped.Add(1)
+	}
+}
+
+func (d *deadLetters) run() {
+	defer close(d.done)
+	batch := make([]dlq.Entry, 0, deadLetterPushMax)
+	for e := range d.entries {
+		batch = append(batch[:0], e)
+	more:
+		for len(batch) < deadLetterPushMax {
+			select {
+			case e, ok := <-d.entries:
+				if !ok {
+					break more
+				}
+				batch = append(batch, e)
+			default:
+				break more
+			}
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), deadLetterPushTimeout)
+		err := d.queue.Push(ctx, batch...)
+		cancel()
+		if err != nil {
+			d.dropped.Add(uint64(len(batch)))
+			if time.Since(d.lastErr) >= deadLetterErrLogEvery {
+				d.lastErr = time.Now()
+				slog.Warn("Dead-letter push failed", "key", d.queue.Key(), "err", err)
+			}
+			continue
+		}
+		d.pushed.Add(uint64(len(batch)))
+	}
+}
+
+// close pushes what is still queued and closes the Redis connection. Nothing may be
+// added after it.
+func (d *deadLetters) close() {
+	close(d.entries)
+	<-d.done
+	if err := d.queue.Close(); err != nil {
+		slog.Error("Dead-letter close failed", "err", err)
+	}
+}
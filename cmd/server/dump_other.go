@@ -0,0 +1,8 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// notifyDump is a no-op where SIGUSR1 doesn't exist (Windows).
+func notifyDump(chan<- os.Signal) {}
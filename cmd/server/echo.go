@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"sync/atomic"
+	"time"
+
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/transport"
+)
+
+const (
+	// echoQueueDepth bounds the written batches waiting to be echoed; beyond it echoes
+	// are dropped rather than holding up the writers.
+	echoQueueDepth = 64
+	// echoMaxPerBatch caps the send times echoed for one batch, which keeps each echo
+	// message a reasonable size; the rest of the batch goes unmeasured.
+	echoMaxPerBatch = 10000
+	echoTimeout     = 5 * time.Second
+)
+
+// echoBatch is the send times of one batch's samples, by the source they came from.
+type echoBatch map[*source][]int64
+
+// echoer publishes to ECHO_CHANNEL, once a batch has been written, the send times of the
+// samples in it, on the broker each sample came from. cmd/bench -measure listens there
+// to measure the full round trip: publish, broker, decode, batch, write. Samples without
+// a send time are not echoed. add and take are called from the batcher goroutine.
+type echoer struct {
+	channel string
+	trs     map[*source]transport.Transport
+
+	pending echoBatch
+	n       int
+	out     chan echoBatch
+
+	sent, dropped, failed atomic.Uint64
+}
+
+func newEchoer(channel string, sources []source, trs []transport.Transport) *echoer {
+	e := &echoer{channel: channel, trs: make(map[*source]transport.Transport, len(sources)), out: make(chan echoBatch, echoQueueDepth)}
+	for i := range sources {
+		e.trs[&sources[i]] = trs[i]
+	}
+	expvar.Publish("echo_sent", expvar.Func(func() any { return e.sent.Load() }))
+	expvar.Publish("echo_dropped", expvar.Func(func() any { return e.dropped.Load() }))
+	expvar.Publish("echo_failed", expvar.Func(func() any { return e.failed.Load() }))
+	go e.run()
+	return e
+}
+
+// add records the send time of a sample added to the pending batch.
+func (e *echoer) add(src *source, sendTimeNano int64) {
+	if e.n >= echoMaxPerBatch {
+		return
+	}
+	if e.pending == nil {
+		e.pending = make(echoBatch)
+	}
+	e.pending[src] = append(e.pending[src], sendTimeNano)
+	e.n++
+}
+
+// take returns what add recorded since the last take, nil if nothing.
+func (e *echoer) take() echoBatch {
+	b := e.pending
+	e.pending, e.n = nil, 0
+	return b
+}
+
+// send queues b to be echoed without blocking. It may be called from any goroutine.
+func (e *echoer) send(b echoBatch) {
+	select {
+	case e.out <- b:
+	default:
+		e.dropped.Add(1)
+	}
+}
+
+// run publishes queued echoes. A failed publish only loses a measurement, so it is
+// counted, not logged or retried.
+func (e *echoer) run() {
+	for b := range e.out {
+		for src, times := range b {
+			tr := e.trs[src]
+			if tr == nil {
+				continue
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), echoTimeout)
+			err := transport.PublishJSON(ctx, tr, e.channel, transport.Echo{SendTimes: times})
+			cancel()
+			if err != nil {
+				e.failed.Add(1)
+				continue
+			}
+			e.sent.Add(1)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/stats"
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/transport"
+)
+
+// formatStats splits message counts and latency by detected wire format, so a
+// migration (e.g. JSON → binary) can be checked while both producers are live.
+// Enabled with STATS_BY_FORMAT=true; owned by the batcher goroutine.
+type formatStats struct {
+	byFormat map[string]*formatWindow
+}
+
+type formatWindow struct {
+	count    int
+	latency  *stats.Window
+	internal *stats.Window
+}
+
+func newFormatStats(sampleCap int) *formatStats {
+	f := &formatStats{byFormat: make(map[string]*formatWindow)}
+	for _, format := range transport.Formats {
+		f.byFormat[format] = &formatWindow{latency: stats.NewWindow(sampleCap), internal: stats.NewWindow(sampleCap)}
+	}
+	return f
+}
+
+// record counts one message; e2e is zero when the producer sent no send time.
+func (f *formatStats) record(format string, internal, e2e time.Duration) {
+	w := f.byFormat[format]
+	w.count++
+	w.internal.Add(internal)
+	if e2e != 0 {
+		w.latency.Add(e2e)
+	}
+}
+
+// report logs FORMAT_STATS per format seen since the last report and resets.
+func (f *formatStats) report() {
+	for _, format := range transport.Formats {
+		w := f.byFormat[format]
+		if w.count == 0 {
+			continue
+		}
+		in := stats.Summarize(w.internal.Samples())
+		e2e := stats.Summarize(w.latency.Samples())
+		slog.Info("FORMAT_STATS", "format", format, "count", w.count, "internal_p50_us", in.P50.Microseconds(), "internal_p99_us", in.P99.Microseconds(),
+			"e2e_p50_us", e2e.P50.Microseconds(), "e2e_p99_us", e2e.P99.Microseconds())
+		w.count = 0
+		w.internal.Reset()
+		w.latency.Reset()
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/clock"
+)
+
+// Health statuses served on /healthz.
+const (
+	healthOK       = "ok"       // every broker instance connected
+	healthDegraded = "degraded" // some down; the others keep flowing
+	healthDown     = "down"     // all down: nothing is being received
+)
+
+// brokerHealth is the connection state of each broker instance as its receiver sees
+// it: connected from startup until a Receive fails, then disconnected until a message
+// arrives again. A reconnected subscription on a quiet channel therefore shows as
+// down until the next message; agents publish every few seconds, so that is brief.
+// It backs GET /healthz, broker_connected on /debug/vars and sentinel_broker_up on
+// /metrics.
+type brokerHealth struct {
+	clk    clock.Clock
+	order  []*source
+	states map[*source]*brokerState // fixed after newBrokerHealth; the states lock themselves
+}
+
+type brokerState struct {
+	connected atomic.Bool // read on every message, so up costs no lock once connected
+
+	mu       sync.Mutex
+	since    time.Time // when the current state began
+	failures int       // consecutive failed Receives
+	lastErr  string
+}
+
+// brokerStatus is one broker instance on /healthz.
+type brokerStatus struct {
+	Source    string    `json:"source"`
+	Connected bool      `json:"connected"`
+	Since     time.Time `json:"since"`
+	Failures  int       `json:"failures,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+func newBrokerHealth(clk clock.Clock, sources []source) *brokerHealth {
+	h := &brokerHealth{clk: clk, states: make(map[*source]*brokerState, len(sources))}
+	now := clk.Now()
+	for i := range sources {
+		st := &brokerState{since: now}
+		st.connected.Store(true)
+		h.order = append(h.order, &sources[i])
+		h.states[&sources[i]] = st
+	}
+	expvar.Publish("broker_connected", expvar.Func(func() any {
+		m := make(map[string]bool, len(h.order))
+		for _, s := range h.status() {
+			m[s.Source] = s.Connected
+		}
+		return m
+	}))
+	return h
+}
+
+// up records that src delivered a message.
+func (h *brokerHealth) up(src *source) {
+	st := h.states[src]
+	if st.connected.Load() {
+		return
+	}
+	st.mu.Lock()
+	if !st.connected.Load() {
+		st.since, st.failures, st.lastErr = h.clk.Now(), 0, ""
+		st.connected.Store(true)
+	}
+	st.mu.Unlock()
+}
+
+// down records a failed Receive on src.
+func (h *brokerHealth) down(src *source, err error) {
+	st := h.states[src]
+	st.mu.Lock()
+	if st.connected.Load() {
+		st.since = h.clk.Now()
+		st.connected.Store(false)
+	}
+	st.failures++
+	st.lastErr = err.Error()
+	st.mu.Unlock()
+}
+
+// status returns every broker instance's state, in REDIS_ADDR order, and the overall
+// status.
+func (h *brokerHealth) status() []brokerStatus {
+	out := make([]brokerStatus, len(h.order))
+	for i, src := range h.order {
+		st := h.states[src]
+		st.mu.Lock()
+		out[i] = brokerStatus{Source: src.String(), Connected: st.connected.Load(), Since: st.since, Failures: st.failures, Error: st.lastErr}
+		st.mu.Unlock()
+	}
+	return out
+}
+
+func overallHealth(brokers []brokerStatus) string {
+	down := 0
+	for _, b := range brokers {
+		if !b.Connected {
+			down++
+		}
+	}
+	switch {
+	case down == 0:
+		return healthOK
+	case down < len(brokers):
+		return healthDegraded
+	default:
+		return healthDown
+	}
+}
+
+// handler serves GET /healthz: the overall status and each broker instance as JSON,
+// with 503 once no instance is connected, so a probe can restart or route around a
+// server that has stopped receiving.
+func (h *brokerHealth) handler(w http.ResponseWriter, r *http.Request) {
+	brokers := h.status()
+	status := overallHealth(brokers)
+	w.Header().Set("Content-Type", "application/json")
+	if status == healthDown {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(struct {
+		Status  string         `json:"status"`
+		Brokers []brokerStatus `json:"brokers"`
+	}{status, brokers})
+}
+
+const (
+	defaultReadyMaxLag       = 30 * time.Second
+	defaultReadyMinWriteRate = 0.5
+)
+
+// readiness backs GET /readyz: whether the server is doing its job rather than just
+// running. It is ready while at least one broker instance is connected, the consumer
+// is no more than maxLag behind, and at least minWriteRate of the recent Influx batches
+// were written. Once shutdown begins it is not, so nothing waits on a server that is
+// about to go.
+type readiness struct {
+	clk          clock.Clock
+	brokers      *brokerHealth
+	queues       *recvQueues
+	influx       *influxWriter // nil without INFLUX_URL
+	maxLag       time.Duration
+	minWriteRate float64
+	draining     atomic.Bool
+}
+
+// readyCheck is one condition on /readyz.
+type readyCheck struct {
+	Name   string `json:"name"`
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail"`
+}
+
+func (rd *readiness) checks() []readyCheck {
+	connected := 0
+	brokers := rd.brokers.status()
+	for _, b := range brokers {
+		if b.Connected {
+			connected++
+		}
+	}
+	checks := []readyCheck{{Name: "brokers", OK: connected > 0, Detail: fmt.Sprintf("%d of %d connected", connected, len(brokers))}}
+	lag := rd.queues.lag(rd.clk.Now())
+	checks = append(checks, readyCheck{Name: "consumer_lag", OK: lag <= rd.maxLag, Detail: fmt.Sprintf("%s behind, at most %s", lag.Round(time.Millisecond), rd.maxLag)})
+	if rd.influx != nil {
+		written, total := rd.influx.recentOutcomes()
+		checks = append(checks, readyCheck{
+			Name:   "influx_writes",
+			OK:     total == 0 || float64(written) >= rd.minWriteRate*float64(total),
+			Detail: fmt.Sprintf("%d of the last %d batches written, %.0f%% needed", written, total, 100*rd.minWriteRate),
+		})
+	}
+	if rd.draining.Load() {
+		checks = append(checks, readyCheck{Name: "shutdown", Detail: "shutting down"})
+	}
+	return checks
+}
+
+// handler serves GET /readyz: each check as JSON, with 503 unless all pass.
+func (rd *readiness) handler(w http.ResponseWriter, r *http.Request) {
+	checks := rd.checks()
+	ready := true
+	for _, c := range checks {
+		ready = ready && c.OK
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if !ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(struct {
+		Ready  bool         `json:"ready"`
+		Checks []readyCheck `json:"checks"`
+	}{ready, checks})
+}
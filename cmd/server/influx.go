@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"expvar"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/dlq"
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/sink"
+)
+
+// influxWriter flushes batches to an InfluxSink from a pool of writer goroutines so
+// the consumer isn't blocked on HTTP. A separate semaphore caps concurrent in-flight
+// requests, decoupling internal parallelism from the load placed on Influx. Retries
+// hold the in-flight slot, so a struggling or throttled Influx also slows the writers
+// down.
+type influxWriter struct {
+	sink *sink.InfluxSink
+	prec sink.Precision
+
+	batches  chan influxJob
+	inflight chan struct{}
+	pool     sync.Pool // *[]sink.Point batch copies
+
+	throttle *influxThrottle // nil unless INFLUX_LATENCY_TARGET is set
+	dlq      *deadLetters    // nil unless DLQ_KEY is set
+	running  sync.WaitGroup  // writer goroutines, for close
+
+	saturated atomic.Uint64 // writes that had to wait for an in-flight slot
+	failed    atomic.Uint64 // batches dropped because Influx didn't accept them
+
+	recentMu sync.Mutex
+	recent   [recentWrites]bool // whether each of the latest batches was written, a ring
+	recentN  int                // batches in recent, up to its size
+	recentAt int                // next slot to fill
+}
+
+// recentWrites is how many of the latest batches /readyz judges Influx by.
+const recentWrites = 20
+
+func newInfluxWriter(writeURL, token string, prec sink.Precision, opts sink.InfluxOptions, writers, maxInflight int, latencyTarget time.Duration, dead *deadLetters) *influxWriter {
+	w := startInfluxWriter(writeURL, token, prec, opts, writers, maxInflight, latencyTarget, dead)
+	if w.throttle != nil {
+		expvar.Publish("influx_throttle", expvar.Func(func() any { return w.throttle.state() }))
+	}
+	expvar.Publish("influx_inflight", expvar.Func(func() any { return len(w.inflight) }))
+	expvar.Publish("influx_inflight_saturated", expvar.Func(func() any { return w.saturated.Load() }))
+	expvar.Publish("influx_rate_limited", expvar.Func(func() any { return w.sink.RateLimited() }))
+	expvar.Publish("influx_retries", expvar.Func(func() any { return w.sink.Retried() }))
+	expvar.Publish("influx_write_failures", expvar.Func(func() any { return w.failed.Load() }))
+	return w
+}
+
+// startInfluxWriter is newInfluxWriter without the expvars, which can only be published
+// once per process; tests use it directly.
+func startInfluxWriter(writeURL, token string, prec sink.Precision, opts sink.InfluxOptions, writers, maxInflight int, latencyTarget time.Duration, dead *deadLetters) *influxWriter {
+	w := &influxWriter{
+		prec:     prec,
+		batches:  make(chan influxJob, writers),
+		inflight: make(chan struct{}, maxInflight),
+		dlq:      dead,
+	}
+	if latencyTarget > 0 {
+		w.throttle = newInfluxThrottle(latencyTarget, maxInflight)
+		opts.Observe = func(d time.Duration) { w.throttle.observe(d, time.Now()) }
+	}
+	w.sink = sink.NewInfluxSink(writeURL, token, prec, opts)
+	w.pool.New = func() interface{} {
+		b := make([]sink.Point, 0, influxBatchSize)
+		return &b
+	}
+	w.running.Add(writers)
+	for i := 0; i < writers; i++ {
+		go w.run()
+	}
+	return w
+}
+
+// close stops accepting batches and waits until the writers have finished (or given up
+// on) everything queued and in flight. Nothing may be submitted after it.
+func (w *influxWriter) close() {
+	close(w.batches)
+	w.running.Wait()
+}
+
+// influxJob is one queued batch. done, if set, runs once Influx accepted it, with a nil
+// error, or once it was dropped, with the reason.
+type influxJob struct {
+	points *[]sink.Point
+	done   func(error)
+}
+
+// submit copies batch and queues it for a writer; it blocks when all writers are busy,
+// which pushes back on the consumer. The caller may reuse batch immediately. done
+// (may be nil) is called from the writer goroutine once the batch is stored or dropped.
+func (w *influxWriter) submit(batch []sink.Point, done func(error)) {
+	b := w.pool.Get().(*[]sink.Point)
+	*b = append((*b)[:0], batch...)
+	w.batches <- influxJob{points: b, done: done}
+}
+
+func (w *influxWriter) run() {
+	defer w.running.Done()
+	for job := range w.batches {
+		select {
+		case w.inflight <- struct{}{}:
+		default:
+			w.saturated.Add(1)
+			w.inflight <- struct{}{}
+		}
+		if w.throttle != nil {
+			w.throttle.acquire()
+		}
+		err := w.sink.WriteBatch(context.Background(), *job.points)
+		if w.throttle != nil {
+			w.throttle.release()
+		}
+		<-w.inflight
+		w.record(err == nil)
+		if err != nil {
+			w.failed.Add(1)
+			slog.Error("Influx batch write failed; dropping the batch", "points", len(*job.points), "err", err)
+			if w.dlq != nil {
+				w.deadLetter(*job.points, err)
+			}
+		}
+		if job.done != nil {
+			job.done(err)
+		}
+		w.pool.Put(job.points)
+	}
+}
+
+// record adds a batch's outcome to the recent ones.
+func (w *influxWriter) record(ok bool) {
+	w.recentMu.Lock()
+	w.recent[w.recentAt] = ok
+	w.recentAt = (w.recentAt + 1) % len(w.recent)
+	w.recentN = min(w.recentN+1, len(w.recent))
+	w.recentMu.Unlock()
+}
+
+// recentOutcomes returns how many of the latest batches (up to recentWrites) were
+// written, and of how many.
+func (w *influxWriter) recentOutcomes() (written, total int) {
+	w.recentMu.Lock()
+	defer w.recentMu.Unlock()
+	for i := 0; i < w.recentN; i++ {
+		if w.recent[i] {
+			written++
+		}
+	}
+	return written, w.recentN
+}
+
+// report logs INFLUX_STATS alongside the latency stats.
+func (w *influxWriter) report() {
+	args := []any{"inflight", len(w.inflight), "max_inflight", cap(w.inflight), "saturated_total", w.saturated.Load(),
+		"rate_limited_total", w.sink.RateLimited(), "retried_total", w.sink.Retried(), "failed_total", w.failed.Load()}
+	if w.throttle != nil {
+		args = append(args, w.throttle.logAttrs()...)
+	}
+	slog.Info("INFLUX_STATS", args...)
+}
+
+// deadLetter pushes batch to the DLQ as line protocol, with the error that dropped it.
+func (w *influxWriter) deadLetter(batch []sink.Point, err error) {
+	var buf bytes.Buffer
+	for _, p := range batch {
+		sink.AppendLineProtocol(&buf, p, w.prec)
+	}
+	w.dlq.add(dlq.Entry{Time: time.Now(), Reason: dlq.ReasonWrite, Error: err.Error(), Lines: buf.String(), Points: len(batch)})
+}
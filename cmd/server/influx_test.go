@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/sink"
+)
+
+// fakeInflux accepts gzipped writes, failing the first attempt of every other batch
+// with a 503 so it is retried, and checks each retry re-sends the same body.
+type fakeInflux struct {
+	t        *testing.T
+	mu       sync.Mutex
+	attempts map[string][]byte // first line → body of its first attempt
+	written  map[string]bool   // timestamps of the points stored
+}
+
+func (f *fakeInflux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	zr, err := gzip.NewReader(r.Body)
+	if err != nil {
+		f.t.Errorf("gzip: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	body, err := io.ReadAll(zr)
+	if err != nil {
+		f.t.Errorf("reading body: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	first, retried := f.attempts[lines[0]]
+	if !retried {
+		f.attempts[lines[0]] = body
+		if len(f.attempts)%2 == 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+	} else if !bytes.Equal(first, body) {
+		f.t.Errorf("retry sent a different body:\n%s\nfirst attempt:\n%s", body, first)
+	}
+	for _, l := range lines {
+		fields := strings.Fields(l)
+		ts := fields[len(fields)-1]
+		if f.written[ts] {
+			f.t.Errorf("point %s written twice", ts)
+		}
+		f.written[ts] = true
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// TestInfluxWriterConcurrentRetries drives several writers against a flaky Influx
+// while the batcher reuses its batch. Run it with -race: batches are copied on submit,
+// and retried bodies must not go back to their pools until the last attempt is done.
+func TestInfluxWriterConcurrentRetries(t *testing.T) {
+	setBatchSize(t, 8)
+	fake := &fakeInflux{t: t, attempts: make(map[string][]byte), written: make(map[string]bool)}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	opts := sink.InfluxOptions{Gzip: true, Retry: sink.RetryPolicy{Retries: 3, Backoff: time.Millisecond, MaxWait: 5 * time.Millisecond}}
+	w := startInfluxWriter(srv.URL, "token", nil, opts, 4, 2, 0, nil)
+
+	const batches = 60
+	var done, failed atomic.Int64
+	batch := make([]sink.Point, influxBatchSize)
+	for i := 0; i < batches; i++ {
+		// Reused right after submit, as the batcher does.
+		for j := range batch {
+			batch[j] = sink.Point{TimeNano: int64(i*len(batch) + j + 1), CPU: float64(i), Mem: float64(j)}
+		}
+		w.submit(batch, func(err error) {
+			done.Add(1)
+			if err != nil {
+				failed.Add(1)
+				t.Errorf("batch dropped: %v", err)
+			}
+		})
+	}
+	w.close()
+
+	if got := done.Load(); got != batches {
+		t.Errorf("done called %d times, want %d", got, batches)
+	}
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if got, want := len(fake.written), batches*influxBatchSize; got != want {
+		t.Errorf("%d points written, want %d", got, want)
+	}
+	if w.sink.Retried() == 0 {
+		t.Error("no write was retried")
+	}
+	written, total := w.recentOutcomes()
+	if written != total || total != recentWrites {
+		t.Errorf("recent outcomes %d/%d, want %d/%d", written, total, recentWrites, recentWrites)
+	}
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/sink"
+)
+
+const (
+	// liveBuffer is how many messages a slow /live client may fall behind before
+	// points are dropped for it; as with /stream, the batcher never waits on a browser.
+	liveBuffer = 256
+	// livePing is how often /live pings its clients; one that hasn't answered within
+	// livePongWait is disconnected.
+	livePing      = 30 * time.Second
+	livePongWait  = livePing + 10*time.Second
+	liveWriteWait = 10 * time.Second
+	// liveMaxFilter bounds the filter messages a client may send.
+	liveMaxFilter = 8 << 10
+)
+
+// liveFilter selects the points a /live client receives: any of hosts, any of
+// measurements, and all of tags. Empty lists match everything.
+type liveFilter struct {
+	hosts        []string
+	measurements []string
+	tags         []sink.Tag
+}
+
+// parseLiveFilter reads host, measurement and tag (key:value) parameters, each
+// repeated or comma-separated.
+func parseLiveFilter(q url.Values) (*liveFilter, error) {
+	list := func(key string) []string {
+		var out []string
+		for _, v := range q[key] {
+			for _, s := range strings.Split(v, ",") {
+				if s = strings.TrimSpace(s); s != "" {
+					out = append(out, s)
+				}
+			}
+		}
+		return out
+	}
+	f := &liveFilter{hosts: list("host"), measurements: list("measurement")}
+	for _, kv := range list("tag") {
+		k, v, ok := strings.Cut(kv, ":")
+		if !ok || k == "" {
+			return nil, fmt.Errorf("tag filter %q is not key:value", kv)
+		}
+		f.tags = append(f.tags, sink.Tag{Key: k, Value: v})
+	}
+	return f, nil
+}
+
+func (f *liveFilter) match(p *sink.Point) bool {
+	if len(f.measurements) > 0 && !slices.Contains(f.measurements, p.Name()) {
+		return false
+	}
+	if len(f.hosts) > 0 && !slices.Contains(f.hosts, tagValue(p.Tags, hostTag)) {
+		return false
+	}
+	for _, want := range f.tags {
+		if !slices.Contains(p.Tags, want) {
+			return false
+		}
+	}
+	return true
+}
+
+func tagValue(tags []sink.Tag, key string) string {
+	for _, t := range tags {
+		if t.Key == key {
+			return t.Value
+		}
+	}
+	return ""
+}
+
+// livePoint is the JSON message /live sends for each point.
+type livePoint struct {
+	Measurement string             `json:"measurement"`
+	TimeMs      int64              `json:"t"`
+	Tags        map[string]string  `json:"tags"`
+	Fields      map[string]float64 `json:"fields"`
+}
+
+func encodeLivePoint(p *sink.Point) ([]byte, error) {
+	m := livePoint{
+		Measurement: p.Name(),
+		TimeMs:      p.TimeNano / 1e6,
+		Tags:        make(map[string]string, len(p.Tags)),
+		Fields:      make(map[string]float64),
+	}
+	for _, t := range p.Tags {
+		m.Tags[t.Key] = t.Value
+	}
+	p.EachField(func(key string, v float64) {
+		if !math.IsNaN(v) && !math.IsInf(v, 0) { // not representable in JSON
+			m.Fields[key] = v
+		}
+	})
+	return json.Marshal(m)
+}
+
+type liveClient struct {
+	filter atomic.Pointer[liveFilter]
+	send   chan []byte
+}
+
+// pointHub broadcasts every decoded point, as JSON over WebSocket, to the /live clients
+// whose filter it matches. Each point is encoded at most once however many clients
+// want it, and not at all when none do.
+type pointHub struct {
+	upgrader websocket.Upgrader
+
+	mu      sync.Mutex
+	clients map[*liveClient]struct{}
+	dropped atomic.Uint64
+}
+
+// newPointHub accepts connections from pages on the comma-separated origins
+// (LIVE_ORIGINS, "*" for any); with none it accepts only same-origin pages and
+// clients that send no Origin.
+func newPointHub(v string) *pointHub {
+	var origins []string
+	for _, o := range strings.Split(v, ",") {
+		if o = strings.TrimSpace(o); o != "" {
+			origins = append(origins, o)
+		}
+	}
+	h := &pointHub{clients: make(map[*liveClient]struct{})}
+	switch {
+	case slices.Contains(origins, "*"):
+		h.upgrader.CheckOrigin = func(*http.Request) bool { return true }
+	case len(origins) > 0:
+		h.upgrader.CheckOrigin = func(r *http.Request) bool {
+			o := r.Header.Get("Origin")
+			return o == "" || slices.Contains(origins, o)
+		}
+	}
+	expvar.Publish("live_clients", expvar.Func(func() any {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		return len(h.clients)
+	}))
+	expvar.Publish("live_dropped", expvar.Func(func() any { return h.dropped.Load() }))
+	return h
+}
+
+// publish offers p, and the extra points decoded from the same sample (which take p's
+// time and tags as the batch does), to every matching client without blocking.
+func (h *pointHub) publish(p sink.Point, extra []sink.Point) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.clients) == 0 {
+		return
+	}
+	h.offer(&p)
+	for _, e := range extra {
+		e.Tags = mergeTags(e.Tags, p.Tags)
+		e.TimeNano = p.TimeNano
+		h.offer(&e)
+	}
+}
+
+// offer is called with h.mu held.
+func (h *pointHub) offer(p *sink.Point) {
+	var msg []byte
+	for c := range h.clients {
+		if !c.filter.Load().match(p) {
+			continue
+		}
+		if msg == nil {
+			var err error
+			if msg, err = encodeLivePoint(p); err != nil {
+				return
+			}
+		}
+		select {
+		case c.send <- msg:
+		default:
+			h.dropped.Add(1)
+		}
+	}
+}
+
+// handler upgrades GET /live to a WebSocket. The query sets the initial filter
+// (?host=a,b&measurement=system_stats&tag=region:eu); the client can replace it at any
+// time by sending the same keys as a JSON object, e.g. {"host":["a"],"tag":["region:eu"]}.
+func (h *pointHub) handler(w http.ResponseWriter, r *http.Request) {
+	f, err := parseLiveFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	conn, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return // Upgrade has already replied
+	}
+	defer conn.Close()
+	c := &liveClient{send: make(chan []byte, liveBuffer)}
+	c.filter.Store(f)
+	h.mu.Lock()
+	h.clients[c] = struct{}{}
+	h.mu.Unlock()
+	defer func() {
+		h.mu.Lock()
+		delete(h.clients, c)
+		h.mu.Unlock()
+	}()
+
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		c.readFilters(conn)
+	}()
+	ping := time.NewTicker(livePing)
+	defer ping.Stop()
+	for {
+		var err error
+		select {
+		case <-closed:
+			return
+		case msg := <-c.send:
+			_ = conn.SetWriteDeadline(time.Now().Add(liveWriteWait))
+			err = conn.WriteMessage(websocket.TextMessage, msg)
+		case <-ping.C:
+			err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(liveWriteWait))
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// readFilters applies the filters the client sends until the connection fails or
+// the client stops answering pings.
+func (c *liveClient) readFilters(conn *websocket.Conn) {
+	conn.SetReadLimit(liveMaxFilter)
+	_ = conn.SetReadDeadline(time.Now().Add(livePongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(livePongWait))
+	})
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var q url.Values
+		var f *liveFilter
+		if err = json.Unmarshal(data, &q); err == nil {
+			f, err = parseLiveFilter(q)
+		}
+		if err != nil {
+			// A close reason must fit in a control frame.
+			reason := "bad filter: " + err.Error()
+			if len(reason) > 120 {
+				reason = reason[:120]
+			}
+			msg := websocket.FormatCloseMessage(websocket.CloseUnsupportedData, reason)
+			_ = conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(liveWriteWait))
+			return
+		}
+		c.filter.Store(f)
+	}
+}
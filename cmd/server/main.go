@@ -1,206 +1,769 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"encoding/binary"
+	"expvar"
+	"flag"
 	"fmt"
-	"io"
-	"log"
+	"log/slog"
 	"math"
 	"net/http"
 	_ "net/http/pprof"
 	"net/url"
 	"os"
 	"os/signal"
+	"slices"
 	"sort"
+	"strconv"
 	"sync"
 	"syscall"
 	"time"
 
-	jsoniter "github.com/json-iterator/go"
-	"github.com/redis/go-redis/v9"
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/buildinfo"
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/clock"
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/config"
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/dlq"
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/logging"
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/sink"
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/stats"
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/tracing"
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/transport"
 )
 
-// Metric must match the Agent's structure
-type Metric struct {
-	Timestamp        int64   `json:"timestamp"`
-	CPUUsage         float64 `json:"cpu_usage"`
-	MemUsage         float64 `json:"mem_usage"`
-	SendTimeUnixNano int64   `json:"send_time_unix_nano"`
-}
+const (
+	defaultBatchSize      = 256
+	defaultMetricsChannel = "metrics"
+	gpuMeasurement        = "gpu_stats"
+	diskIOMeasurement     = "diskio_stats"
 
-const influxBatchSize = 256
+	// Latency stats are logged every statsReportEvery messages. Sample buffers are
+	// capped separately (STATS_SAMPLE_CAP) so they stay bounded even if reporting stalls.
+	statsReportEvery = 1000
+	defaultSampleCap = 10000
 
-type batchPoint struct {
-	ts  int64
-	cpu float64
-	mem float64
-}
+	// Partial batches are flushed this often, so with few agents points don't wait
+	// minutes for a batch to fill. A partial batch below FLUSH_MIN_BATCH still goes
+	// out once its oldest point is defaultFlushMaxAge old.
+	defaultFlushInterval = 5 * time.Second
+	defaultFlushMaxAge   = 30 * time.Second
+
+	// Values for -timestamp. Ingest time trusts the server clock when agent clocks are skewed.
+	timestampEvent  = "event"
+	timestampIngest = "ingest"
+
+	// -input-format value that detects each payload's wire format.
+	inputAuto = "auto"
+)
 
 var (
 	metricPool = sync.Pool{
-		New: func() interface{} { return &Metric{} },
-	}
-	bufferPool = sync.Pool{
-		New: func() interface{} { return &bytes.Buffer{} },
+		New: func() interface{} { return &transport.Metric{} },
 	}
+
+	// influxBatchSize is the number of points that triggers a flush (BATCH_SIZE). It is
+	// set once at startup, before the batcher and writers start.
+	influxBatchSize = defaultBatchSize
 )
 
 func main() {
-	fmt.Println("📡 Sentinel Server starting...")
+	timestampSource := flag.String("timestamp", timestampEvent, "point timestamp: event (agent collection time) or ingest (server receive time)")
+	inputFormat := flag.String("input-format", inputAuto, "payload format: auto (detect per message), or json, binary, proto or msgpack to decode strictly as that format")
+	legacyBinary := flag.Bool("legacy-binary", false, "with -input-format=auto, also read unframed 32-byte binary records from agents that predate framing")
+	stream := flag.Bool("stream", false, "consume Redis Streams in a consumer group (same as TRANSPORT=redis-streams): durable, and acked only once written")
+	ui := flag.Bool("ui", false, "serve a live CPU/mem dashboard at /ui/ (fed by the /stream SSE endpoint) on the pprof port")
+	liveWS := flag.Bool("live", false, "broadcast every decoded point as JSON over WebSocket at /live on the pprof port")
+	configPath := flag.String("config", "", "YAML or TOML config file whose server section sets flags and env vars; the command line and environment override it")
+	flag.Parse()
+	if *configPath != "" {
+		file, err := config.Load(*configPath)
+		if err != nil {
+			logging.Fatal("Invalid -config", "err", err)
+		}
+		if err := config.Apply(flag.CommandLine, file.Settings(file.Server, "TRANSPORT", "REDIS_ADDR", "REDIS_CHANNEL")); err != nil {
+			logging.Fatal("Invalid -config", "err", err)
+		}
+	}
+	logFormat := os.Getenv("LOG_FORMAT")
+	if logFormat == "" {
+		logFormat = logging.FormatText
+	}
+	logLevel := os.Getenv("LOG_LEVEL")
+	if logLevel == "" {
+		logLevel = "info"
+	}
+	if err := logging.Setup("server", logFormat, logLevel); err != nil {
+		logging.Fatal("Invalid LOG_FORMAT or LOG_LEVEL", "err", err)
+	}
+	if *timestampSource != timestampEvent && *timestampSource != timestampIngest {
+		logging.Fatal("-timestamp must be "+timestampEvent+" or "+timestampIngest, "timestamp", *timestampSource)
+	}
+	if *inputFormat != inputAuto && !slices.Contains(transport.Formats, *inputFormat) {
+		logging.Fatal("-input-format must be "+inputAuto+" or a wire format", "input_format", *inputFormat, "formats", transport.Formats)
+	}
+
+	build := buildinfo.Get()
+	slog.Info("Sentinel Server starting", "version", build.Version, "commit", build.Commit, "go", build.GoVersion)
+	expvar.Publish("build_info", expvar.Func(func() any { return build }))
+	http.HandleFunc("/version", versionHandler(build))
+
+	profileDir := os.Getenv("PROFILE_DIR")
+	if profileDir == "" {
+		profileDir = "profiles"
+	}
+	adminToken := os.Getenv("ADMIN_TOKEN")
+	if adminToken == "" {
+		slog.Info("ADMIN_TOKEN not set; POST /flush and /debug/capture are disabled")
+	}
+	http.HandleFunc("/debug/capture", requireToken(adminToken, captureHandler(profileDir)))
+	var live *liveHub
+	if *ui {
+		live = newLiveHub()
+		registerUI(live)
+		slog.Info("Live dashboard on http://localhost:6060/ui/")
+	}
+	var points *pointHub
+	if *liveWS {
+		points = newPointHub(os.Getenv("LIVE_ORIGINS"))
+		http.HandleFunc("/live", points.handler)
+		slog.Info("Live points over WebSocket on ws://localhost:6060/live")
+	}
 
 	go func() {
-		log.Println("pprof listening on http://localhost:6060/debug/pprof/")
+		slog.Info("pprof listening on http://localhost:6060/debug/pprof/")
 		if err := http.ListenAndServe(":6060", nil); err != nil {
-			log.Printf("pprof server error: %v", err)
+			slog.Error("pprof server failed", "err", err)
 		}
 	}()
 
-	redisAddr := os.Getenv("REDIS_ADDR")
-	if redisAddr == "" {
-		redisAddr = "localhost:6379"
+	transportKind := os.Getenv("TRANSPORT")
+	if transportKind == "" {
+		transportKind = transport.KindRedis
+	}
+	if *stream {
+		if transportKind != transport.KindRedis && transportKind != transport.KindRedisStreams {
+			logging.Fatal("-stream needs TRANSPORT=redis", "transport", transportKind)
+		}
+		transportKind = transport.KindRedisStreams
+	}
+	sources, err := parseSources(os.Getenv("REDIS_ADDR"), transport.DefaultAddr(transportKind))
+	if err != nil {
+		logging.Fatal("Invalid REDIS_ADDR", "err", err)
+	}
+	redisCluster, _ := strconv.ParseBool(os.Getenv("REDIS_CLUSTER"))
+	brokerOpts := transport.Options{
+		Group:        os.Getenv("CONSUMER_GROUP"),
+		Consumer:     os.Getenv("CONSUMER_NAME"),
+		RedisMaster:  os.Getenv("REDIS_MASTER_NAME"),
+		RedisCluster: redisCluster,
+	}
+	if v := os.Getenv("CLAIM_IDLE"); v != "" {
+		if brokerOpts.ClaimIdle, err = time.ParseDuration(v); err != nil || brokerOpts.ClaimIdle <= 0 {
+			logging.Fatal("CLAIM_IDLE must be a positive duration")
+		}
 	}
 
-	rdb := redis.NewClient(&redis.Options{Addr: redisAddr})
-	pubsub := rdb.Subscribe(context.Background(), "metrics")
-	defer pubsub.Close()
+	raw := true
+	if v := os.Getenv("OUTPUT_RAW"); v != "" {
+		if raw, err = strconv.ParseBool(v); err != nil {
+			logging.Fatal("OUTPUT_RAW must be a boolean")
+		}
+	}
+	var roll *rollup
+	if v := os.Getenv("ROLLUP_INTERVAL"); v != "" {
+		interval, err := time.ParseDuration(v)
+		if err != nil || interval <= 0 {
+			logging.Fatal("ROLLUP_INTERVAL must be a positive duration")
+		}
+		roll = newRollup(interval)
+	}
+	if !raw && roll == nil {
+		logging.Fatal("OUTPUT_RAW=false needs ROLLUP_INTERVAL, otherwise nothing is written")
+	}
+	// Entries stay pending while the rollup intervals they fed are open, so they
+	// mustn't look abandoned to XAUTOCLAIM, which would deliver them twice.
+	if roll != nil && transportKind == transport.KindRedisStreams {
+		switch hold := roll.maxHold(); {
+		case brokerOpts.ClaimIdle == 0:
+			brokerOpts.ClaimIdle = hold + transport.DefaultClaimIdle
+		case brokerOpts.ClaimIdle <= hold:
+			logging.Fatal("CLAIM_IDLE must be longer than 3 × ROLLUP_INTERVAL with -stream", "claim_idle", brokerOpts.ClaimIdle, "rollup_interval", time.Duration(roll.interval))
+		}
+	}
+
+	if brokerOpts.RedisMaster != "" && brokerOpts.RedisCluster {
+		logging.Fatal("REDIS_MASTER_NAME and REDIS_CLUSTER can't both be set")
+	}
+	brokerOpts.RedisUsername, brokerOpts.RedisPassword = os.Getenv("REDIS_USERNAME"), os.Getenv("REDIS_PASSWORD")
+	redisTLS, _ := strconv.ParseBool(os.Getenv("REDIS_TLS"))
+	caFile, certFile, keyFile := os.Getenv("REDIS_TLS_CA"), os.Getenv("REDIS_TLS_CERT"), os.Getenv("REDIS_TLS_KEY")
+	if redisTLS || caFile != "" || certFile != "" || keyFile != "" {
+		if brokerOpts.RedisTLS, err = transport.LoadTLSConfig(caFile, certFile, keyFile); err != nil {
+			logging.Fatal("Invalid REDIS_TLS", "err", err)
+		}
+	}
 
 	influxURL := os.Getenv("INFLUX_URL")
 	influxToken := os.Getenv("INFLUX_TOKEN")
 	influxOrg := os.Getenv("INFLUX_ORG")
 	influxBucket := os.Getenv("INFLUX_BUCKET")
-	writeURL := influxURL + "/api/v2/write?org=" + url.QueryEscape(influxOrg) + "&bucket=" + url.QueryEscape(influxBucket)
+	if err := validateInfluxConfig(influxURL, influxOrg, influxBucket); err != nil {
+		logging.Fatal("Invalid Influx config", "err", err)
+	}
+	writeURL := sink.InfluxWriteURL(influxURL, influxOrg, influxBucket)
+	if influxBatchSize, err = envInt("BATCH_SIZE", defaultBatchSize); err != nil || influxBatchSize <= 0 {
+		logging.Fatal("BATCH_SIZE must be a positive integer")
+	}
+
+	// Runs last, after the other defers have closed the sinks.
+	exitCode := 0
+	defer func() {
+		if exitCode != 0 {
+			os.Exit(exitCode)
+		}
+	}()
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	dumpReq := make(chan os.Signal, 1)
+	notifyDump(dumpReq)
 
-	fmt.Println("Listening for metrics on Redis 'metrics' channel...")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ingestTime := *timestampSource == timestampIngest
+	clk := clock.Real()
 
-	go func() {
-		var (
-			latencySamples  = make([]time.Duration, 0, 1000)
-			internalSamples = make([]time.Duration, 0, 1000)
-			batch           = make([]batchPoint, 0, influxBatchSize)
-		)
-
-		for {
-			msg, err := pubsub.ReceiveMessage(context.Background())
-			if err != nil {
-				log.Printf("Redis error: %v", err)
-				return
+	queueDepth, err := envInt("RECV_QUEUE_DEPTH", defaultRecvQueueDepth)
+	if err != nil || queueDepth <= 0 {
+		logging.Fatal("RECV_QUEUE_DEPTH must be a positive integer")
+	}
+	channels, err := parseChannels(os.Getenv("REDIS_CHANNEL"))
+	if err != nil {
+		logging.Fatal("Invalid REDIS_CHANNEL", "err", err)
+	}
+	queue := newRecvQueues(channels, queueDepth)
+	silence := newSilenceTracker(clk, queue)
+	if v := os.Getenv("SILENCE_ALERT_AFTER"); v != "" {
+		threshold, err := time.ParseDuration(v)
+		if err != nil || threshold <= 0 {
+			logging.Fatal("SILENCE_ALERT_AFTER must be a positive duration")
+		}
+		go silence.watch(ctx, threshold)
+	}
+	maxReconnects, err := envInt("MAX_RECONNECT_ATTEMPTS", 0)
+	if err != nil || maxReconnects < 0 {
+		logging.Fatal("MAX_RECONNECT_ATTEMPTS must be a non-negative integer")
+	}
+	giveUp := make(chan error, len(sources))
+	controlChannel := os.Getenv("CONTROL_CHANNEL")
+	if controlChannel == "" {
+		controlChannel = transport.DefaultControlChannel
+	}
+	auth, err := newAgentAuth(os.Getenv("AGENT_TOKENS"), controlChannel)
+	if err != nil {
+		logging.Fatal("Invalid AGENT_TOKENS", "err", err)
+	}
+	if auth != nil {
+		slog.Info("Accepting metrics only from authenticated agents", "channel", controlChannel)
+	}
+
+	// Every broker instance gets its own receiver; they all feed one decode/batch stage.
+	// Receivers stop on recvCtx first at shutdown; the transports stay open until the
+	// batcher has drained, so late acks still reach the broker.
+	recvCtx, stopRecv := context.WithCancel(ctx)
+	var receivers sync.WaitGroup
+	health := newBrokerHealth(clk, sources)
+	http.HandleFunc("/healthz", health.handler)
+	transports := make([]transport.Transport, 0, len(sources))
+	for i := range sources {
+		tr, err := transport.New(transportKind, sources[i].addr, brokerOpts)
+		if err != nil {
+			logging.Fatal("Invalid TRANSPORT", "err", err)
+		}
+		transports = append(transports, tr)
+		for _, c := range channels {
+			slog.Info("Listening for metrics", "transport", transportKind, "source", sources[i].String(), "channel", c.name, "priority", c.priority)
+		}
+		receivers.Add(1)
+		go func() {
+			defer receivers.Done()
+			receive(recvCtx, clk, tr, &sources[i], channels, queue, auth, health, maxReconnects, giveUp)
+		}()
+	}
+	shutdownTimeout := defaultShutdownTimeout
+	if v := os.Getenv("SHUTDOWN_TIMEOUT"); v != "" {
+		if shutdownTimeout, err = time.ParseDuration(v); err != nil || shutdownTimeout <= 0 {
+			logging.Fatal("SHUTDOWN_TIMEOUT must be a positive duration")
+		}
+	}
+
+	sampleCap, err := envInt("STATS_SAMPLE_CAP", defaultSampleCap)
+	if err != nil || sampleCap <= 0 {
+		logging.Fatal("STATS_SAMPLE_CAP must be a positive integer")
+	}
+
+	precision, err := sink.ParsePrecision(os.Getenv("FIELD_PRECISION"))
+	if err != nil {
+		logging.Fatal("Invalid FIELD_PRECISION", "err", err)
+	}
+	extraSinks, err := extraSinksFromEnv(precision)
+	if err != nil {
+		logging.Fatal("Invalid sink config", "err", err)
+	}
+	defer func() {
+		for _, s := range extraSinks {
+			if err := s.Close(); err != nil {
+				slog.Error("Sink close failed", "sink", s.name, "err", err)
 			}
+		}
+	}()
 
-			recvAt := time.Now()
-			payload := []byte(msg.Payload)
-
-			var ts int64
-			var cpuUsage, memUsage float64
-			var sendTimeNano int64
-
-			if len(payload) == 32 {
-				ts = int64(binary.LittleEndian.Uint64(payload[0:8]))
-				cpuUsage = math.Float64frombits(binary.LittleEndian.Uint64(payload[8:16]))
-				memUsage = math.Float64frombits(binary.LittleEndian.Uint64(payload[16:24]))
-				sendTimeNano = int64(binary.LittleEndian.Uint64(payload[24:32]))
-			} else {
-				m := metricPool.Get().(*Metric)
-				*m = Metric{}
-				err = jsoniter.Unmarshal(payload, m)
-				if err != nil {
-					metricPool.Put(m)
-					log.Printf("JSON error: %v", err)
-					continue
-				}
-				ts, cpuUsage, memUsage, sendTimeNano = m.Timestamp, m.CPUUsage, m.MemUsage, m.SendTimeUnixNano
-				metricPool.Put(m)
+	dedupWindow, err := envInt("DEDUP_WINDOW", 0)
+	if err != nil || dedupWindow < 0 {
+		logging.Fatal("DEDUP_WINDOW must be a non-negative integer")
+	}
+	var dedup *seqDedup
+	if dedupWindow > 0 {
+		dedup = newSeqDedup(dedupWindow)
+	}
+
+	writers, err := envInt("INFLUX_WRITERS", 1)
+	if err != nil || writers <= 0 {
+		logging.Fatal("INFLUX_WRITERS must be a positive integer")
+	}
+	maxInflight, err := envInt("INFLUX_MAX_INFLIGHT", writers)
+	if err != nil || maxInflight <= 0 {
+		logging.Fatal("INFLUX_MAX_INFLIGHT must be a positive integer")
+	}
+	retry := sink.DefaultRetryPolicy
+	if retry.Retries, err = envInt("INFLUX_RETRIES", retry.Retries); err != nil || retry.Retries < 0 {
+		logging.Fatal("INFLUX_RETRIES must be a non-negative integer")
+	}
+	if retry.RateLimitRetries, err = envInt("INFLUX_RATE_LIMIT_RETRIES", retry.RateLimitRetries); err != nil || retry.RateLimitRetries < 0 {
+		logging.Fatal("INFLUX_RATE_LIMIT_RETRIES must be a non-negative integer")
+	}
+	if v := os.Getenv("INFLUX_RETRY_BACKOFF"); v != "" {
+		if retry.Backoff, err = time.ParseDuration(v); err != nil || retry.Backoff <= 0 {
+			logging.Fatal("INFLUX_RETRY_BACKOFF must be a positive duration")
+		}
+	}
+	if v := os.Getenv("INFLUX_RETRY_AFTER_MAX"); v != "" {
+		if retry.MaxWait, err = time.ParseDuration(v); err != nil || retry.MaxWait <= 0 {
+			logging.Fatal("INFLUX_RETRY_AFTER_MAX must be a positive duration")
+		}
+	}
+	if v := os.Getenv("INFLUX_RETRY_JITTER"); v != "" {
+		if retry.Jitter, err = strconv.ParseFloat(v, 64); err != nil || retry.Jitter < 0 || retry.Jitter > 1 {
+			logging.Fatal("INFLUX_RETRY_JITTER must be between 0 and 1")
+		}
+	}
+	var latencyTarget time.Duration
+	if v := os.Getenv("INFLUX_LATENCY_TARGET"); v != "" {
+		if latencyTarget, err = time.ParseDuration(v); err != nil || latencyTarget <= 0 {
+			logging.Fatal("INFLUX_LATENCY_TARGET must be a positive duration")
+		}
+	}
+	gzipBody, _ := strconv.ParseBool(os.Getenv("INFLUX_GZIP"))
+	influxOpts := sink.InfluxOptions{ContentType: os.Getenv("INFLUX_CONTENT_TYPE"), Gzip: gzipBody, Retry: retry}
+	if v := os.Getenv("INFLUX_TIMEOUT"); v != "" {
+		if influxOpts.Timeout, err = time.ParseDuration(v); err != nil || influxOpts.Timeout <= 0 {
+			logging.Fatal("INFLUX_TIMEOUT must be a positive duration")
+		}
+	}
+	var deadLetter *deadLetters
+	if key := os.Getenv("DLQ_KEY"); key != "" {
+		addr := os.Getenv("DLQ_ADDR")
+		if addr == "" {
+			if transportKind != transport.KindRedis && transportKind != transport.KindRedisStreams {
+				logging.Fatal("DLQ_ADDR must be set with this TRANSPORT", "transport", transportKind)
 			}
+			addr = sources[0].addr
+		}
+		maxLen, err := envInt("DLQ_MAX_LEN", dlq.DefaultMaxLen)
+		if err != nil || maxLen <= 0 {
+			logging.Fatal("DLQ_MAX_LEN must be a positive integer")
+		}
+		deadLetter = newDeadLetters(dlq.New(addr, key, maxLen, brokerOpts))
+		slog.Info("Dead-lettering undecodable messages and rejected batches", "key", key, "addr", addr)
+	}
+	// Without INFLUX_URL the server still feeds the other sinks; with no sinks at all
+	// there is nowhere for points to go, so refuse to start.
+	var influx *influxWriter
+	if influxURL != "" {
+		influx = newInfluxWriter(writeURL, influxToken, precision, influxOpts, writers, maxInflight, latencyTarget, deadLetter)
+	} else if len(extraSinks) > 0 {
+		slog.Warn("INFLUX_URL is not set; writing only to the configured extra sinks")
+	} else {
+		logging.Fatal("Influx config: INFLUX_URL is not set and no other sink is configured (FILE_SINK_PATH, STDOUT_SINK, VM_URL, GRAPHITE_ADDR, SQLITE_PATH, CLICKHOUSE_URL, POSTGRES_URL, PARQUET_DIR, S3_BUCKET)")
+	}
 
-			batch = append(batch, batchPoint{ts: ts, cpu: cpuUsage, mem: memUsage})
-			internalDuration := time.Since(recvAt) // Core engine: Redis recv → point created (batch entry)
-			internalSamples = append(internalSamples, internalDuration)
+	decodeSampleEvery, err := envInt("DECODE_SAMPLE_EVERY", 100)
+	if err != nil || decodeSampleEvery < 0 {
+		logging.Fatal("DECODE_SAMPLE_EVERY must be a non-negative integer")
+	}
+
+	flushEvery := defaultFlushInterval
+	if v := os.Getenv("FLUSH_INTERVAL"); v != "" {
+		if flushEvery, err = time.ParseDuration(v); err != nil || flushEvery < 0 {
+			logging.Fatal("FLUSH_INTERVAL must be a non-negative duration (0 disables it)")
+		}
+	}
+	minBatch, err := envInt("FLUSH_MIN_BATCH", 0)
+	if err != nil || minBatch < 0 || minBatch > influxBatchSize {
+		logging.Fatal("FLUSH_MIN_BATCH must be between 0 and BATCH_SIZE", "batch_size", influxBatchSize)
+	}
+	maxAge := defaultFlushMaxAge
+	if v := os.Getenv("FLUSH_MAX_AGE"); v != "" {
+		if maxAge, err = time.ParseDuration(v); err != nil || maxAge <= 0 {
+			logging.Fatal("FLUSH_MAX_AGE must be a positive duration")
+		}
+	}
 
-			if len(batch) >= influxBatchSize {
-				flushInfluxBatch(writeURL, influxToken, batch)
-				batch = batch[:0]
+	var avail *availability
+	if v := os.Getenv("AVAILABILITY_INTERVAL"); v != "" {
+		interval, err := time.ParseDuration(v)
+		if err != nil || interval <= 0 {
+			logging.Fatal("AVAILABILITY_INTERVAL must be a positive duration")
+		}
+		window := defaultAvailabilityWindow
+		if v := os.Getenv("AVAILABILITY_WINDOW"); v != "" {
+			if window, err = time.ParseDuration(v); err != nil || window < interval {
+				logging.Fatal("AVAILABILITY_WINDOW must be a duration of at least AVAILABILITY_INTERVAL")
 			}
+		}
+		avail = newAvailability(interval, window)
+	}
 
-			if sendTimeNano != 0 {
-				latencySamples = append(latencySamples, time.Since(time.Unix(0, sendTimeNano)))
+	var notifiers alertNotifiers
+	if os.Getenv("ALERT_RULES") != "" || os.Getenv("ANOMALY_SIGMA") != "" {
+		if notifiers, err = alertNotifiersFromEnv(); err != nil {
+			logging.Fatal("Invalid alert notifier config", "err", err)
+		}
+	}
+	var alerts *alerter
+	if v := os.Getenv("ALERT_RULES"); v != "" {
+		rules, err := parseAlertRules(v)
+		if err != nil {
+			logging.Fatal("Invalid ALERT_RULES", "err", err)
+		}
+		var repeat time.Duration
+		if v := os.Getenv("ALERT_REPEAT_INTERVAL"); v != "" {
+			if repeat, err = time.ParseDuration(v); err != nil || repeat < 0 {
+				logging.Fatal("ALERT_REPEAT_INTERVAL must be a non-negative duration")
 			}
-			if len(internalSamples) >= 1000 {
-				printLatencyStats("E2E", latencySamples)
-				printLatencyStats("INTERNAL", internalSamples)
-				latencySamples = latencySamples[:0]
-				internalSamples = internalSamples[:0]
+		}
+		alerts = newAlerter(clk, rules, repeat, notifiers)
+		slog.Info("Evaluating alert rules", "rules", len(rules))
+	}
+	var anomalies *anomalyDetector
+	if v := os.Getenv("ANOMALY_SIGMA"); v != "" {
+		sigma, err := strconv.ParseFloat(v, 64)
+		if err != nil || sigma <= 0 {
+			logging.Fatal("ANOMALY_SIGMA must be a positive number")
+		}
+		alpha := defaultAnomalyAlpha
+		if v := os.Getenv("ANOMALY_ALPHA"); v != "" {
+			if alpha, err = strconv.ParseFloat(v, 64); err != nil || alpha <= 0 || alpha >= 1 {
+				logging.Fatal("ANOMALY_ALPHA must be between 0 and 1")
 			}
 		}
-	}()
+		warmup, err := envInt("ANOMALY_WARMUP", defaultAnomalyWarmup)
+		if err != nil || warmup < 2 {
+			logging.Fatal("ANOMALY_WARMUP must be an integer of at least 2")
+		}
+		fields, err := parseAnomalyFields(os.Getenv("ANOMALY_FIELDS"))
+		if err != nil {
+			logging.Fatal("Invalid ANOMALY_FIELDS", "err", err)
+		}
+		anomalies = newAnomalyDetector(clk, sigma, alpha, warmup, fields, notifiers)
+		slog.Info("Detecting anomalies", "sigma", sigma, "alpha", alpha, "warmup", warmup)
+	}
 
-	<-sigChan
-	fmt.Println("\n🛑 Server shutting down...")
-}
+	var echo *echoer
+	if v := os.Getenv("ECHO_CHANNEL"); v != "" {
+		echo = newEchoer(v, sources, transports)
+		slog.Info("Echoing the send times of written samples", "channel", v)
+	}
+	var traces *sampleTracer
+	if endpoint := os.Getenv(tracing.EndpointEnv); endpoint != "" {
+		stopTracing, err := tracing.Setup(context.Background(), "sentinel-server", endpoint, 0)
+		if err != nil {
+			logging.Fatal("Invalid "+tracing.EndpointEnv, "err", err)
+		}
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := stopTracing(ctx); err != nil {
+				slog.Warn("Exporting the last spans failed", "err", err)
+			}
+		}()
+		traces = newSampleTracer()
+		slog.Info("Continuing agent traces", "endpoint", endpoint)
+	}
 
-func flushInfluxBatch(writeURL, token string, batch []batchPoint) {
-	if len(batch) == 0 {
-		return
+	replicas, err := envInt("REPLICA_COUNT", 1)
+	if err != nil || replicas <= 0 {
+		logging.Fatal("REPLICA_COUNT must be a positive integer")
+	}
+	replicaIndex, err := envInt("REPLICA_INDEX", 0)
+	if err != nil || replicaIndex < 0 || replicaIndex >= replicas {
+		logging.Fatal("REPLICA_INDEX must be between 0 and REPLICA_COUNT-1")
+	}
+	// A consumer group already splits entries between replicas; partitioning on top
+	// would drop, and then ack, entries only this replica was given.
+	if replicas > 1 && (transportKind == transport.KindRedisStreams || transportKind == transport.KindKafka) {
+		logging.Fatal("REPLICA_COUNT can't be used with a consumer group; run replicas in one CONSUMER_GROUP instead", "transport", transportKind)
+	}
+
+	b := &batcher{
+		clock:        clk,
+		queue:        queue,
+		influx:       influx,
+		sinks:        extraSinks,
+		dedup:        dedup,
+		ingestTime:   ingestTime,
+		inputFormat:  *inputFormat,
+		legacyBinary: *legacyBinary,
+		raw:          raw,
+		rollup:       roll,
+		tagFilter:    newTagFilter(clk, os.Getenv("TAG_ALLOWLIST")),
+		auth:         auth,
+		avail:        avail,
+		alerts:       alerts,
+		anomalies:    anomalies,
+		echo:         echo,
+		traces:       traces,
+		dlq:          deadLetter,
+		metrics:      newPipelineMetrics(),
+		latency:      stats.NewWindow(sampleCap),
+		internal:     stats.NewWindow(sampleCap),
+		batch:        make([]sink.Point, 0, influxBatchSize),
+		decodeTime:   newDecodeTimer(decodeSampleEvery, sampleCap),
+		live:         live,
+		points:       points,
+		flushEvery:   flushEvery,
+		minBatch:     minBatch,
+		maxAge:       maxAge,
+		flushReq:     make(chan chan int),
+		dumpReq:      dumpReq,
+		drainReq:     make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+	if transportKind == transport.KindRedisStreams {
+		b.acks, b.ackStats = make(ackSet), newAckStats()
+	}
+	if replicas > 1 {
+		b.partition = newPartition(replicaIndex, replicas)
+		slog.Info("Handling this replica's share of hosts", "replica", replicaIndex, "replicas", replicas)
+	}
+	if byFormat, _ := strconv.ParseBool(os.Getenv("STATS_BY_FORMAT")); byFormat {
+		b.byFormat = newFormatStats(sampleCap)
+	}
+	b.batch = append(b.batch, buildInfoPoint(build, clk.Now()))
+	http.HandleFunc("/flush", requireToken(adminToken, flushHandler(b)))
+	ready := &readiness{clk: clk, brokers: health, queues: queue, influx: influx, maxLag: defaultReadyMaxLag, minWriteRate: defaultReadyMinWriteRate}
+	if v := os.Getenv("READY_MAX_LAG"); v != "" {
+		if ready.maxLag, err = time.ParseDuration(v); err != nil || ready.maxLag <= 0 {
+			logging.Fatal("READY_MAX_LAG must be a positive duration")
+		}
+	}
+	if v := os.Getenv("READY_MIN_WRITE_RATE"); v != "" {
+		if ready.minWriteRate, err = strconv.ParseFloat(v, 64); err != nil || ready.minWriteRate < 0 || ready.minWriteRate > 1 {
+			logging.Fatal("READY_MIN_WRITE_RATE must be between 0 and 1")
+		}
+	}
+	http.HandleFunc("/readyz", ready.handler)
+	metrics := metricsHandler(b.metrics, influx, health, queue)
+	http.Handle("/metrics", metrics)
+	if addr := os.Getenv("METRICS_ADDR"); addr != "" {
+		// A listener of its own, so Prometheus and probes can be let in without
+		// exposing pprof.
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics)
+		mux.HandleFunc("/healthz", health.handler)
+		mux.HandleFunc("/readyz", ready.handler)
+		go func() {
+			slog.Info("Serving /metrics, /healthz and /readyz", "addr", addr)
+			if err := http.ListenAndServe(addr, mux); err != nil {
+				slog.Error("Metrics server failed", "err", err)
+			}
+		}()
+	}
+	go b.run(ctx)
+
+	select {
+	case <-sigChan:
+		slog.Info("Server shutting down")
+	case err := <-giveUp:
+		slog.Error("Exiting", "err", err)
+		exitCode = 1
 	}
-	buf := bufferPool.Get().(*bytes.Buffer)
-	buf.Reset()
-	for _, p := range batch {
-		tsNano := p.ts * 1e9
-		_, _ = fmt.Fprintf(buf, "system_stats cpu=%f,mem=%f %d\n", p.cpu, p.mem, tsNano)
+	ready.draining.Store(true)
+	shutdown(stopRecv, &receivers, b, influx, deadLetter, notifiers, transports, shutdownTimeout)
+}
+
+// validateInfluxConfig catches settings that would otherwise make every flush fail
+// with a confusing error (e.g. a relative "/api/v2/write" URL). An empty URL is
+// allowed here; main decides whether that's fatal.
+func validateInfluxConfig(influxURL, org, bucket string) error {
+	if influxURL == "" {
+		return nil
 	}
-	body := buf.Bytes()
-	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, writeURL, bytes.NewReader(body))
+	u, err := url.Parse(influxURL)
 	if err != nil {
-		bufferPool.Put(buf)
-		log.Printf("Influx batch request: %v", err)
-		return
+		return fmt.Errorf("INFLUX_URL: %w", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("INFLUX_URL %q must be an absolute http(s) URL", influxURL)
+	}
+	if org == "" || bucket == "" {
+		return fmt.Errorf("INFLUX_ORG and INFLUX_BUCKET are required when INFLUX_URL is set")
+	}
+	return nil
+}
+
+// envInt reads an integer env var, returning def when it is unset.
+func envInt(key string, def int) (int, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return def, nil
+	}
+	return strconv.Atoi(v)
+}
+
+// decoded is one message after decoding, before it joins a batch.
+type decoded struct {
+	format       string // transport.FormatJSON, FormatBinary or FormatProto
+	point        sink.Point
+	sendTimeNano int64 // 0 if the producer didn't set it
+	host         string
+	seq          uint64
+	traceParent  string       // the agent's collect span, if it traced the sample
+	tags         []sink.Tag   // agent-supplied tags, sorted by key
+	extra        []sink.Point // per-device points (e.g. GPUs) sharing the sample's timestamp
+}
+
+// decodeBinary is the allocation-free fast path for one fixed binary record.
+func decodeBinary(rec []byte) decoded {
+	return decoded{
+		format: transport.FormatBinary,
+		point: sink.Point{
+			TimeNano: int64(binary.LittleEndian.Uint64(rec[0:8])) * 1e9,
+			CPU:      math.Float64frombits(binary.LittleEndian.Uint64(rec[8:16])),
+			Mem:      math.Float64frombits(binary.LittleEndian.Uint64(rec[16:24])),
+		},
+		sendTimeNano: int64(binary.LittleEndian.Uint64(rec[24:32])),
+	}
+}
+
+// decodePayload turns a Redis message into a point plus the metadata carried alongside it.
+// With inputFormat other than inputAuto the payload must be in that format. Unframed
+// binary records are read with inputAuto only if legacyBinary is set.
+func decodePayload(payload []byte, inputFormat string, legacyBinary bool) (decoded, error) {
+	if inputFormat == inputAuto || inputFormat == transport.FormatBinary {
+		if rec, ok := transport.BinaryFrameRecord(payload); ok {
+			return decodeBinary(rec), nil
+		}
+		if (legacyBinary && transport.IsLegacyBinary(payload)) || (inputFormat == transport.FormatBinary && len(payload) == transport.BinarySize) {
+			return decodeBinary(payload), nil
+		}
+	}
+
+	m := metricPool.Get().(*transport.Metric)
+	defer metricPool.Put(m)
+	format := inputFormat
+	var err error
+	if inputFormat == inputAuto {
+		format, err = transport.DecodeMetric(payload, m)
+	} else {
+		err = transport.DecodeMetricAs(inputFormat, payload, m)
 	}
-	req.Header.Set("Authorization", "Token "+token)
-	req.Header.Set("Content-Type", "application/vnd.influxdb.lineprotocol")
-	resp, err := http.DefaultClient.Do(req)
-	bufferPool.Put(buf)
 	if err != nil {
-		log.Printf("Influx batch write: %v", err)
-		return
+		return decoded{}, err
+	}
+	d := decoded{
+		format:       format,
+		point:        sink.Point{TimeNano: m.Timestamp * 1e9, CPU: m.CPUUsage, Mem: m.MemUsage},
+		sendTimeNano: m.SendTimeUnixNano,
+		host:         m.Host,
+		seq:          m.Seq,
+		traceParent:  m.TraceParent,
+	}
+	if len(m.Tags) > 0 {
+		d.tags = make([]sink.Tag, 0, len(m.Tags))
+		for k, v := range m.Tags {
+			// Line protocol has no empty tag keys or values; skip them rather than fail the write.
+			if k != "" && v != "" {
+				d.tags = append(d.tags, sink.Tag{Key: k, Value: v})
+			}
+		}
+		sort.Slice(d.tags, func(i, j int) bool { return d.tags[i].Key < d.tags[j].Key })
 	}
-	_, _ = io.Copy(io.Discard, resp.Body)
-	resp.Body.Close()
-	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
-		log.Printf("Influx batch write status: %d", resp.StatusCode)
+	for _, g := range m.GPUs {
+		d.extra = append(d.extra, sink.Point{
+			Measurement: gpuMeasurement,
+			TimeNano:    d.point.TimeNano,
+			Fields: []sink.Field{
+				{Key: "util_percent", Value: g.UtilPercent},
+				{Key: "mem_used_mb", Value: g.MemUsedMB},
+				{Key: "mem_total_mb", Value: g.MemTotalMB},
+			},
+			Tags: []sink.Tag{{Key: "gpu", Value: strconv.Itoa(g.Index)}},
+		})
 	}
+	for _, disk := range m.Disks {
+		d.extra = append(d.extra, sink.Point{
+			Measurement: diskIOMeasurement,
+			TimeNano:    d.point.TimeNano,
+			Fields: []sink.Field{
+				{Key: "read_bytes_per_sec", Value: disk.ReadBytesPerSec},
+				{Key: "write_bytes_per_sec", Value: disk.WriteBytesPerSec},
+				{Key: "reads_per_sec", Value: disk.ReadsPerSec},
+				{Key: "writes_per_sec", Value: disk.WritesPerSec},
+				{Key: "busy_percent", Value: disk.BusyPercent},
+			},
+			Tags: []sink.Tag{{Key: "device", Value: disk.Device}},
+		})
+	}
+	for _, smp := range m.Samples {
+		if p, ok := samplePoint(smp, d.point.TimeNano); ok {
+			d.extra = append(d.extra, p)
+		}
+	}
+	return d, nil
+}
+
+// samplePoint turns a collector sample into a point. Like agent tags, empty tag keys
+// and values and empty field keys are skipped, since line protocol can't hold them; a
+// sample without a measurement or any fields is dropped.
+func samplePoint(smp transport.Sample, timeNano int64) (sink.Point, bool) {
+	p := sink.Point{Measurement: smp.Measurement, TimeNano: timeNano}
+	for k, v := range smp.Fields {
+		if k != "" {
+			p.Fields = append(p.Fields, sink.Field{Key: k, Value: v})
+		}
+	}
+	if p.Measurement == "" || len(p.Fields) == 0 {
+		return sink.Point{}, false
+	}
+	sort.Slice(p.Fields, func(i, j int) bool { return p.Fields[i].Key < p.Fields[j].Key })
+	for k, v := range smp.Tags {
+		if k != "" && v != "" {
+			p.Tags = append(p.Tags, sink.Tag{Key: k, Value: v})
+		}
+	}
+	sort.Slice(p.Tags, func(i, j int) bool { return p.Tags[i].Key < p.Tags[j].Key })
+	return p, true
 }
 
 func printLatencyStats(label string, samples []time.Duration) {
 	if len(samples) == 0 {
 		return
 	}
-	sorted := append([]time.Duration(nil), samples...)
-	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
-	p50 := percentile(sorted, 0.50)
-	p90 := percentile(sorted, 0.90)
-	p99 := percentile(sorted, 0.99)
+	pct := stats.Summarize(samples)
 	prefix := "E2E_LATENCY_STATS"
 	if label == "INTERNAL" {
 		prefix = "INTERNAL_LATENCY_STATS"
 	}
-	log.Printf("%s count=%d p50_us=%d p90_us=%d p99_us=%d",
-		prefix, len(sorted), p50.Microseconds(), p90.Microseconds(), p99.Microseconds())
-}
-
-func percentile(durations []time.Duration, p float64) time.Duration {
-	n := len(durations)
-	if n == 0 {
-		return 0
-	}
-	rank := int(math.Ceil(p*float64(n))) - 1
-	if rank < 0 {
-		rank = 0
-	}
-	if rank >= n {
-		rank = n - 1
-	}
-	return durations[rank]
+	slog.Info(prefix, "count", len(samples), "p50_us", pct.P50.Microseconds(), "p90_us", pct.P90.Microseconds(), "p99_us", pct.P99.Microseconds())
 }
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"text/template"
+	"time"
+)
+
+const (
+	// alertQueueDepth bounds the notifications waiting for each notifier.
+	alertQueueDepth = 256
+	// alertSendTimeout bounds one attempt to deliver a notification.
+	alertSendTimeout = 10 * time.Second
+	// alertSendAttempts is how often a notification is tried before it is dropped;
+	// the wait between attempts starts at alertSendBackoff and doubles.
+	alertSendAttempts = 3
+	alertSendBackoff  = time.Second
+
+	pagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+)
+
+// alertSender delivers one alert event to a notification service.
+type alertSender interface {
+	send(ctx context.Context, e alertEvent) error
+}
+
+// alertNotifier feeds one sender from its own goroutine and queue, in order, so a slow
+// or failing service never holds up the batcher or the other notifiers. Failed sends
+// are retried with backoff unless the service rejected the request outright; events
+// that still fail, or don't fit in the queue, are logged and counted.
+type alertNotifier struct {
+	name   string
+	sender alertSender
+	events chan alertEvent
+	done   chan struct{}
+
+	sent   atomic.Uint64
+	failed atomic.Uint64
+}
+
+// alertNotifiers are the configured notifiers; every event goes to all of them.
+type alertNotifiers []*alertNotifier
+
+// alertNotifiersFromEnv starts a notifier for each of ALERT_WEBHOOK_URL,
+// ALERT_SLACK_WEBHOOK_URL and ALERT_PAGERDUTY_ROUTING_KEY that is set.
+func alertNotifiersFromEnv() (alertNotifiers, error) {
+	var ns alertNotifiers
+	if url := os.Getenv("ALERT_WEBHOOK_URL"); url != "" {
+		w := &webhookSender{url: url}
+		if v := os.Getenv("ALERT_WEBHOOK_TEMPLATE"); v != "" {
+			tmpl, err := template.New("ALERT_WEBHOOK_TEMPLATE").Funcs(template.FuncMap{"json": jsonString}).Parse(v)
+			if err != nil {
+				return nil, fmt.Errorf("ALERT_WEBHOOK_TEMPLATE: %w", err)
+			}
+			w.tmpl = tmpl
+		}
+		ns = append(ns, newAlertNotifier("webhook", w))
+	}
+	if url := os.Getenv("ALERT_SLACK_WEBHOOK_URL"); url != "" {
+		ns = append(ns, newAlertNotifier("slack", &slackSender{url: url}))
+	}
+	if key := os.Getenv("ALERT_PAGERDUTY_ROUTING_KEY"); key != "" {
+		severity := os.Getenv("ALERT_PAGERDUTY_SEVERITY")
+		switch severity {
+		case "":
+			severity = "critical"
+		case "critical", "error", "warning", "info":
+		default:
+			return nil, errors.New("ALERT_PAGERDUTY_SEVERITY must be critical, error, warning or info")
+		}
+		ns = append(ns, newAlertNotifier("pagerduty", &pagerDutySender{url: pagerDutyEventsURL, routingKey: key, severity: severity}))
+	}
+	if len(ns) > 0 {
+		expvar.Publish("alert_notifications_sent", expvar.Func(func() any { return ns.counts(func(n *alertNotifier) uint64 { return n.sent.Load() }) }))
+		expvar.Publish("alert_notifications_failed", expvar.Func(func() any { return ns.counts(func(n *alertNotifier) uint64 { return n.failed.Load() }) }))
+	}
+	return ns, nil
+}
+
+func newAlertNotifier(name string, s alertSender) *alertNotifier {
+	n := &alertNotifier{name: name, sender: s, events: make(chan alertEvent, alertQueueDepth), done: make(chan struct{})}
+	go n.run()
+	return n
+}
+
+// add queues e for every notifier without blocking.
+func (ns alertNotifiers) add(e alertEvent) {
+	for _, n := range ns {
+		select {
+		case n.events <- e:
+		default:
+			n.failed.Add(1)
+			slog.Warn("Alert queue full; dropping notification", "notifier", n.name, "status", e.Status, "rule", e.Rule)
+		}
+	}
+}
+
+// close sends what is queued and stops. add must not be called after it.
+func (ns alertNotifiers) close() {
+	for _, n := range ns {
+		close(n.events)
+	}
+	for _, n := range ns {
+		<-n.done
+	}
+}
+
+func (ns alertNotifiers) counts(get func(*alertNotifier) uint64) map[string]uint64 {
+	out := make(map[string]uint64, len(ns))
+	for _, n := range ns {
+		out[n.name] = get(n)
+	}
+	return out
+}
+
+func (n *alertNotifier) run() {
+	defer close(n.done)
+	for e := range n.events {
+		if err := n.deliver(e); err != nil {
+			n.failed.Add(1)
+			slog.Error("Alert notification failed", "notifier", n.name, "err", err)
+			continue
+		}
+		n.sent.Add(1)
+	}
+}
+
+func (n *alertNotifier) deliver(e alertEvent) error {
+	wait := alertSendBackoff
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), alertSendTimeout)
+		err := n.sender.send(ctx, e)
+		cancel()
+		var status *statusError
+		if err == nil || attempt == alertSendAttempts || (errors.As(err, &status) && !status.retryable()) {
+			return err
+		}
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
+
+// statusError is a response outside 2xx.
+type statusError struct {
+	code int
+	body string
+}
+
+func (e *statusError) Error() string {
+	if e.body == "" {
+		return fmt.Sprintf("status %d", e.code)
+	}
+	return fmt.Sprintf("status %d: %s", e.code, e.body)
+}
+
+// retryable reports whether sending the same request again could succeed.
+func (e *statusError) retryable() bool {
+	return e.code == http.StatusTooManyRequests || e.code >= 500
+}
+
+// post sends body and turns a non-2xx response into a statusError.
+func post(ctx context.Context, url, contentType string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return &statusError{code: resp.StatusCode, body: strings.TrimSpace(string(msg))}
+}
+
+// marshalJSON encodes v without escaping <, > and &, which alert rules are full of.
+func marshalJSON(v any) ([]byte, error) {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(b.Bytes(), []byte("\n")), nil
+}
+
+// jsonString is the template function json: v as a JSON value, so strings can be
+// embedded in a JSON template safely.
+func jsonString(v any) (string, error) {
+	b, err := marshalJSON(v)
+	return string(b), err
+}
+
+// webhookSender posts the event as JSON (ALERT_WEBHOOK_URL) or, with
+// ALERT_WEBHOOK_TEMPLATE, whatever the template renders for it.
+type webhookSender struct {
+	url  string
+	tmpl *template.Template // nil sends the event as JSON
+}
+
+func (w *webhookSender) send(ctx context.Context, e alertEvent) error {
+	if w.tmpl == nil {
+		body, err := marshalJSON(e)
+		if err != nil {
+			return err
+		}
+		return post(ctx, w.url, "application/json", body)
+	}
+	var b bytes.Buffer
+	if err := w.tmpl.Execute(&b, e); err != nil {
+		return err
+	}
+	return post(ctx, w.url, "application/json", b.Bytes())
+}
+
+// slackSender posts a one-line message to a Slack incoming webhook.
+type slackSender struct {
+	url string
+}
+
+func (s *slackSender) send(ctx context.Context, e alertEvent) error {
+	var text string
+	switch {
+	case e.Status == "resolved":
+		text = fmt.Sprintf(":white_check_mark: *Resolved* `%s` %s: value %g", e.Rule, formatEventTags(e.Tags), e.Value)
+	case e.Repeat:
+		text = fmt.Sprintf(":fire: *Still firing* `%s` %s: value %g since %s", e.Rule, formatEventTags(e.Tags), e.Value, e.Since.UTC().Format(time.RFC3339))
+	default:
+		text = fmt.Sprintf(":fire: *Firing* `%s` %s: value %g", e.Rule, formatEventTags(e.Tags), e.Value)
+	}
+	body, err := marshalJSON(map[string]string{"text": text})
+	if err != nil {
+		return err
+	}
+	return post(ctx, s.url, "application/json", body)
+}
+
+// formatEventTags writes an event's tags as k=v pairs in key order.
+func formatEventTags(tags map[string]string) string {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = k + "=" + tags[k]
+	}
+	return strings.Join(parts, " ")
+}
+
+// pagerDutySender triggers and resolves PagerDuty incidents through the Events API
+// v2, with the event's fingerprint as dedup_key, so repeats update the open incident
+// instead of opening new ones.
+type pagerDutySender struct {
+	url        string
+	routingKey string
+	severity   string
+}
+
+func (p *pagerDutySender) send(ctx context.Context, e alertEvent) error {
+	ev := map[string]any{
+		"routing_key":  p.routingKey,
+		"event_action": "trigger",
+		"dedup_key":    e.Fingerprint,
+	}
+	if e.Status == "resolved" {
+		ev["event_action"] = "resolve"
+	} else {
+		source := e.Tags[hostTag]
+		if source == "" {
+			source = "sentinel-server"
+		}
+		ev["payload"] = map[string]any{
+			"summary":   fmt.Sprintf("%s %s: value %g", e.Rule, formatEventTags(e.Tags), e.Value),
+			"source":    source,
+			"severity":  p.severity,
+			"timestamp": e.Since.UTC().Format(time.RFC3339),
+			"custom_details": map[string]any{
+				"measurement": e.Measurement,
+				"field":       e.Field,
+				"value":       e.Value,
+				"threshold":   e.Threshold,
+				"tags":        e.Tags,
+			},
+		}
+	}
+	body, err := marshalJSON(ev)
+	if err != nil {
+		return err
+	}
+	return post(ctx, p.url, "application/json", body)
+}
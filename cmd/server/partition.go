@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/binary"
+	"expvar"
+	"hash/fnv"
+	"math"
+	"sync/atomic"
+)
+
+// partition splits the Pub/Sub stream between replicas that all receive every message:
+// each sample belongs to exactly one replica, chosen by jump consistent hashing of its
+// host, so REPLICA_COUNT can change with minimal reshuffling. Samples without a host
+// (the binary layout) hash their timestamp and values instead, which spreads one
+// host's samples across replicas but still writes each exactly once.
+type partition struct {
+	index, count int
+	skipped      atomic.Uint64
+}
+
+func newPartition(index, count int) *partition {
+	p := &partition{index: index, count: count}
+	expvar.Publish("partition_skipped", expvar.Func(func() any { return p.skipped.Load() }))
+	return p
+}
+
+// owns reports whether this replica handles d, counting the ones it skips.
+func (p *partition) owns(d *decoded) bool {
+	h := fnv.New64a()
+	if d.host != "" {
+		h.Write([]byte(d.host))
+	} else {
+		var b [32]byte
+		binary.LittleEndian.PutUint64(b[0:8], uint64(d.point.TimeNano))
+		binary.LittleEndian.PutUint64(b[8:16], math.Float64bits(d.point.CPU))
+		binary.LittleEndian.PutUint64(b[16:24], math.Float64bits(d.point.Mem))
+		binary.LittleEndian.PutUint64(b[24:32], uint64(d.sendTimeNano))
+		h.Write(b[:])
+	}
+	if jumpHash(h.Sum64(), p.count) == p.index {
+		return true
+	}
+	p.skipped.Add(1)
+	return false
+}
+
+// jumpHash is Lamping and Veach's jump consistent hash: it maps key to a bucket in
+// [0, buckets) and moves only 1/buckets of keys when a bucket is added.
+func jumpHash(key uint64, buckets int) int {
+	var b, j int64 = -1, 0
+	for j < int64(buckets) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
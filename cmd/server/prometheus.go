@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bufio"
+	"expvar"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// e2eBuckets are the upper bounds, in seconds, of the E2E latency histogram.
+var e2eBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// pipelineMetrics are the counters behind /metrics. Unlike the stats windows they are
+// never reset, as Prometheus expects, and they are atomics because the handler reads
+// them while the batcher updates them.
+type pipelineMetrics struct {
+	consumed     atomic.Uint64 // messages handed to the batcher
+	decodeErrors atomic.Uint64
+	flushes      atomic.Uint64 // non-empty batches flushed
+	e2e          histogram
+}
+
+func newPipelineMetrics() *pipelineMetrics {
+	m := &pipelineMetrics{e2e: histogram{bounds: e2eBuckets, counts: make([]atomic.Uint64, len(e2eBuckets))}}
+	expvar.Publish("messages_consumed", expvar.Func(func() any { return m.consumed.Load() }))
+	expvar.Publish("decode_errors", expvar.Func(func() any { return m.decodeErrors.Load() }))
+	expvar.Publish("batch_flushes", expvar.Func(func() any { return m.flushes.Load() }))
+	return m
+}
+
+// histogram is a Prometheus-style histogram of durations. counts are per bucket, not
+// cumulative; the handler adds them up, and its _count is the +Inf bucket.
+type histogram struct {
+	bounds  []float64
+	counts  []atomic.Uint64
+	inf     atomic.Uint64 // above the last bound
+	sumNano atomic.Int64
+}
+
+func (h *histogram) observe(d time.Duration) {
+	s := d.Seconds()
+	i := 0
+	for i < len(h.bounds) && s > h.bounds[i] {
+		i++
+	}
+	if i < len(h.bounds) {
+		h.counts[i].Add(1)
+	} else {
+		h.inf.Add(1)
+	}
+	h.sumNano.Add(int64(d))
+}
+
+// metricsHandler serves m, and influx's retries and write failures if Influx is configured, in the
+// Prometheus text format, along with whether each broker instance in health is connected
+// and how far the consumer of queues is behind.
+func metricsHandler(m *pipelineMetrics, influx *influxWriter, health *brokerHealth, queues *recvQueues) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		bw := bufio.NewWriter(w)
+		counter := func(name, help string, v uint64) {
+			fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", name, help, name, name, v)
+		}
+		counter("sentinel_messages_consumed_total", "Messages received from the broker and handed to the decoder.", m.consumed.Load())
+		counter("sentinel_decode_errors_total", "Messages that could not be decoded.", m.decodeErrors.Load())
+		counter("sentinel_batch_flushes_total", "Batches flushed to the sinks.", m.flushes.Load())
+		if influx != nil {
+			counter("sentinel_influx_retries_total", "InfluxDB write attempts that were retried.", influx.sink.Retried())
+			counter("sentinel_influx_write_failures_total", "Batches InfluxDB did not accept, after any retries.", influx.failed.Load())
+		}
+		fmt.Fprint(bw, "# HELP sentinel_broker_up Whether the broker instance is connected (1) or its receiver is reconnecting (0).\n# TYPE sentinel_broker_up gauge\n")
+		for _, s := range health.status() {
+			up := 0
+			if s.Connected {
+				up = 1
+			}
+			fmt.Fprintf(bw, "sentinel_broker_up{source=%q} %d\n", s.Source, up)
+		}
+		const lag = "sentinel_consumer_lag_seconds"
+		fmt.Fprintf(bw, "# HELP %s How long the oldest queued message has waited for the decoder, at most.\n# TYPE %s gauge\n%s %g\n", lag, lag, lag, queues.lag(time.Now()).Seconds())
+
+		const name = "sentinel_e2e_latency_seconds"
+		fmt.Fprintf(bw, "# HELP %s Time from the agent's send to the point joining a batch.\n# TYPE %s histogram\n", name, name)
+		var cum uint64
+		for i, le := range m.e2e.bounds {
+			cum += m.e2e.counts[i].Load()
+			fmt.Fprintf(bw, "%s_bucket{le=\"%g\"} %d\n", name, le, cum)
+		}
+		cum += m.e2e.inf.Load()
+		fmt.Fprintf(bw, "%s_bucket{le=\"+Inf\"} %d\n", name, cum)
+		fmt.Fprintf(bw, "%s_sum %g\n%s_count %d\n", name, time.Duration(m.e2e.sumNano.Load()).Seconds(), name, cum)
+		bw.Flush()
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"expvar"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/transport"
+)
+
+const defaultRecvQueueDepth = 4096
+
+// inbound is a raw Redis message waiting to be decoded.
+type inbound struct {
+	payload string
+	recvAt  time.Time
+	src     *source
+	channel string
+	// decodeAt is when the batcher started decoding it; set only for tracing.
+	decodeAt time.Time
+
+	// Set for transports that ack (-stream), so the message is acked once written.
+	acker transport.Acker
+	id    string
+}
+
+// channelSpec is one subscribed channel and its drain priority (higher first).
+type channelSpec struct {
+	name     string
+	priority int
+}
+
+// parseChannels reads REDIS_CHANNEL: a comma-separated list of channels, each
+// optionally suffixed with "=priority" (default 0), e.g. "prod=10,staging".
+func parseChannels(v string) ([]channelSpec, error) {
+	if strings.TrimSpace(v) == "" {
+		return []channelSpec{{name: defaultMetricsChannel}}, nil
+	}
+	var specs []channelSpec
+	seen := make(map[string]bool)
+	for _, entry := range strings.Split(v, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		spec := channelSpec{name: entry}
+		if i := strings.LastIndex(entry, "="); i >= 0 {
+			prio, err := strconv.Atoi(entry[i+1:])
+			if err != nil {
+				return nil, fmt.Errorf("bad priority in %q", entry)
+			}
+			spec = channelSpec{name: strings.TrimSpace(entry[:i]), priority: prio}
+		}
+		if spec.name == "" || seen[spec.name] {
+			return nil, fmt.Errorf("empty or duplicate channel in %q", entry)
+		}
+		seen[spec.name] = true
+		specs = append(specs, spec)
+	}
+	return specs, nil
+}
+
+// recvQueue is one bounded priority level between the Redis receivers and the
+// decode/batch stage.
+type recvQueue struct {
+	priority  int
+	ch        chan inbound
+	highWater atomic.Int64
+}
+
+// recvQueues is the hand-off between the Redis receivers and the decode/batch stage.
+// Receivers only enqueue, so a burst is absorbed here instead of backing up inside
+// go-redis. Each priority level has its own bounded queue; every enqueue also posts a
+// token on ready, and the consumer takes one token per message and then drains the
+// highest non-empty level, so busy low-priority channels can't starve important ones.
+// Depths and high-water marks are exported on /debug/vars.
+type recvQueues struct {
+	levels    []*recvQueue // highest priority first
+	byChannel map[string]*recvQueue
+	ready     chan struct{}
+	lastRecv  map[string]*atomic.Int64 // per channel, unix nanos of the latest message; 0 = none yet
+	lastTaken atomic.Int64             // receive time, unix nanos, of the message next last returned
+}
+
+func newRecvQueues(channels []channelSpec, depth int) *recvQueues {
+	qs := &recvQueues{byChannel: make(map[string]*recvQueue), lastRecv: make(map[string]*atomic.Int64)}
+	byPrio := make(map[int]*recvQueue)
+	for _, c := range channels {
+		q := byPrio[c.priority]
+		if q == nil {
+			q = &recvQueue{priority: c.priority, ch: make(chan inbound, depth)}
+			byPrio[c.priority] = q
+			qs.levels = append(qs.levels, q)
+		}
+		qs.byChannel[c.name] = q
+		qs.lastRecv[c.name] = new(atomic.Int64)
+	}
+	sort.Slice(qs.levels, func(i, j int) bool { return qs.levels[i].priority > qs.levels[j].priority })
+	qs.ready = make(chan struct{}, depth*len(qs.levels))
+
+	expvar.Publish("recv_queue_depth", expvar.Func(func() any { return qs.depths(func(q *recvQueue) int64 { return int64(len(q.ch)) }) }))
+	expvar.Publish("recv_queue_capacity", expvar.Func(func() any { return depth }))
+	expvar.Publish("consumer_lag_seconds", expvar.Func(func() any { return qs.lag(time.Now()).Seconds() }))
+	expvar.Publish("recv_queue_high_water", expvar.Func(func() any { return qs.depths(func(q *recvQueue) int64 { return q.highWater.Load() }) }))
+	return qs
+}
+
+// depths reports f per priority level, keyed by priority.
+func (qs *recvQueues) depths(f func(*recvQueue) int64) map[string]int64 {
+	out := make(map[string]int64, len(qs.levels))
+	for _, q := range qs.levels {
+		out[strconv.Itoa(q.priority)] = f(q)
+	}
+	return out
+}
+
+// push enqueues a message received on channel.
+func (qs *recvQueues) push(channel string, m inbound) {
+	q := qs.byChannel[channel]
+	if q == nil {
+		return // not one of ours (e.g. a pattern subscription added elsewhere)
+	}
+	qs.lastRecv[channel].Store(m.recvAt.UnixNano())
+	q.ch <- m
+	qs.ready <- struct{}{}
+	n := int64(len(q.ch))
+	for {
+		hw := q.highWater.Load()
+		if n <= hw || q.highWater.CompareAndSwap(hw, n) {
+			return
+		}
+	}
+}
+
+// next returns the highest-priority queued message. Call it once per token received
+// from ready, and only from the single consumer goroutine.
+func (qs *recvQueues) next() inbound {
+	for {
+		for _, q := range qs.levels {
+			select {
+			case m := <-q.ch:
+				qs.lastTaken.Store(m.recvAt.UnixNano())
+				return m
+			default:
+			}
+		}
+		// The token was posted after the enqueue, so a message is always present;
+		// this loop only spins if the scheduler interleaves oddly between the two.
+	}
+}
+
+// lag is how far the consumer is behind at now: with messages waiting, the time since
+// the last one it took was received, which bounds how long the oldest has waited (and
+// keeps growing while the consumer is stuck); with none waiting, zero.
+func (qs *recvQueues) lag(now time.Time) time.Duration {
+	waiting := false
+	for _, q := range qs.levels {
+		if len(q.ch) > 0 {
+			waiting = true
+			break
+		}
+	}
+	if !waiting {
+		return 0
+	}
+	taken := qs.lastTaken.Load()
+	if taken == 0 {
+		return 0 // the consumer hasn't started
+	}
+	return max(now.Sub(time.Unix(0, taken)), 0)
+}
+
+// report logs QUEUE_STATS, one record per priority level.
+func (qs *recvQueues) report() {
+	for _, q := range qs.levels {
+		slog.Info("QUEUE_STATS", "prio", q.priority, "depth", len(q.ch), "capacity", cap(q.ch), "high_water", q.highWater.Load())
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/sink"
+)
+
+const rollupMeasurement = "system_stats_rollup"
+
+// rollup keeps per-series min/max/mean of cpu and mem over fixed, aligned intervals and
+// emits a system_stats_rollup point when a series moves into its next interval, when
+// expire finds the interval long over (the series went quiet), or from flushAll at
+// shutdown. A series is a tag set, so untagged samples from different hosts share one
+// series. It is owned by the consumer goroutine.
+type rollup struct {
+	interval int64 // ns
+	series   map[string]*rollupBucket
+}
+
+// rollupPoint is a finished interval and the deliveries that may only be acked once it
+// has been written.
+type rollupPoint struct {
+	sink.Point
+	held []*heldAck
+}
+
+type rollupBucket struct {
+	start  int64
+	tags   []sink.Tag
+	held   []*heldAck // deliveries with points in the interval (-stream)
+	count  int
+	cpuSum float64
+	cpuMin float64
+	cpuMax float64
+	memSum float64
+	memMin float64
+	memMax float64
+}
+
+func newRollup(interval time.Duration) *rollup {
+	return &rollup{interval: int64(interval), series: make(map[string]*rollupBucket)}
+}
+
+// add folds a system_stats point into its series. When the point starts a new interval,
+// the finished one is returned as a rollup point. h, if not nil, is the delivery the
+// point came from; it is held until the interval the point went into is written.
+func (r *rollup) add(p sink.Point, h *heldAck) (rollupPoint, bool) {
+	start := p.TimeNano - p.TimeNano%r.interval
+	key := seriesKey(p.Tags)
+	b := r.series[key]
+	if b == nil {
+		b = &rollupBucket{}
+		r.series[key] = b
+		b.reset(start, p, h)
+		return rollupPoint{}, false
+	}
+	if start <= b.start {
+		// Late points are folded into the open interval rather than reopening a closed one.
+		b.fold(p, h)
+		return rollupPoint{}, false
+	}
+	out := b.point()
+	b.reset(start, p, h)
+	return out, true
+}
+
+// expire removes and returns the intervals that ended at least one interval before now,
+// so a series that went quiet is still written. That extra interval leaves room for
+// late points; one that arrives after its interval expired starts it again, and the
+// point written for it then replaces the earlier one.
+func (r *rollup) expire(now time.Time) []rollupPoint {
+	var out []rollupPoint
+	for key, b := range r.series {
+		if b.start+2*r.interval <= now.UnixNano() {
+			out = append(out, b.point())
+			delete(r.series, key)
+		}
+	}
+	return out
+}
+
+// flushAll removes and returns every open interval, for shutdown.
+func (r *rollup) flushAll() []rollupPoint {
+	out := make([]rollupPoint, 0, len(r.series))
+	for key, b := range r.series {
+		out = append(out, b.point())
+		delete(r.series, key)
+	}
+	return out
+}
+
+// maxHold is about the longest a delivery waits in a bucket: until its interval expires,
+// plus up to one interval for the sweep that finds it (see batcher.run).
+func (r *rollup) maxHold() time.Duration {
+	return time.Duration(3 * r.interval)
+}
+
+func (b *rollupBucket) reset(start int64, p sink.Point, h *heldAck) {
+	*b = rollupBucket{start: start, tags: p.Tags, cpuMin: p.CPU, cpuMax: p.CPU, memMin: p.Mem, memMax: p.Mem}
+	b.fold(p, h)
+}
+
+func (b *rollupBucket) fold(p sink.Point, h *heldAck) {
+	if h != nil && (len(b.held) == 0 || b.held[len(b.held)-1] != h) {
+		h.refs++
+		b.held = append(b.held, h)
+	}
+	b.count++
+	b.cpuSum += p.CPU
+	b.memSum += p.Mem
+	b.cpuMin, b.cpuMax = min(b.cpuMin, p.CPU), max(b.cpuMax, p.CPU)
+	b.memMin, b.memMax = min(b.memMin, p.Mem), max(b.memMax, p.Mem)
+}
+
+func (b *rollupBucket) point() rollupPoint {
+	n := float64(b.count)
+	return rollupPoint{held: b.held, Point: sink.Point{
+		Measurement: rollupMeasurement,
+		TimeNano:    b.start,
+		Fields: []sink.Field{
+			{Key: "cpu_mean", Value: b.cpuSum / n},
+			{Key: "cpu_min", Value: b.cpuMin},
+			{Key: "cpu_max", Value: b.cpuMax},
+			{Key: "mem_mean", Value: b.memSum / n},
+			{Key: "mem_min", Value: b.memMin},
+			{Key: "mem_max", Value: b.memMax},
+			{Key: "count", Value: n},
+		},
+		Tags: b.tags,
+	}}
+}
+
+// seriesKey identifies a tag set; the common untagged case costs nothing.
+func seriesKey(tags []sink.Tag) string {
+	if len(tags) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	for _, t := range tags {
+		sb.WriteString(t.Key)
+		sb.WriteByte('=')
+		sb.WriteString(t.Value)
+		sb.WriteByte(',')
+	}
+	return sb.String()
+}
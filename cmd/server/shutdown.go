@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/transport"
+)
+
+// defaultShutdownTimeout stays under Kubernetes' default 30s termination grace period.
+const defaultShutdownTimeout = 20 * time.Second
+
+// shutdown stops the pipeline front to back so nothing already received is lost: the
+// receivers unsubscribe, the batcher drains the receive queue and flushes its partial
+// batch, the Influx writers finish every queued and in-flight write (acking -stream
+// entries as they succeed), the dead letters and alert notifications are sent, and only
+// then are the broker connections closed. The extra sinks are closed by main's defers
+// afterwards. If that takes longer than timeout it gives up and lets main exit with
+// whatever is left unwritten.
+func shutdown(stopRecv context.CancelFunc, receivers *sync.WaitGroup, b *batcher, influx *influxWriter, dead *deadLetters, alerts alertNotifiers, transports []transport.Transport, timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		stopRecv()
+		receivers.Wait()
+		close(b.drainReq)
+		<-b.done
+		if influx != nil {
+			influx.close()
+		}
+		if dead != nil {
+			dead.close()
+		}
+		if len(alerts) > 0 {
+			alerts.close()
+		}
+		for _, tr := range transports {
+			if err := tr.Close(); err != nil {
+				slog.Error("Transport close failed", "err", err)
+			}
+		}
+	}()
+	select {
+	case <-done:
+		slog.Info("Shutdown complete")
+	case <-b.clock.After(timeout):
+		slog.Warn("Shutdown timed out; pending points may be lost", "timeout", timeout)
+	}
+}
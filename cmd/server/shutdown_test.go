@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/clock"
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/sink"
+)
+
+// blockingSink holds every write until release is closed.
+type blockingSink struct{ release chan struct{} }
+
+func (s blockingSink) WriteBatch(context.Context, []sink.Point) error {
+	<-s.release
+	return nil
+}
+
+func (blockingSink) Close() error { return nil }
+
+func TestShutdownDrainsQueue(t *testing.T) {
+	setBatchSize(t, 100)
+	snk := &fakeSink{ev: &events{}}
+	// The clock never moves, so only finishing the drain can end the shutdown.
+	b := newTestBatcher(t, clock.NewFake(testStart), namedSink{"fake", snk})
+	for i := int64(0); i < 3; i++ {
+		b.queue.push("metrics", message(nil, "", binarySample(i)))
+	}
+	go b.run(context.Background())
+
+	var receivers sync.WaitGroup
+	shutdown(func() {}, &receivers, b, nil, nil, nil, nil, time.Second)
+	if n := snk.points(); n != 3 {
+		t.Errorf("%d points written by shutdown, want 3", n)
+	}
+}
+
+func TestShutdownTimesOut(t *testing.T) {
+	setBatchSize(t, 100)
+	clk := clock.NewFake(testStart)
+	release := make(chan struct{})
+	defer close(release)
+	b := newTestBatcher(t, clk, namedSink{"stuck", blockingSink{release}})
+	b.queue.push("metrics", message(nil, "", binarySample(1)))
+	go b.run(context.Background())
+
+	returned := make(chan struct{})
+	go func() {
+		var receivers sync.WaitGroup
+		shutdown(func() {}, &receivers, b, nil, nil, nil, nil, 20*time.Second)
+		close(returned)
+	}()
+	// The final flush never finishes, so shutdown only returns once its timeout passes
+	// on the clock. Advance until it has started waiting on it.
+	clk.Advance(19 * time.Second)
+	select {
+	case <-returned:
+		t.Fatal("shutdown returned before its timeout")
+	case <-time.After(20 * time.Millisecond):
+	}
+	waitFor(t, "shutdown to time out", func() bool {
+		clk.Advance(time.Second)
+		select {
+		case <-returned:
+			return true
+		default:
+			return false
+		}
+	})
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/clock"
+)
+
+// silenceCheckEvery is how often watch looks for silent channels.
+const silenceCheckEvery = time.Second
+
+// silenceTracker reports how long each subscribed channel has gone without a message,
+// so a pipeline where every agent has died is visible instead of just quiet. Channels
+// that have never received anything count from server start.
+type silenceTracker struct {
+	clock  clock.Clock
+	queues *recvQueues
+	start  time.Time
+}
+
+func newSilenceTracker(clk clock.Clock, qs *recvQueues) *silenceTracker {
+	t := &silenceTracker{clock: clk, queues: qs, start: clk.Now()}
+	expvar.Publish("channel_silence_seconds", expvar.Func(func() any {
+		out := make(map[string]float64, len(qs.lastRecv))
+		for name := range qs.lastRecv {
+			out[name] = t.since(name).Seconds()
+		}
+		return out
+	}))
+	return t
+}
+
+// since is the time since the latest message on channel.
+func (t *silenceTracker) since(channel string) time.Duration {
+	last := t.start
+	if ns := t.queues.lastRecv[channel].Load(); ns != 0 {
+		last = time.Unix(0, ns)
+	}
+	return t.clock.Since(last)
+}
+
+// watch logs once when a channel has been silent for threshold (SILENCE_ALERT_AFTER) and
+// again when it recovers, until ctx is done.
+func (t *silenceTracker) watch(ctx context.Context, threshold time.Duration) {
+	names := make([]string, 0, len(t.queues.lastRecv))
+	for name := range t.queues.lastRecv {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	silent := make(map[string]bool)
+	ticker := t.clock.NewTicker(silenceCheckEvery)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+		for _, name := range names {
+			quiet := t.since(name)
+			switch {
+			case quiet >= threshold && !silent[name]:
+				silent[name] = true
+				slog.Warn("No messages on channel", "channel", name, "quiet_for", quiet.Round(time.Second))
+			case quiet < threshold && silent[name]:
+				silent[name] = false
+				slog.Info("Messages on channel resumed", "channel", name)
+			}
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"expvar"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/sink"
+)
+
+// namedSink labels a sink for log messages.
+type namedSink struct {
+	name string
+	sink.Sink
+}
+
+// extraSinksFromEnv builds the optional sinks that receive every batch in addition to
+// InfluxDB. A new backend is a sink.Sink in internal/sink plus a block here.
+func extraSinksFromEnv(prec sink.Precision) ([]namedSink, error) {
+	var sinks []namedSink
+
+	if path := os.Getenv("FILE_SINK_PATH"); path != "" {
+		flushEvery := time.Second
+		if v := os.Getenv("FILE_SINK_FLUSH_INTERVAL"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return nil, fmt.Errorf("FILE_SINK_FLUSH_INTERVAL: %w", err)
+			}
+			flushEvery = d
+		}
+		policy := sink.FsyncInterval
+		if v := os.Getenv("FILE_SINK_FSYNC"); v != "" {
+			p, err := sink.ParseFsyncPolicy(v)
+			if err != nil {
+				return nil, err
+			}
+			policy = p
+		}
+		fs, err := sink.NewFileSink(path, flushEvery, policy, prec)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, namedSink{name: "file", Sink: fs})
+	}
+
+	if v := os.Getenv("STDOUT_SINK"); v != "" {
+		on, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("STDOUT_SINK must be a boolean")
+		}
+		if on {
+			sinks = append(sinks, namedSink{name: "stdout", Sink: sink.NewWriterSink(os.Stdout, prec)})
+		}
+	}
+
+	if url := os.Getenv("VM_URL"); url != "" {
+		format := os.Getenv("VM_FORMAT")
+		if format == "" {
+			format = sink.VMFormatInflux
+		}
+		vm, err := sink.NewVictoriaMetricsSink(url, format, prec)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, namedSink{name: "victoriametrics", Sink: vm})
+	}
+
+	if addr := os.Getenv("GRAPHITE_ADDR"); addr != "" {
+		g, err := sink.NewGraphiteSink(addr, os.Getenv("GRAPHITE_TEMPLATE"))
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, namedSink{name: "graphite", Sink: g})
+	}
+
+	if path := os.Getenv("SQLITE_PATH"); path != "" {
+		db, err := sink.NewSQLiteSink(path)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, namedSink{name: "sqlite", Sink: db})
+	}
+
+	if url := os.Getenv("CLICKHOUSE_URL"); url != "" {
+		table := os.Getenv("CLICKHOUSE_TABLE")
+		if table == "" {
+			table = "metrics"
+		}
+		ch, err := sink.NewClickHouseSink(url, table, os.Getenv("CLICKHOUSE_USER"), os.Getenv("CLICKHOUSE_PASSWORD"))
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, namedSink{name: "clickhouse", Sink: ch})
+	}
+
+	if dsn := os.Getenv("POSTGRES_URL"); dsn != "" {
+		table := os.Getenv("POSTGRES_TABLE")
+		if table == "" {
+			table = "metrics"
+		}
+		pg, err := sink.NewPostgresSink(dsn, table)
+		if err != nil {
+			return nil, err
+		}
+		if pg.Hypertable() {
+			slog.Info("Postgres sink writing to a TimescaleDB hypertable", "table", table)
+		}
+		sinks = append(sinks, namedSink{name: "postgres", Sink: pg})
+	}
+
+	if dir := os.Getenv("PARQUET_DIR"); dir != "" {
+		name := os.Getenv("PARQUET_COMPRESSION")
+		if name == "" {
+			name = "snappy"
+		}
+		codec, err := sink.ParseParquetCompression(name)
+		if err != nil {
+			return nil, fmt.Errorf("PARQUET_COMPRESSION: %w", err)
+		}
+		maxMB := 128
+		if v := os.Getenv("PARQUET_MAX_MB"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("PARQUET_MAX_MB must be a positive integer")
+			}
+			maxMB = n
+		}
+		maxAge := time.Hour
+		if v := os.Getenv("PARQUET_ROTATE_INTERVAL"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("PARQUET_ROTATE_INTERVAL must be a positive duration")
+			}
+			maxAge = d
+		}
+		pq, err := sink.NewParquetSink(dir, os.Getenv("PARQUET_FILENAME"), codec, int64(maxMB)<<20, maxAge)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, namedSink{name: "parquet", Sink: pq})
+	}
+
+	if bucket := os.Getenv("S3_BUCKET"); bucket != "" {
+		opts := sink.S3Options{
+			Endpoint:   os.Getenv("S3_ENDPOINT"),
+			Region:     os.Getenv("S3_REGION"),
+			Bucket:     bucket,
+			AccessKey:  os.Getenv("S3_ACCESS_KEY_ID"),
+			SecretKey:  os.Getenv("S3_SECRET_ACCESS_KEY"),
+			Prefix:     os.Getenv("S3_PREFIX"),
+			Format:     os.Getenv("S3_FORMAT"),
+			Prec:       prec,
+			FlushBytes: 64 << 20,
+			FlushEvery: 10 * time.Minute,
+			PartSize:   16 << 20,
+		}
+		if opts.Format == "" {
+			opts.Format = sink.ArchiveFormatLineProtocol
+		}
+		if v := os.Getenv("S3_INSECURE"); v != "" {
+			insecure, err := strconv.ParseBool(v)
+			if err != nil {
+				return nil, fmt.Errorf("S3_INSECURE must be a boolean")
+			}
+			opts.Insecure = insecure
+		}
+		if v := os.Getenv("PARQUET_COMPRESSION"); v != "" {
+			codec, err := sink.ParseParquetCompression(v)
+			if err != nil {
+				return nil, fmt.Errorf("PARQUET_COMPRESSION: %w", err)
+			}
+			opts.Codec = codec
+		}
+		if v := os.Getenv("S3_FLUSH_MB"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("S3_FLUSH_MB must be a positive integer")
+			}
+			opts.FlushBytes = int64(n) << 20
+		}
+		if v := os.Getenv("S3_FLUSH_INTERVAL"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("S3_FLUSH_INTERVAL must be a positive duration")
+			}
+			opts.FlushEvery = d
+		}
+		if v := os.Getenv("S3_PART_MB"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 5 {
+				return nil, fmt.Errorf("S3_PART_MB must be an integer of at least 5")
+			}
+			opts.PartSize = uint64(n) << 20
+		}
+		s3, err := sink.NewS3Sink(opts)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, namedSink{name: "s3", Sink: s3})
+	}
+
+	if name := os.Getenv("SHADOW_SINK"); name != "" {
+		i := slices.IndexFunc(sinks, func(s namedSink) bool { return s.name == name })
+		if i < 0 {
+			return nil, fmt.Errorf("SHADOW_SINK %q is not a configured sink (file, stdout, victoriametrics, graphite, sqlite, clickhouse, postgres, parquet, s3)", name)
+		}
+		shadow := sink.NewShadowSink(nil, sinks[i].Sink, name, sink.DefaultShadowQueue, sink.DefaultShadowTimeout)
+		expvar.Publish("shadow_errors", expvar.Func(func() any { return shadow.Errors() }))
+		expvar.Publish("shadow_dropped", expvar.Func(func() any { return shadow.Dropped() }))
+		sinks[i].Sink = shadow
+	}
+
+	return sinks, nil
+}
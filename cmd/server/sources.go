@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/clock"
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/sink"
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/transport"
+)
+
+const (
+	minReconnectBackoff = 100 * time.Millisecond
+	maxReconnectBackoff = 5 * time.Second
+)
+
+// source is one broker instance the server subscribes to. region, when set,
+// is written as a tag on every point received from it.
+type source struct {
+	addr   string
+	region string
+	tags   []sink.Tag
+}
+
+func (s source) String() string {
+	if s.region == "" {
+		return s.addr
+	}
+	return s.region + "=" + s.addr
+}
+
+// parseSources reads a comma-separated REDIS_ADDR, where each entry is either
+// "host:port" or "region=host:port". An empty value means defaultAddr.
+func parseSources(v, defaultAddr string) ([]source, error) {
+	if strings.TrimSpace(v) == "" {
+		return []source{{addr: defaultAddr}}, nil
+	}
+	var sources []source
+	for _, entry := range strings.Split(v, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		var src source
+		if region, addr, ok := strings.Cut(entry, "="); ok {
+			src = source{addr: strings.TrimSpace(addr), region: strings.TrimSpace(region)}
+		} else {
+			src = source{addr: entry}
+		}
+		if src.addr == "" {
+			return nil, fmt.Errorf("empty address in %q", entry)
+		}
+		if src.region != "" {
+			src.tags = []sink.Tag{{Key: "region", Value: src.region}}
+		}
+		sources = append(sources, src)
+	}
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("no addresses in %q", v)
+	}
+	return sources, nil
+}
+
+// receive subscribes to one broker instance over tr and enqueues raw messages until ctx
+// is done; the caller closes tr. Subscriptions reconnect on the next Receive after a connection
+// error, so an outage on one instance only backs off this receiver; the others keep flowing. After
+// maxAttempts consecutive failures (0 = never) it reports on giveUp and stops. Failures and
+// recoveries are recorded in health.
+// With auth set it also subscribes to the control channel and hands hellos to it.
+// If the subscription acks (-stream), hellos are acked right away and everything else
+// once the batcher has written it.
+func receive(ctx context.Context, clk clock.Clock, tr transport.Transport, src *source, channels []channelSpec, q *recvQueues, auth *agentAuth, health *brokerHealth, maxAttempts int, giveUp chan<- error) {
+	names := make([]string, len(channels))
+	for i, c := range channels {
+		names[i] = c.name
+	}
+	if auth != nil {
+		names = append(names, auth.channel)
+	}
+	sub := tr.Subscribe(ctx, names...)
+	defer sub.Close()
+	acker, _ := sub.(transport.Acker)
+
+	backoff := minReconnectBackoff
+	failures := 0
+	for {
+		msg, err := sub.Receive(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			failures++
+			health.down(src, err)
+			if maxAttempts > 0 && failures > maxAttempts {
+				giveUp <- fmt.Errorf("%s unreachable after %d reconnect attempts: %w", src, maxAttempts, err)
+				return
+			}
+			slog.Warn("Receive error", "source", src.String(), "err", err, "retry_in", backoff)
+			select {
+			case <-ctx.Done():
+				return
+			case <-clk.After(backoff):
+			}
+			backoff = min(backoff*2, maxReconnectBackoff)
+			continue
+		}
+		if failures > 0 {
+			slog.Info("Receiving again", "source", src.String(), "failed_attempts", failures)
+		}
+		backoff, failures = minReconnectBackoff, 0
+		health.up(src)
+		if auth != nil && msg.Channel == auth.channel {
+			auth.hello(src, []byte(msg.Payload))
+			if acker != nil {
+				if err := acker.Ack(ctx, msg); err != nil {
+					slog.Warn("Hello ack failed", "source", src.String(), "channel", msg.Channel, "err", err)
+				}
+			}
+			continue
+		}
+		in := inbound{payload: msg.Payload, recvAt: clk.Now(), src: src, channel: msg.Channel}
+		if acker != nil {
+			in.acker, in.id = acker, msg.ID
+		}
+		q.push(msg.Channel, in)
+	}
+}
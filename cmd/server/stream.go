@@ -0,0 +1,106 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// uiFiles is the demo dashboard served under /ui/ when the server runs with -ui.
+//
+//go:embed ui
+var uiFiles embed.FS
+
+// streamBuffer is how many samples a slow /stream client may fall behind before
+// samples are dropped for it; the batcher never waits on a browser.
+const streamBuffer = 64
+
+// liveSample is one system_stats point as sent to /stream clients.
+type liveSample struct {
+	TimeMs int64   `json:"t"`
+	CPU    float64 `json:"cpu"`
+	Mem    float64 `json:"mem"`
+	Host   string  `json:"host"`
+}
+
+// liveHub fans decoded samples out to the connected /stream clients.
+type liveHub struct {
+	mu      sync.Mutex
+	clients map[chan liveSample]struct{}
+}
+
+func newLiveHub() *liveHub {
+	return &liveHub{clients: make(map[chan liveSample]struct{})}
+}
+
+func (h *liveHub) subscribe() chan liveSample {
+	ch := make(chan liveSample, streamBuffer)
+	h.mu.Lock()
+	h.clients[ch] = struct{}{}
+	h.mu.Unlock()
+	return ch
+}
+
+func (h *liveHub) unsubscribe(ch chan liveSample) {
+	h.mu.Lock()
+	delete(h.clients, ch)
+	h.mu.Unlock()
+}
+
+// publish offers s to every client without blocking.
+func (h *liveHub) publish(s liveSample) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.clients {
+		select {
+		case ch <- s:
+		default:
+		}
+	}
+}
+
+// streamHandler serves GET /stream as Server-Sent Events, one JSON sample per event.
+func (h *liveHub) streamHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	flusher.Flush()
+
+	ch := h.subscribe()
+	defer h.unsubscribe(ch)
+	keepalive := time.NewTicker(15 * time.Second)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case s := <-ch:
+			data, err := json.Marshal(s)
+			if err != nil { // a NaN or Inf value, which JSON can't carry
+				continue
+			}
+			fmt.Fprintf(w, "data: %s\n\n", data)
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+		}
+		flusher.Flush()
+	}
+}
+
+// registerUI mounts /stream and the embedded dashboard on the default mux.
+func registerUI(h *liveHub) {
+	sub, err := fs.Sub(uiFiles, "ui")
+	if err != nil {
+		panic(err)
+	}
+	http.HandleFunc("/stream", h.streamHandler)
+	http.Handle("/ui/", http.StripPrefix("/ui/", http.FileServer(http.FS(sub))))
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestStreamSendsJSON(t *testing.T) {
+	hub := newLiveHub()
+	srv := httptest.NewServer(http.HandlerFunc(hub.streamHandler))
+	defer srv.Close()
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	waitFor(t, "the client to subscribe", func() bool {
+		hub.mu.Lock()
+		defer hub.mu.Unlock()
+		return len(hub.clients) == 1
+	})
+
+	hub.publish(liveSample{TimeMs: 1, CPU: math.NaN(), Mem: 2, Host: "skipped"})
+	hub.publish(liveSample{TimeMs: 2, CPU: math.Inf(1), Mem: 2, Host: "skipped"})
+	// Hosts are agent-supplied, so anything may be in them.
+	want := liveSample{TimeMs: 3, CPU: 1e-7, Mem: 50, Host: "web \"1\" \x7f"}
+	hub.publish(want)
+
+	r := bufio.NewReader(resp.Body)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok {
+			continue
+		}
+		var got liveSample
+		if err := json.Unmarshal([]byte(data), &got); err != nil {
+			t.Fatalf("event %q: %v", data, err)
+		}
+		if got != want {
+			t.Errorf("got %+v, want %+v", got, want)
+		}
+		return
+	}
+}
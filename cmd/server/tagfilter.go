@@ -0,0 +1,97 @@
+package main
+
+import (
+	"expvar"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/clock"
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/sink"
+)
+
+// tagDropLogEvery rate-limits the log line listing dropped tag keys.
+const tagDropLogEvery = time.Minute
+
+// tagFilter drops tag keys that aren't on TAG_ALLOWLIST before points are batched, so a
+// misconfigured agent can't blow up series cardinality in the sinks. It applies to
+// source and agent-supplied tags and to the tags of collector samples (such as proc's
+// name); only the keys in tagFilterExempt are always kept. It is owned by the consumer
+// goroutine.
+type tagFilter struct {
+	clock   clock.Clock
+	allowed map[string]bool
+
+	pending map[string]uint64 // dropped keys since the last log line
+	lastLog time.Time
+	dropped atomic.Uint64
+}
+
+// tagFilterExempt are the per-device tags the server adds itself to GPU and disk points.
+var tagFilterExempt = map[string]bool{"gpu": true, "device": true}
+
+// newTagFilter reads a comma-separated list of tag keys; empty means no filtering (nil).
+func newTagFilter(clk clock.Clock, v string) *tagFilter {
+	allowed := make(map[string]bool)
+	for _, key := range strings.Split(v, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			allowed[key] = true
+		}
+	}
+	if len(allowed) == 0 {
+		return nil
+	}
+	f := &tagFilter{clock: clk, allowed: allowed, pending: make(map[string]uint64)}
+	expvar.Publish("tags_dropped", expvar.Func(func() any { return f.dropped.Load() }))
+	return f
+}
+
+// filter returns tags without the keys that aren't allowed. tags is not modified; the
+// unfiltered slice is returned as-is when nothing is dropped.
+func (f *tagFilter) filter(tags []sink.Tag) []sink.Tag {
+	keep := len(tags)
+	for _, t := range tags {
+		if !f.keeps(t.Key) {
+			keep--
+		}
+	}
+	if keep == len(tags) {
+		return tags
+	}
+	out := make([]sink.Tag, 0, keep)
+	for _, t := range tags {
+		if f.keeps(t.Key) {
+			out = append(out, t)
+			continue
+		}
+		f.pending[t.Key]++
+		f.dropped.Add(1)
+	}
+	f.maybeLog()
+	return out
+}
+
+func (f *tagFilter) keeps(key string) bool {
+	return f.allowed[key] || tagFilterExempt[key]
+}
+
+func (f *tagFilter) maybeLog() {
+	now := f.clock.Now()
+	if now.Sub(f.lastLog) < tagDropLogEvery {
+		return
+	}
+	f.lastLog = now
+	keys := make([]string, 0, len(f.pending))
+	for k := range f.pending {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	dropped := make([]any, 0, 2*len(keys))
+	for _, k := range keys {
+		dropped = append(dropped, k, f.pending[k])
+		delete(f.pending, k)
+	}
+	slog.Warn("Dropped tags not on TAG_ALLOWLIST", slog.Group("dropped", dropped...))
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// throttleAlpha weights the newest write latency in the moving average.
+	throttleAlpha = 0.2
+	// maxThrottleDelay caps the pause added before each write at a limit of 1.
+	maxThrottleDelay = 5 * time.Second
+)
+
+// influxThrottle keeps Influx within a latency band by adjusting how many writes may be
+// in flight (AIMD). It tracks an exponential moving average of write latency: above the
+// target the limit is halved, below half the target it grows by one, and in between it
+// holds. Changes wait for a cooldown of one target period so the average can react
+// before the next step. At a limit of 1, writes are also delayed by how far the average
+// is over the target. All methods are safe for concurrent use by the writers.
+type influxThrottle struct {
+	target time.Duration
+	max    int
+
+	mu         sync.Mutex
+	cond       *sync.Cond
+	limit      int
+	active     int
+	avg        time.Duration
+	lastChange time.Time
+	waits      uint64 // acquires that had to wait for the limit
+}
+
+func newInfluxThrottle(target time.Duration, maxInflight int) *influxThrottle {
+	t := &influxThrottle{target: target, max: maxInflight, limit: maxInflight}
+	t.cond = sync.NewCond(&t.mu)
+	return t
+}
+
+// acquire blocks until a write may start, then applies any delay.
+func (t *influxThrottle) acquire() {
+	t.mu.Lock()
+	if t.active >= t.limit {
+		t.waits++
+		for t.active >= t.limit {
+			t.cond.Wait()
+		}
+	}
+	t.active++
+	var delay time.Duration
+	if t.limit == 1 && t.avg > t.target {
+		delay = min(t.avg-t.target, maxThrottleDelay)
+	}
+	t.mu.Unlock()
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+}
+
+// release ends a write started with acquire.
+func (t *influxThrottle) release() {
+	t.mu.Lock()
+	t.active--
+	t.mu.Unlock()
+	t.cond.Signal()
+}
+
+// observe feeds the latency of one write request that got a response and adjusts the
+// limit. Requests that failed outright say nothing about Influx's write latency.
+func (t *influxThrottle) observe(took time.Duration, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.avg == 0 {
+		t.avg = took
+	} else {
+		t.avg += time.Duration(throttleAlpha * float64(took-t.avg))
+	}
+	if now.Sub(t.lastChange) < t.target {
+		return
+	}
+	switch {
+	case t.avg > t.target && t.limit > 1:
+		t.limit = max(t.limit/2, 1)
+		t.lastChange = now
+	case t.avg < t.target/2 && t.limit < t.max:
+		t.limit++
+		t.lastChange = now
+		t.cond.Signal()
+	}
+}
+
+// state is exported on /debug/vars as influx_throttle.
+func (t *influxThrottle) state() map[string]any {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return map[string]any{
+		"limit":          t.limit,
+		"max":            t.max,
+		"avg_latency_ms": t.avg.Milliseconds(),
+		"target_ms":      t.target.Milliseconds(),
+		"waits":          t.waits,
+	}
+}
+
+// logAttrs returns the throttle state as slog key-value pairs for INFLUX_STATS.
+func (t *influxThrottle) logAttrs() []any {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return []any{"throttle_limit", t.limit, "throttle_max", t.max, "avg_latency_ms", t.avg.Milliseconds(), "target_ms", t.target.Milliseconds(), "throttle_waits", t.waits}
+}
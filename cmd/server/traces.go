@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/tracing"
+)
+
+// sampleTracer continues the traces agents start for sampled samples (their traceparent
+// is in the envelope), so a slow E2E sample shows where its time went. Each traced
+// sample gets four spans, children of the agent's collect span next to its publish span:
+//
+//   - receive: from the broker read until the batcher took the message off the queue
+//   - decode: until the point was added to the batch
+//   - batch: until the batch was flushed
+//   - write: from the flush until Influx, or the extra sinks without it, stored or
+//     dropped the batch, including the wait for a writer
+//
+// add and take are called from the batcher goroutine.
+type sampleTracer struct {
+	tracer  trace.Tracer
+	pending []tracedSample // samples in the pending batch
+}
+
+type tracedSample struct {
+	ctx   context.Context // carries the agent's span as the remote parent
+	batch trace.Span
+}
+
+func newSampleTracer() *sampleTracer {
+	return &sampleTracer{tracer: tracing.Tracer("server")}
+}
+
+// add records the receive and decode spans of a sample just added to the batch, and
+// starts its batch span, if the agent traced it.
+func (t *sampleTracer) add(ctx context.Context, in inbound, traceparent string, now time.Time) {
+	ctx, ok := tracing.Extract(ctx, traceparent)
+	if !ok {
+		return
+	}
+	_, recv := t.tracer.Start(ctx, "receive", trace.WithTimestamp(in.recvAt), trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(attribute.String("sentinel.source", in.src.String()), attribute.String("messaging.destination.name", in.channel)))
+	recv.End(trace.WithTimestamp(in.decodeAt))
+	_, dec := t.tracer.Start(ctx, "decode", trace.WithTimestamp(in.decodeAt))
+	dec.End(trace.WithTimestamp(now))
+	_, batch := t.tracer.Start(ctx, "batch", trace.WithTimestamp(now))
+	t.pending = append(t.pending, tracedSample{ctx: ctx, batch: batch})
+}
+
+// take ends the batch spans of the samples in the batch flushed at now, and starts
+// their write spans. points is the size of the batch; with none, nothing is written
+// and take returns nil.
+func (t *sampleTracer) take(now time.Time, points int) writeSpans {
+	if len(t.pending) == 0 {
+		return nil
+	}
+	var spans writeSpans
+	for _, s := range t.pending {
+		s.batch.End(trace.WithTimestamp(now))
+		if points > 0 {
+			_, w := t.tracer.Start(s.ctx, "write", trace.WithTimestamp(now), trace.WithAttributes(attribute.Int("sentinel.batch_points", points)))
+			spans = append(spans, w)
+		}
+	}
+	clear(t.pending)
+	t.pending = t.pending[:0]
+	return spans
+}
+
+// writeSpans are the write spans of one batch's traced samples.
+type writeSpans []trace.Span
+
+// end ends the spans once the batch is stored, or dropped with err. It may be called
+// from any goroutine.
+func (w writeSpans) end(err error) {
+	for _, span := range w {
+		tracing.End(span, err)
+	}
+}
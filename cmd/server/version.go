@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/buildinfo"
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/sink"
+)
+
+const buildInfoMeasurement = "build_info"
+
+// versionHandler serves GET /version with the server's build info as JSON.
+func versionHandler(info buildinfo.Info) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(info)
+	}
+}
+
+// buildInfoPoint is written once at startup so the deployed versions show up next to
+// the data: a build_info point with value 1, tagged with the build details (sorted,
+// empty ones left out).
+func buildInfoPoint(info buildinfo.Info, now time.Time) sink.Point {
+	var tags []sink.Tag
+	for _, t := range []sink.Tag{
+		{Key: "build_time", Value: info.BuildTime},
+		{Key: "commit", Value: info.Commit},
+		{Key: "go_version", Value: info.GoVersion},
+		{Key: "version", Value: info.Version},
+	} {
+		if t.Value != "" {
+			tags = append(tags, t)
+		}
+	}
+	return sink.Point{
+		Measurement: buildInfoMeasurement,
+		TimeNano:    now.UnixNano(),
+		Fields:      []sink.Field{{Key: "value", Value: 1}},
+		Tags:        tags,
+	}
+}
@@ -0,0 +1,345 @@
+// Command top shows the fleet's CPU and memory live in the terminal, like htop across
+// hosts. It subscribes to the metrics channel the way the server does and redraws a
+// gauge and a sparkline of recent samples per host every -refresh.
+//
+//	top [-transport redis -addr localhost:6379] [-channel metrics] [-sort cpu]
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/transport"
+)
+
+// unknownHost labels samples that don't carry a host: fixed binary frames and
+// binary batches.
+const unknownHost = "(no host)"
+
+func main() {
+	var (
+		transportKind = flag.String("transport", transport.KindRedis, "broker to subscribe to: redis, redis-streams, nats or kafka")
+		addr          = flag.String("addr", "", "broker address (default the broker's usual local port)")
+		redisFlags    = transport.AddRedisFlags(flag.CommandLine, "addr")
+		channel       = flag.String("channel", "metrics", "channel the agents publish to")
+		refresh       = flag.Duration("refresh", time.Second, "how often to redraw")
+		history       = flag.Int("history", 30, "samples per sparkline")
+		sortBy        = flag.String("sort", "cpu", "row order: cpu, mem or host")
+		stale         = flag.Duration("stale", 30*time.Second, "dim hosts that have sent nothing for this long")
+	)
+	flag.Parse()
+	if *sortBy != "cpu" && *sortBy != "mem" && *sortBy != "host" {
+		log.Fatalf("-sort must be cpu, mem or host")
+	}
+	if *history < 1 || *refresh <= 0 {
+		log.Fatalf("-history and -refresh must be positive")
+	}
+	if *addr == "" {
+		*addr = transport.DefaultAddr(*transportKind)
+	}
+	host, _ := os.Hostname()
+	// A group of its own, so top sees every sample on Redis Streams and Kafka instead
+	// of taking them away from the servers.
+	brokerOpts := transport.Options{Group: "sentinel-top-" + host}
+	if err := redisFlags.Apply(&brokerOpts); err != nil {
+		log.Fatal(err)
+	}
+	tr, err := transport.New(*transportKind, *addr, brokerOpts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer tr.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	f := &fleet{hosts: make(map[string]*hostState), history: *history}
+	sub := tr.Subscribe(ctx, *channel)
+	defer sub.Close()
+	go f.receive(ctx, sub)
+
+	v := view{
+		fleet:   f,
+		title:   fmt.Sprintf("%s on %s %s", *channel, *transportKind, *addr),
+		sortBy:  *sortBy,
+		stale:   *stale,
+		color:   os.Getenv("NO_COLOR") == "",
+		refresh: *refresh,
+	}
+	os.Stdout.WriteString("\x1b[?1049h\x1b[?25l") // alternate screen, hide cursor
+	defer os.Stdout.WriteString("\x1b[?25h\x1b[?1049l")
+	t := time.NewTicker(*refresh)
+	defer t.Stop()
+	for {
+		os.Stdout.Write(v.render(time.Now()))
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// hostState is one host's recent samples, oldest first.
+type hostState struct {
+	cpu, mem []float64
+	last     time.Time
+}
+
+type fleet struct {
+	mu       sync.Mutex
+	hosts    map[string]*hostState
+	history  int
+	received int // samples since the last render
+	bad      int // undecodable messages, in total
+	err      error
+}
+
+func (f *fleet) receive(ctx context.Context, sub transport.Subscription) {
+	acker, _ := sub.(transport.Acker)
+	var m transport.Metric
+	for {
+		d, err := sub.Receive(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			f.mu.Lock()
+			f.err = err
+			f.mu.Unlock()
+			time.Sleep(time.Second) // Receive reconnects on the next call
+			continue
+		}
+		if acker != nil {
+			_ = acker.Ack(ctx, d)
+		}
+		now := time.Now()
+		payloads := [][]byte{[]byte(d.Payload)}
+		f.mu.Lock()
+		f.err = nil
+		if transport.IsMultiFrame(payloads[0]) {
+			if payloads, err = transport.MultiFramePayloads(payloads[0]); err != nil {
+				f.bad++
+			}
+		}
+		for _, payload := range payloads {
+			f.decode(payload, &m, now)
+		}
+		f.mu.Unlock()
+	}
+}
+
+// decode adds the samples in one payload. Called with f.mu held.
+func (f *fleet) decode(payload []byte, m *transport.Metric, now time.Time) {
+	_, err := transport.DecodeMetric(payload, m)
+	switch {
+	case err == nil:
+		f.add(m.Host, m.CPUUsage, m.MemUsage, now)
+	case errors.Is(err, transport.ErrBatchFrame):
+		recs, n, err := transport.BinaryBatchRecords(payload)
+		if err != nil {
+			f.bad++
+			return
+		}
+		for i := 0; i < n; i++ {
+			*m = transport.Metric{}
+			transport.DecodeBinary(recs[i*transport.BinarySize:(i+1)*transport.BinarySize], m)
+			f.add(m.Host, m.CPUUsage, m.MemUsage, now)
+		}
+	default:
+		f.bad++
+	}
+}
+
+// add records a sample. Called with f.mu held.
+func (f *fleet) add(host string, cpu, mem float64, at time.Time) {
+	if host == "" {
+		host = unknownHost
+	}
+	h := f.hosts[host]
+	if h == nil {
+		h = &hostState{}
+		f.hosts[host] = h
+	}
+	h.cpu = appendCapped(h.cpu, cpu, f.history)
+	h.mem = appendCapped(h.mem, mem, f.history)
+	h.last = at
+	f.received++
+}
+
+func appendCapped(s []float64, v float64, n int) []float64 {
+	if len(s) == n {
+		s = append(s[:0], s[1:]...)
+	}
+	return append(s, v)
+}
+
+// view draws the fleet as a table, one host per row.
+type view struct {
+	fleet   *fleet
+	title   string
+	sortBy  string
+	stale   time.Duration
+	color   bool
+	refresh time.Duration
+	buf     bytes.Buffer
+}
+
+const gaugeWidth = 20
+
+func (v *view) render(now time.Time) []byte {
+	f := v.fleet
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	type row struct {
+		name string
+		*hostState
+	}
+	rows := make([]row, 0, len(f.hosts))
+	nameWidth := len("HOST")
+	for name, h := range f.hosts {
+		rows = append(rows, row{name, h})
+		nameWidth = max(nameWidth, min(len(name), 32))
+	}
+	slices.SortFunc(rows, func(a, b row) int {
+		switch v.sortBy {
+		case "cpu":
+			if c := cmpDesc(last(a.cpu), last(b.cpu)); c != 0 {
+				return c
+			}
+		case "mem":
+			if c := cmpDesc(last(a.mem), last(b.mem)); c != 0 {
+				return c
+			}
+		}
+		return strings.Compare(a.name, b.name)
+	})
+
+	b := &v.buf
+	b.Reset()
+	b.WriteString("\x1b[H")
+	rate := float64(f.received) / v.refresh.Seconds()
+	f.received = 0
+	fmt.Fprintf(b, "sentinel top - %s - %d hosts, %.0f samples/s", v.title, len(rows), rate)
+	if f.bad > 0 {
+		fmt.Fprintf(b, ", %d undecodable", f.bad)
+	}
+	fmt.Fprintf(b, " - %s\x1b[K\n", now.Format("15:04:05"))
+	if f.err != nil {
+		v.style(b, "31", "receive: "+f.err.Error())
+		b.WriteString("\x1b[K")
+	}
+	b.WriteString("\x1b[K\n")
+	fmt.Fprintf(b, "%-*s  %-*s  %-*s  %-*s  %-*s  %5s\x1b[K\n",
+		nameWidth, "HOST", gaugeWidth+7, "CPU", f.history, "", gaugeWidth+7, "MEM", f.history, "", "AGE")
+	for _, r := range rows {
+		age := now.Sub(r.last)
+		name := r.name
+		if len(name) > nameWidth {
+			name = name[:nameWidth-1] + "~"
+		}
+		if age >= v.stale {
+			b.WriteString(v.esc("2"))
+		}
+		fmt.Fprintf(b, "%-*s  ", nameWidth, name)
+		v.gauge(b, last(r.cpu), age < v.stale)
+		b.WriteString("  ")
+		sparkline(b, r.cpu, f.history)
+		b.WriteString("  ")
+		v.gauge(b, last(r.mem), age < v.stale)
+		b.WriteString("  ")
+		sparkline(b, r.mem, f.history)
+		fmt.Fprintf(b, "  %5s%s\x1b[K\n", shortAge(age), v.esc("0"))
+	}
+	if len(rows) == 0 {
+		b.WriteString("waiting for samples... (Ctrl-C quits)\x1b[K\n")
+	}
+	b.WriteString("\x1b[J")
+	return b.Bytes()
+}
+
+// gauge draws a bar and percentage, colored by load while the host is live.
+func (v *view) gauge(b *bytes.Buffer, pct float64, live bool) {
+	filled := int(min(max(pct, 0), 100) / 100 * gaugeWidth)
+	code := "32"
+	switch {
+	case pct >= 85:
+		code = "31"
+	case pct >= 60:
+		code = "33"
+	}
+	if !live {
+		code = "" // keep the row's dim
+	}
+	v.style(b, code, strings.Repeat("█", filled))
+	b.WriteString(strings.Repeat("░", gaugeWidth-filled))
+	fmt.Fprintf(b, " %5.1f%%", pct)
+}
+
+var sparks = []rune("▁▂▃▄▅▆▇█")
+
+// sparkline draws values (0-100) right-aligned in width cells.
+func sparkline(b *bytes.Buffer, values []float64, width int) {
+	b.WriteString(strings.Repeat(" ", width-len(values)))
+	for _, x := range values {
+		i := int(min(max(x, 0), 100) / 100 * float64(len(sparks)-1))
+		b.WriteRune(sparks[i])
+	}
+}
+
+func (v *view) esc(code string) string {
+	if !v.color {
+		return ""
+	}
+	return "\x1b[" + code + "m"
+}
+
+// style writes s in the given SGR code, then resets all attributes.
+func (v *view) style(b *bytes.Buffer, code, s string) {
+	if code == "" || !v.color {
+		b.WriteString(s)
+		return
+	}
+	b.WriteString(v.esc(code))
+	b.WriteString(s)
+	b.WriteString(v.esc("0"))
+}
+
+func shortAge(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%ds", int(d.Seconds()))
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	default:
+		return fmt.Sprintf("%dh", int(d.Hours()))
+	}
+}
+
+func last(s []float64) float64 {
+	if len(s) == 0 {
+		return 0
+	}
+	return s[len(s)-1]
+}
+
+func cmpDesc(a, b float64) int {
+	switch {
+	case a > b:
+		return -1
+	case a < b:
+		return 1
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,57 @@
+// Package buildinfo reports what a binary was built from, so a fleet can be audited
+// for what is actually deployed. Version, Commit and BuildTime are set at link time:
+//
+//	go build -ldflags "-X github.com/thomas-sabu-cs/sentinel-stream/internal/buildinfo.Version=v1.2.3 \
+//	  -X github.com/thomas-sabu-cs/sentinel-stream/internal/buildinfo.BuildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// Without ldflags the commit and time are taken from the VCS stamp Go embeds.
+package buildinfo
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// Set with -ldflags -X.
+var (
+	Version   = "dev"
+	Commit    = ""
+	BuildTime = ""
+)
+
+// Info describes the running binary.
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildTime string `json:"build_time,omitempty"`
+	GoVersion string `json:"go_version"`
+}
+
+// Get returns the build info, filling Commit and BuildTime from the embedded VCS
+// stamp when they weren't set at link time.
+func Get() Info {
+	info := Info{Version: Version, Commit: Commit, BuildTime: BuildTime, GoVersion: runtime.Version()}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range bi.Settings {
+			switch {
+			case s.Key == "vcs.revision" && info.Commit == "":
+				info.Commit = s.Value
+			case s.Key == "vcs.time" && info.BuildTime == "":
+				info.BuildTime = s.Value
+			}
+		}
+	}
+	return info
+}
+
+func (i Info) String() string {
+	s := fmt.Sprintf("version=%s go=%s", i.Version, i.GoVersion)
+	if i.Commit != "" {
+		s += " commit=" + i.Commit
+	}
+	if i.BuildTime != "" {
+		s += " built=" + i.BuildTime
+	}
+	return s
+}
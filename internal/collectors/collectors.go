@@ -0,0 +1,160 @@
+// Package collectors holds the agent's collectors and the registry they are enabled
+// from. A collector registers itself by name in an init function, so adding one is a
+// new file here (or a blank import of a package that calls Register) rather than an
+// edit to the agent:
+//
+//	func init() { collectors.Register("uptime", newUptimeCollector) }
+//
+// The agent enables collectors with -collectors (or collectors: in its config file). A
+// collector with settings of its own defines them as agent flags with RegisterFlags.
+package collectors
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/transport"
+)
+
+// Sample is one reading. Samples in the measurements below are sent in the Metric
+// fields that predate Samples, so servers from before them keep working; every other
+// sample is sent in Metric.Samples and written as its own point.
+type Sample = transport.Sample
+
+// Measurements with dedicated Metric fields (see Fill).
+const (
+	// System samples carry the cpu and mem fields (CPUUsage and MemUsage).
+	System = "system_stats"
+	// GPU samples are tagged gpu=<index> (Metric.GPUs).
+	GPU = "gpu_stats"
+	// DiskIO samples are tagged device=<name> (Metric.Disks).
+	DiskIO = "diskio_stats"
+)
+
+// Collector produces samples each time the agent ticks. Collect is only ever called
+// from one goroutine, so collectors can keep state (e.g. counters for rates) between
+// calls without locking.
+type Collector interface {
+	Name() string
+	Collect(ctx context.Context) ([]Sample, error)
+}
+
+// Factory creates a collector when the agent enables it. It returns ErrUnavailable if
+// the collector can't run on this host.
+type Factory func() (Collector, error)
+
+// ErrUnavailable is returned by a Factory whose collector can't run here, e.g. the GPU
+// collector without an NVIDIA driver. The agent skips such collectors unless required.
+var ErrUnavailable = errors.New("collector unavailable on this host")
+
+var (
+	mu        sync.Mutex
+	registry  = make(map[string]Factory)
+	flagFuncs []func(fs *flag.FlagSet)
+)
+
+// Register makes a collector available under name. It panics if name is taken.
+func Register(name string, f Factory) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, dup := registry[name]; dup {
+		panic("collectors: Register called twice for " + name)
+	}
+	registry[name] = f
+}
+
+// RegisterFlags adds fn, which defines a collector's settings as flags, to the ones
+// AddFlags defines. Call it from the collector's init, next to Register, and prefix the
+// flag names with the collector's name. The Factory runs after flags are parsed, so it
+// sees their values.
+func RegisterFlags(fn func(fs *flag.FlagSet)) {
+	mu.Lock()
+	defer mu.Unlock()
+	flagFuncs = append(flagFuncs, fn)
+}
+
+// AddFlags defines every collector's flags on fs, whether or not it is enabled, so a
+// config file can hold settings for collectors it doesn't turn on.
+func AddFlags(fs *flag.FlagSet) {
+	mu.Lock()
+	defer mu.Unlock()
+	for _, fn := range flagFuncs {
+		fn(fs)
+	}
+}
+
+// Names returns the registered collectors, sorted.
+func Names() []string {
+	mu.Lock()
+	defer mu.Unlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New creates the collector registered as name.
+func New(name string) (Collector, error) {
+	mu.Lock()
+	f, ok := registry[name]
+	mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown collector %q (have %v)", name, Names())
+	}
+	c, err := f()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return c, nil
+}
+
+// Settings returns c's settings for the agent's startup log and /config, if it reports
+// any through a Settings() map[string]string method.
+func Settings(c Collector) map[string]string {
+	if s, ok := c.(interface{ Settings() map[string]string }); ok {
+		return s.Settings()
+	}
+	return nil
+}
+
+// Fill adds samples to m: System, GPU and DiskIO samples go into the fields the wire
+// formats have always had for them, everything else into m.Samples.
+func Fill(m *transport.Metric, samples []Sample) {
+	for _, s := range samples {
+		switch s.Measurement {
+		case System:
+			if v, ok := s.Fields["cpu"]; ok {
+				m.CPUUsage = v
+			}
+			if v, ok := s.Fields["mem"]; ok {
+				m.MemUsage = v
+			}
+		case GPU:
+			index, _ := strconv.Atoi(s.Tags["gpu"])
+			m.GPUs = append(m.GPUs, transport.GPU{
+				Index:       index,
+				UtilPercent: s.Fields["util_percent"],
+				MemUsedMB:   s.Fields["mem_used_mb"],
+				MemTotalMB:  s.Fields["mem_total_mb"],
+			})
+		case DiskIO:
+			m.Disks = append(m.Disks, transport.DiskIO{
+				Device:           s.Tags["device"],
+				ReadBytesPerSec:  s.Fields["read_bytes_per_sec"],
+				WriteBytesPerSec: s.Fields["write_bytes_per_sec"],
+				ReadsPerSec:      s.Fields["reads_per_sec"],
+				WritesPerSec:     s.Fields["writes_per_sec"],
+				BusyPercent:      s.Fields["busy_percent"],
+			})
+		default:
+			m.Samples = append(m.Samples, s)
+		}
+	}
+}
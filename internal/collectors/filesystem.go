@@ -0,0 +1,58 @@
+package collectors
+
+import (
+	"context"
+	"sort"
+
+	"github.com/shirou/gopsutil/v3/disk"
+)
+
+// Filesystem samples are tagged mountpoint, device and fstype.
+const Filesystem = "filesystem_stats"
+
+// skipFSTypes are filesystems that are always full or have no meaningful usage, so
+// reporting them would only add series (snap packages mount one squashfs each).
+var skipFSTypes = map[string]bool{"squashfs": true, "iso9660": true}
+
+func init() {
+	Register("filesystem", func() (Collector, error) { return filesystemCollector{}, nil })
+}
+
+// filesystemCollector reports space and inode usage for each mounted physical
+// filesystem (disk.Partitions with all=false, so no proc, sysfs, tmpfs and the like).
+type filesystemCollector struct{}
+
+func (filesystemCollector) Name() string { return "filesystem" }
+
+func (filesystemCollector) Collect(ctx context.Context) ([]Sample, error) {
+	parts, err := disk.PartitionsWithContext(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	var samples []Sample
+	for _, p := range parts {
+		if skipFSTypes[p.Fstype] {
+			continue
+		}
+		u, err := disk.UsageWithContext(ctx, p.Mountpoint)
+		if err != nil || u.Total == 0 {
+			continue // unmounted since Partitions, or not readable by the agent
+		}
+		fields := map[string]float64{
+			"used_percent": u.UsedPercent,
+			"used_bytes":   float64(u.Used),
+			"free_bytes":   float64(u.Free),
+			"total_bytes":  float64(u.Total),
+		}
+		if u.InodesTotal > 0 {
+			fields["inodes_used_percent"] = u.InodesUsedPercent
+		}
+		samples = append(samples, Sample{
+			Measurement: Filesystem,
+			Tags:        map[string]string{"mountpoint": p.Mountpoint, "device": p.Device, "fstype": p.Fstype},
+			Fields:      fields,
+		})
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i].Tags["mountpoint"] < samples[j].Tags["mountpoint"] })
+	return samples, nil
+}
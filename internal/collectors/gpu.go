@@ -0,0 +1,26 @@
+package collectors
+
+import "strconv"
+
+// GPU stats come from NVML. The default build queries it through nvidia-smi, which ships
+// with the NVIDIA driver, so the agent stays a static, cgo-free binary (gpu_smi.go).
+// Building with -tags nvml links NVML directly instead (gpu_nvml.go), which saves a
+// process per interval but needs cgo. Either way, without a driver the collector is
+// unavailable.
+
+func init() {
+	Register("gpu", newGPUCollector)
+}
+
+// gpuSample is the sample for one device, memory in MB.
+func gpuSample(index int, util, memUsed, memTotal float64) Sample {
+	return Sample{
+		Measurement: GPU,
+		Tags:        map[string]string{"gpu": strconv.Itoa(index)},
+		Fields: map[string]float64{
+			"util_percent": util,
+			"mem_used_mb":  memUsed,
+			"mem_total_mb": memTotal,
+		},
+	}
+}
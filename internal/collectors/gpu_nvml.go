@@ -0,0 +1,56 @@
+//go:build nvml
+
+package collectors
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+)
+
+// gpuCollector reports utilization and memory per GPU, one GPU sample per device. NVML
+// stays initialized for the life of the agent.
+type gpuCollector struct {
+	driver string
+}
+
+func newGPUCollector() (Collector, error) {
+	// Init loads libnvidia-ml at run time, so a host without the driver fails here
+	// rather than at startup.
+	if ret := nvml.Init(); ret != nvml.SUCCESS {
+		return nil, fmt.Errorf("%w: NVML: %s", ErrUnavailable, nvml.ErrorString(ret))
+	}
+	driver, _ := nvml.SystemGetDriverVersion()
+	return gpuCollector{driver: driver}, nil
+}
+
+func (gpuCollector) Name() string { return "gpu" }
+
+func (c gpuCollector) Settings() map[string]string {
+	return map[string]string{"nvml": "linked", "driver": c.driver}
+}
+
+func (gpuCollector) Collect(context.Context) ([]Sample, error) {
+	count, ret := nvml.DeviceGetCount()
+	if ret != nvml.SUCCESS {
+		return nil, fmt.Errorf("NVML device count: %s", nvml.ErrorString(ret))
+	}
+	samples := make([]Sample, 0, count)
+	for i := 0; i < count; i++ {
+		dev, ret := nvml.DeviceGetHandleByIndex(i)
+		if ret != nvml.SUCCESS {
+			return nil, fmt.Errorf("NVML device %d: %s", i, nvml.ErrorString(ret))
+		}
+		// Unsupported values are left zero, as nvidia-smi's "[N/A]" is.
+		var util, used, total float64
+		if u, ret := dev.GetUtilizationRates(); ret == nvml.SUCCESS {
+			util = float64(u.Gpu)
+		}
+		if m, ret := dev.GetMemoryInfo(); ret == nvml.SUCCESS {
+			used, total = float64(m.Used)/(1<<20), float64(m.Total)/(1<<20)
+		}
+		samples = append(samples, gpuSample(i, util, used, total))
+	}
+	return samples, nil
+}
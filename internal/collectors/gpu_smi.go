@@ -0,0 +1,75 @@
+//go:build !nvml
+
+package collectors
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const nvidiaSMI = "nvidia-smi"
+
+// gpuQueryTimeout bounds one nvidia-smi run, which can hang on a wedged driver.
+const gpuQueryTimeout = 2 * time.Second
+
+// gpuCollector reports utilization and memory per GPU, one GPU sample per device.
+type gpuCollector struct {
+	path string
+}
+
+func newGPUCollector() (Collector, error) {
+	path, err := exec.LookPath(nvidiaSMI)
+	if err != nil {
+		return nil, fmt.Errorf("%w: no NVIDIA driver found", ErrUnavailable)
+	}
+	return gpuCollector{path: path}, nil
+}
+
+func (gpuCollector) Name() string { return "gpu" }
+
+func (c gpuCollector) Settings() map[string]string {
+	return map[string]string{"nvidia-smi": c.path}
+}
+
+func (c gpuCollector) Collect(ctx context.Context) ([]Sample, error) {
+	ctx, cancel := context.WithTimeout(ctx, gpuQueryTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, c.path,
+		"--query-gpu=index,utilization.gpu,memory.used,memory.total",
+		"--format=csv,noheader,nounits").Output()
+	if err != nil {
+		return nil, err
+	}
+	return parseNvidiaSMI(out)
+}
+
+// parseNvidiaSMI turns nvidia-smi's CSV rows of index, utilization, used and total
+// memory into samples.
+func parseNvidiaSMI(out []byte) ([]Sample, error) {
+	records, err := csv.NewReader(bytes.NewReader(out)).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	samples := make([]Sample, 0, len(records))
+	for _, rec := range records {
+		if len(rec) != 4 {
+			return nil, fmt.Errorf("unexpected nvidia-smi row %q", rec)
+		}
+		var vals [4]float64
+		for i, field := range rec {
+			// Unsupported values are reported as "[N/A]"; leave them zero.
+			v, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+			if err == nil {
+				vals[i] = v
+			}
+		}
+		samples = append(samples, gpuSample(int(vals[0]), vals[1], vals[2], vals[3]))
+	}
+	return samples, nil
+}
@@ -0,0 +1,128 @@
+package collectors
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/net"
+)
+
+// Net samples are tagged interface.
+const Net = "net_stats"
+
+// defaultNetExclude skips loopback and the virtual interfaces container runtimes and
+// hypervisors create per container or VM, which would otherwise add series by the
+// hundred on a busy host.
+const defaultNetExclude = "lo,docker*,veth*,br-*,virbr*,cni*,flannel*,cali*,vxlan*,tun*,tap*"
+
+var netFlags struct {
+	include, exclude string
+}
+
+func init() {
+	Register("net", newNetCollector)
+	RegisterFlags(func(fs *flag.FlagSet) {
+		fs.StringVar(&netFlags.include, "net-include", "", "comma-separated interface name globs the net collector reports, e.g. eth*,ens*; empty means all not excluded")
+		fs.StringVar(&netFlags.exclude, "net-exclude", defaultNetExclude, "comma-separated interface name globs the net collector skips, even if included")
+	})
+}
+
+// netCollector reports, for each network interface that passes the include/exclude
+// globs, bytes and packets in and out, errors and drops, all per second since the
+// previous call. The first call only records a baseline.
+type netCollector struct {
+	include, exclude []string
+	prev             map[string]net.IOCountersStat
+	prevAt           time.Time
+}
+
+func newNetCollector() (Collector, error) {
+	c := &netCollector{include: splitGlobs(netFlags.include), exclude: splitGlobs(netFlags.exclude)}
+	for _, g := range append(c.include, c.exclude...) {
+		if _, err := path.Match(g, ""); err != nil {
+			return nil, fmt.Errorf("bad interface glob %q: %w", g, err)
+		}
+	}
+	return c, nil
+}
+
+func splitGlobs(v string) []string {
+	var out []string
+	for _, g := range strings.Split(v, ",") {
+		if g = strings.TrimSpace(g); g != "" {
+			out = append(out, g)
+		}
+	}
+	return out
+}
+
+func matchAny(globs []string, name string) bool {
+	for _, g := range globs {
+		if ok, _ := path.Match(g, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// reports says whether the interface called name passes the filter.
+func (c *netCollector) reports(name string) bool {
+	return (len(c.include) == 0 || matchAny(c.include, name)) && !matchAny(c.exclude, name)
+}
+
+func (*netCollector) Name() string { return "net" }
+
+func (c *netCollector) Settings() map[string]string {
+	return map[string]string{"include": strings.Join(c.include, ","), "exclude": strings.Join(c.exclude, ",")}
+}
+
+func (c *netCollector) Collect(ctx context.Context) ([]Sample, error) {
+	stats, err := net.IOCountersWithContext(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+	counters := make(map[string]net.IOCountersStat, len(stats))
+	for _, s := range stats {
+		if c.reports(s.Name) {
+			counters[s.Name] = s
+		}
+	}
+	now := time.Now()
+	prev, secs := c.prev, now.Sub(c.prevAt).Seconds()
+	c.prev, c.prevAt = counters, now
+	if prev == nil || secs <= 0 {
+		return nil, nil
+	}
+	var samples []Sample
+	for name, cur := range counters {
+		p, ok := prev[name]
+		// An interface that appeared or whose counters reset has no usable delta.
+		if !ok || cur.BytesRecv < p.BytesRecv || cur.BytesSent < p.BytesSent ||
+			cur.PacketsRecv < p.PacketsRecv || cur.PacketsSent < p.PacketsSent ||
+			cur.Errin < p.Errin || cur.Errout < p.Errout || cur.Dropin < p.Dropin || cur.Dropout < p.Dropout {
+			continue
+		}
+		rate := func(cur, prev uint64) float64 { return float64(cur-prev) / secs }
+		samples = append(samples, Sample{
+			Measurement: Net,
+			Tags:        map[string]string{"interface": name},
+			Fields: map[string]float64{
+				"bytes_recv_per_sec":   rate(cur.BytesRecv, p.BytesRecv),
+				"bytes_sent_per_sec":   rate(cur.BytesSent, p.BytesSent),
+				"packets_recv_per_sec": rate(cur.PacketsRecv, p.PacketsRecv),
+				"packets_sent_per_sec": rate(cur.PacketsSent, p.PacketsSent),
+				"errors_in_per_sec":    rate(cur.Errin, p.Errin),
+				"errors_out_per_sec":   rate(cur.Errout, p.Errout),
+				"drops_in_per_sec":     rate(cur.Dropin, p.Dropin),
+				"drops_out_per_sec":    rate(cur.Dropout, p.Dropout),
+			},
+		})
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i].Tags["interface"] < samples[j].Tags["interface"] })
+	return samples, nil
+}
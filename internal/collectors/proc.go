@@ -0,0 +1,136 @@
+package collectors
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/mem"
+	"github.com/shirou/gopsutil/v3/process"
+)
+
+// Proc samples are tagged by (cpu or mem), rank (1 is the top process) and name.
+// cpu_percent is of one core, like top, so a busy multi-threaded process can exceed 100.
+const Proc = "process_stats"
+
+var procFlags struct {
+	top int
+}
+
+func init() {
+	Register("proc", newProcCollector)
+	RegisterFlags(func(fs *flag.FlagSet) {
+		fs.IntVar(&procFlags.top, "proc-top", 5, "how many processes the proc collector reports by CPU and by memory")
+	})
+}
+
+// procCollector reports the top processes by CPU use since the previous call and by
+// resident memory, so a spike can be traced to a process without logging in to the
+// host. The pid is a field rather than a tag, so restarts don't add series; only new
+// names reaching the top do.
+type procCollector struct {
+	top    int
+	prev   map[int32]float64 // pid -> user+system CPU seconds at prevAt
+	prevAt time.Time
+}
+
+func newProcCollector() (Collector, error) {
+	if procFlags.top <= 0 {
+		return nil, fmt.Errorf("-proc-top must be positive")
+	}
+	return &procCollector{top: procFlags.top}, nil
+}
+
+func (*procCollector) Name() string { return "proc" }
+
+func (c *procCollector) Settings() map[string]string {
+	return map[string]string{"top": strconv.Itoa(c.top)}
+}
+
+// procStat is one process's readings from a single pass.
+type procStat struct {
+	pid    int32
+	name   string
+	cpu    float64 // percent of one core since the previous call; -1 if the pid is new
+	rss    uint64
+	memPct float64
+}
+
+func (c *procCollector) Collect(ctx context.Context) ([]Sample, error) {
+	procs, err := process.ProcessesWithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	vm, err := mem.VirtualMemoryWithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	prev, secs := c.prev, now.Sub(c.prevAt).Seconds()
+	cpuTimes := make(map[int32]float64, len(procs))
+	stats := make([]procStat, 0, len(procs))
+	for _, p := range procs {
+		// Processes can exit mid-pass; skip any we can no longer read.
+		times, err := p.TimesWithContext(ctx)
+		if err != nil {
+			continue
+		}
+		mi, err := p.MemoryInfoWithContext(ctx)
+		if err != nil {
+			continue
+		}
+		name, _ := p.NameWithContext(ctx)
+		total := times.User + times.System
+		cpuTimes[p.Pid] = total
+		st := procStat{pid: p.Pid, name: name, cpu: -1, rss: mi.RSS}
+		if vm.Total > 0 {
+			st.memPct = float64(mi.RSS) / float64(vm.Total) * 100
+		}
+		if before, ok := prev[p.Pid]; ok && total >= before && secs > 0 {
+			st.cpu = (total - before) / secs * 100
+		}
+		stats = append(stats, st)
+	}
+	c.prev, c.prevAt = cpuTimes, now
+
+	// The first call only has a baseline for CPU, so it reports memory alone.
+	var samples []Sample
+	if prev != nil && secs > 0 {
+		sort.Slice(stats, func(i, j int) bool { return stats[i].cpu > stats[j].cpu })
+		samples = c.appendTop(samples, "cpu", stats, func(st procStat) bool { return st.cpu >= 0 })
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].rss > stats[j].rss })
+	samples = c.appendTop(samples, "mem", stats, func(procStat) bool { return true })
+	return samples, nil
+}
+
+// appendTop appends a sample for each of the first c.top entries of sorted that pass ok.
+func (c *procCollector) appendTop(samples []Sample, by string, sorted []procStat, ok func(procStat) bool) []Sample {
+	rank := 0
+	for _, st := range sorted {
+		if rank == c.top {
+			break
+		}
+		if !ok(st) {
+			continue
+		}
+		rank++
+		fields := map[string]float64{
+			"pid":         float64(st.pid),
+			"mem_percent": st.memPct,
+			"rss_bytes":   float64(st.rss),
+		}
+		if st.cpu >= 0 {
+			fields["cpu_percent"] = st.cpu
+		}
+		samples = append(samples, Sample{
+			Measurement: Proc,
+			Tags:        map[string]string{"by": by, "rank": strconv.Itoa(rank), "name": st.name},
+			Fields:      fields,
+		})
+	}
+	return samples
+}
@@ -0,0 +1,43 @@
+package collectors
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/shirou/gopsutil/v3/cpu"
+	"github.com/shirou/gopsutil/v3/mem"
+)
+
+func init() {
+	Register("cpu", func() (Collector, error) { return cpuCollector{}, nil })
+	Register("mem", func() (Collector, error) { return memCollector{}, nil })
+}
+
+// cpuCollector reports overall CPU utilization since the previous call.
+type cpuCollector struct{}
+
+func (cpuCollector) Name() string { return "cpu" }
+
+func (cpuCollector) Collect(ctx context.Context) ([]Sample, error) {
+	cpuPercent, err := cpu.PercentWithContext(ctx, 0, false)
+	if err != nil {
+		return nil, err
+	}
+	if len(cpuPercent) == 0 {
+		return nil, fmt.Errorf("no cpu stats returned")
+	}
+	return []Sample{{Measurement: System, Fields: map[string]float64{"cpu": cpuPercent[0]}}}, nil
+}
+
+// memCollector reports the percentage of memory in use.
+type memCollector struct{}
+
+func (memCollector) Name() string { return "mem" }
+
+func (memCollector) Collect(ctx context.Context) ([]Sample, error) {
+	vMem, err := mem.VirtualMemoryWithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return []Sample{{Measurement: System, Fields: map[string]float64{"mem": vMem.UsedPercent}}}, nil
+}
@@ -0,0 +1,164 @@
+// Package config loads the -config file shared by the agent, server and bench. The file
+// is YAML, or TOML if its name ends in .toml. Top-level transport, addr and channel apply
+// to all three binaries; the agent, server and bench sections hold anything else, keyed
+// by the binary's own flag names or, for settings the server reads from the environment,
+// env var names:
+//
+//	transport: redis
+//	addr: localhost:6379
+//	channel: metrics
+//	agent:
+//	  interval: 5s
+//	  format: binary
+//	server:
+//	  INFLUX_URL: http://localhost:8086
+//	  BATCH_SIZE: 500
+//	bench:
+//	  workers: 64
+//
+// The file only supplies defaults: a flag given on the command line, or an env var that
+// is already set, wins over it.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// File is a parsed config file. Section values are kept as strings, the form flags and
+// env vars are parsed from, so "5s", 500 and true all work.
+type File struct {
+	Transport string
+	Addr      string
+	Channel   string
+	Agent     map[string]string
+	Server    map[string]string
+	Bench     map[string]string
+}
+
+// raw is the decoded file before values are turned into strings.
+type raw struct {
+	Transport string         `yaml:"transport" toml:"transport"`
+	Addr      string         `yaml:"addr" toml:"addr"`
+	Channel   string         `yaml:"channel" toml:"channel"`
+	Agent     map[string]any `yaml:"agent" toml:"agent"`
+	Server    map[string]any `yaml:"server" toml:"server"`
+	Bench     map[string]any `yaml:"bench" toml:"bench"`
+}
+
+// Load reads and parses the config file at path. Unknown top-level keys are an error.
+func Load(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var r raw
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		md, err := toml.Decode(string(data), &r)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return nil, fmt.Errorf("%s: unknown key %q", path, undecoded[0].String())
+		}
+	} else {
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(&r); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	f := &File{Transport: r.Transport, Addr: r.Addr, Channel: r.Channel}
+	for _, s := range []struct {
+		name string
+		in   map[string]any
+		out  *map[string]string
+	}{{"agent", r.Agent, &f.Agent}, {"server", r.Server, &f.Server}, {"bench", r.Bench, &f.Bench}} {
+		if *s.out, err = stringify(s.in); err != nil {
+			return nil, fmt.Errorf("%s: %s.%w", path, s.name, err)
+		}
+	}
+	return f, nil
+}
+
+// stringify turns a section's scalar values into strings.
+func stringify(in map[string]any) (map[string]string, error) {
+	out := make(map[string]string, len(in))
+	for k, v := range in {
+		switch v := v.(type) {
+		case map[string]any, []any:
+			return nil, fmt.Errorf("%s: must be a single value", k)
+		case nil:
+			out[k] = ""
+		default:
+			out[k] = fmt.Sprint(v)
+		}
+	}
+	return out, nil
+}
+
+// Settings merges the shared keys into section under the names one binary uses for
+// them (its transport, address and channel flag or env var), with section's own
+// entries taking precedence.
+func (f *File) Settings(section map[string]string, transportKey, addrKey, channelKey string) map[string]string {
+	out := make(map[string]string, len(section)+3)
+	for k, v := range map[string]string{transportKey: f.Transport, addrKey: f.Addr, channelKey: f.Channel} {
+		if v != "" {
+			out[k] = v
+		}
+	}
+	for k, v := range section {
+		out[k] = v
+	}
+	return out
+}
+
+// envName matches keys that are taken as env vars rather than flags.
+var envName = regexp.MustCompile(`^[A-Z][A-Z0-9_]*$`)
+
+// Apply sets each setting that names a flag in fs, unless that flag was given on the
+// command line, and each upper-case setting that isn't a flag as an env var, unless it
+// is already set. It must be called after fs.Parse. Any other key is an error, so a
+// misspelt flag name doesn't go unnoticed.
+func Apply(fs *flag.FlagSet, settings map[string]string) error {
+	given := make(map[string]bool)
+	fs.Visit(func(fl *flag.Flag) { given[fl.Name] = true })
+	keys := make([]string, 0, len(settings))
+	for k := range settings {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := settings[k]
+		switch {
+		case fs.Lookup(k) != nil:
+			if given[k] {
+				continue
+			}
+			if err := fs.Set(k, v); err != nil {
+				return fmt.Errorf("config %s: %w", k, err)
+			}
+		case envName.MatchString(k):
+			if _, set := os.LookupEnv(k); set {
+				continue
+			}
+			if err := os.Setenv(k, v); err != nil {
+				return fmt.Errorf("config %s: %w", k, err)
+			}
+		default:
+			return fmt.Errorf("config: unknown setting %q", k)
+		}
+	}
+	return nil
+}
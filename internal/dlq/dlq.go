@@ -0,0 +1,144 @@
+// Package dlq is the dead-letter queue: a capped Redis list of the payloads the server
+// could not decode and the batches InfluxDB would not accept, with the error that
+// stopped them. The server pushes entries; cmd/dlq lists them and re-injects them once
+// the cause is fixed.
+package dlq
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/transport"
+)
+
+// DefaultKey is the list cmd/dlq reads when -key isn't given.
+const DefaultKey = "metrics:dlq"
+
+// DefaultMaxLen caps the list so a broken producer can't fill Redis.
+const DefaultMaxLen = 10000
+
+// Entry reasons.
+const (
+	// ReasonDecode entries hold a message the server could not decode, as received.
+	ReasonDecode = "decode"
+	// ReasonWrite entries hold a batch InfluxDB did not accept, as line protocol.
+	ReasonWrite = "write"
+)
+
+// Entry is one dead letter.
+type Entry struct {
+	Time   time.Time `json:"time"`
+	Reason string    `json:"reason"`
+	Error  string    `json:"error"`
+
+	// ReasonDecode: the channel and broker instance the message came from, and the
+	// message itself (base64 in JSON, since it may be binary).
+	Channel string `json:"channel,omitempty"`
+	Source  string `json:"source,omitempty"`
+	Payload []byte `json:"payload,omitempty"`
+
+	// ReasonWrite: the batch and how many points it held.
+	Lines  string `json:"lines,omitempty"`
+	Points int    `json:"points,omitempty"`
+}
+
+// ErrEmpty is returned by Head when the queue holds no entries.
+var ErrEmpty = errors.New("dead-letter queue is empty")
+
+// Queue is a dead-letter list in Redis. Entries are pushed on the left and taken from
+// the right, so Head returns the oldest and List the newest first.
+type Queue struct {
+	client redis.UniversalClient
+	key    string
+	maxLen int64
+}
+
+// New opens the list key on the Redis server at addr, or through Sentinel or on a
+// Cluster as opts say (see transport.NewRedisUniversalClient). Pushes trim it to maxLen
+// entries, dropping the oldest; maxLen <= 0 means DefaultMaxLen.
+func New(addr, key string, maxLen int, opts transport.Options) *Queue {
+	if maxLen <= 0 {
+		maxLen = DefaultMaxLen
+	}
+	return &Queue{client: transport.NewRedisUniversalClient(addr, opts), key: key, maxLen: int64(maxLen)}
+}
+
+// Key returns the Redis list the queue lives in.
+func (q *Queue) Key() string { return q.key }
+
+// Push adds entries in one round-trip and trims the list to its cap.
+func (q *Queue) Push(ctx context.Context, entries ...Entry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	values := make([]any, len(entries))
+	for i, e := range entries {
+		b, err := jsoniter.Marshal(e)
+		if err != nil {
+			return err
+		}
+		values[i] = b
+	}
+	pipe := q.client.TxPipeline()
+	pipe.LPush(ctx, q.key, values...)
+	pipe.LTrim(ctx, q.key, 0, q.maxLen-1)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// Len returns the number of entries.
+func (q *Queue) Len(ctx context.Context) (int64, error) {
+	return q.client.LLen(ctx, q.key).Result()
+}
+
+// List returns up to n entries, newest first, without removing them. n <= 0 means all.
+func (q *Queue) List(ctx context.Context, n int) ([]Entry, error) {
+	raw, err := q.client.LRange(ctx, q.key, 0, int64(n)-1).Result()
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]Entry, len(raw))
+	for i, r := range raw {
+		if err := jsoniter.UnmarshalFromString(r, &entries[i]); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// Head is the oldest entry, read but not yet removed from the queue.
+type Head struct {
+	Entry
+	raw string // as stored, for Remove
+}
+
+// Head returns the oldest entry without removing it, or ErrEmpty. Take it off with
+// Remove once it has been re-injected, so a crash in between leaves it in the queue to
+// be replayed again rather than losing it.
+func (q *Queue) Head(ctx context.Context) (Head, error) {
+	raw, err := q.client.LIndex(ctx, q.key, -1).Result()
+	if errors.Is(err, redis.Nil) {
+		return Head{}, ErrEmpty
+	}
+	if err != nil {
+		return Head{}, err
+	}
+	h := Head{raw: raw}
+	err = jsoniter.UnmarshalFromString(raw, &h.Entry)
+	return h, err
+}
+
+// Remove takes h off the queue. It is a no-op if h is gone already, e.g. trimmed by a
+// push while it was being re-injected.
+func (q *Queue) Remove(ctx context.Context, h Head) error {
+	return q.client.LRem(ctx, q.key, -1, h.raw).Err()
+}
+
+// Close closes the Redis connection.
+func (q *Queue) Close() error {
+	return q.client.Close()
+}
@@ -0,0 +1,52 @@
+package dlq
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/transport"
+)
+
+func TestHeadLeavesEntryUntilRemoved(t *testing.T) {
+	m := miniredis.RunT(t)
+	q := New(m.Addr(), DefaultKey, 0, transport.Options{})
+	defer q.Close()
+	ctx := context.Background()
+
+	at := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := q.Push(ctx, Entry{Time: at, Reason: ReasonDecode, Payload: []byte("first")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Push(ctx, Entry{Time: at, Reason: ReasonDecode, Payload: []byte("second")}); err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := q.Head(ctx)
+	if err != nil || string(h.Payload) != "first" {
+		t.Fatalf("Head = %q, %v; want the oldest entry", h.Payload, err)
+	}
+	// A replay that dies here, after re-injecting, must find the entry again.
+	if again, err := q.Head(ctx); err != nil || string(again.Payload) != "first" {
+		t.Fatalf("Head again = %q, %v; want the same entry", again.Payload, err)
+	}
+	if n, _ := q.Len(ctx); n != 2 {
+		t.Fatalf("Len = %d before Remove, want 2", n)
+	}
+
+	if err := q.Remove(ctx, h); err != nil {
+		t.Fatal(err)
+	}
+	if h, err = q.Head(ctx); err != nil || string(h.Payload) != "second" {
+		t.Fatalf("Head after Remove = %q, %v; want the next entry", h.Payload, err)
+	}
+	if err := q.Remove(ctx, h); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.Head(ctx); !errors.Is(err, ErrEmpty) {
+		t.Errorf("Head of an empty queue: %v, want ErrEmpty", err)
+	}
+}
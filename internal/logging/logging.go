@@ -0,0 +1,85 @@
+// Package logging sets up the log/slog logger the server, agent and bench share, so
+// their logs can be ingested and filtered alike: text or JSON records at a minimum
+// level, each tagged with the binary's component and host. Call sites add their own
+// fields; the usual ones are channel, source (a broker instance) and err.
+package logging
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Formats.
+const (
+	FormatText = "text" // key=value pairs, as slog.TextHandler writes them
+	FormatJSON = "json" // one JSON object per line
+)
+
+// New returns a logger that writes records of at least level ("debug", "info", "warn"
+// or "error") to w in format, with component and the host name on every record.
+func New(w io.Writer, component, format, level string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("log level %q: want debug, info, warn or error", level)
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+	var h slog.Handler
+	switch strings.ToLower(format) {
+	case FormatText:
+		h = slog.NewTextHandler(w, opts)
+	case FormatJSON:
+		h = slog.NewJSONHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("log format %q: want %s or %s", format, FormatText, FormatJSON)
+	}
+	attrs := []slog.Attr{slog.String("component", component)}
+	if host, err := os.Hostname(); err == nil {
+		attrs = append(attrs, slog.String("host", host))
+	}
+	return slog.New(h.WithAttrs(attrs)), nil
+}
+
+// Setup makes a logger from New, writing to stderr, the default. That covers slog's
+// top-level functions and anything still using the log package, which is logged at
+// info.
+func Setup(component, format, level string) error {
+	l, err := New(os.Stderr, component, format, level)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(l)
+	return nil
+}
+
+// Flags are the -log-format and -log-level flags of the agent and bench. The server
+// reads the same settings from LOG_FORMAT and LOG_LEVEL.
+type Flags struct {
+	format, level *string
+}
+
+// AddFlags defines the logging flags on fs.
+func AddFlags(fs *flag.FlagSet) *Flags {
+	return &Flags{
+		format: fs.String("log-format", FormatText, "log format: text (key=value) or json"),
+		level:  fs.String("log-level", "info", "minimum log level: debug, info, warn or error"),
+	}
+}
+
+// Setup calls Setup with the flags. It must be called after fs.Parse.
+func (f *Flags) Setup(component string) error {
+	if err := Setup(component, *f.format, *f.level); err != nil {
+		return fmt.Errorf("-log-format/-log-level: %w", err)
+	}
+	return nil
+}
+
+// Fatal logs msg and args at error level and exits with status 1, for failures at
+// startup.
+func Fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
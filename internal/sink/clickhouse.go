@@ -0,0 +1,151 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const clickHouseSchema = `CREATE TABLE IF NOT EXISTS %s (
+	time        DateTime64(9, 'UTC'),
+	measurement LowCardinality(String),
+	field       LowCardinality(String),
+	value       Float64,
+	tags        Map(LowCardinality(String), String)
+) ENGINE = MergeTree
+ORDER BY (measurement, field, time)`
+
+// clickHouseTable matches table or database.table, the names ClickHouseSink quotes.
+var clickHouseTable = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_]*\.)?[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ClickHouseSink writes batches to ClickHouse over its HTTP interface, one
+// INSERT ... VALUES per batch. As in SQLiteSink every field is a row (time, measurement,
+// field, value, tags), with tags as a Map so they can be queried as tags['region'].
+// ClickHouse prefers few large inserts, so give it a large BATCH_SIZE, or add
+// async_insert=1 to the URL's query, which is passed through as settings.
+type ClickHouseSink struct {
+	url            string
+	table          string // quoted
+	user, password string
+	client         *http.Client
+
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+// NewClickHouseSink targets the HTTP interface at baseURL (e.g. http://clickhouse:8123)
+// and creates table (name or database.name) if it doesn't exist. user may be empty
+// for the default user.
+func NewClickHouseSink(baseURL, table, user, password string) (*ClickHouseSink, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("sink: ClickHouse URL %q must be an absolute http(s) URL", baseURL)
+	}
+	if !clickHouseTable.MatchString(table) {
+		return nil, fmt.Errorf("sink: ClickHouse table %q must be name or database.name", table)
+	}
+	s := &ClickHouseSink{
+		url:      u.String(),
+		table:    "`" + strings.ReplaceAll(table, ".", "`.`") + "`",
+		user:     user,
+		password: password,
+		client:   newHTTPClient(0),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := s.exec(ctx, []byte(fmt.Sprintf(clickHouseSchema, s.table))); err != nil {
+		return nil, fmt.Errorf("clickhouse init: %w", err)
+	}
+	return s, nil
+}
+
+func (s *ClickHouseSink) WriteBatch(ctx context.Context, points []Point) error {
+	if len(points) == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.buf.Reset()
+	s.buf.WriteString("INSERT INTO ")
+	s.buf.WriteString(s.table)
+	s.buf.WriteString(" (time, measurement, field, value, tags) VALUES ")
+	var num [32]byte
+	rows := 0
+	for i := range points {
+		p := &points[i]
+		ts := time.Unix(0, p.TimeNano).UTC().AppendFormat(num[:0], "2006-01-02 15:04:05.000000000")
+		name := p.Name()
+		p.EachField(func(key string, v float64) {
+			if rows > 0 {
+				s.buf.WriteByte(',')
+			}
+			rows++
+			s.buf.WriteString("('")
+			s.buf.Write(ts)
+			s.buf.WriteString("',")
+			appendClickHouseString(&s.buf, name)
+			s.buf.WriteByte(',')
+			appendClickHouseString(&s.buf, key)
+			s.buf.WriteByte(',')
+			s.buf.WriteString(strconv.FormatFloat(v, 'g', -1, 64))
+			s.buf.WriteString(",{")
+			for j, t := range p.Tags {
+				if j > 0 {
+					s.buf.WriteByte(',')
+				}
+				appendClickHouseString(&s.buf, t.Key)
+				s.buf.WriteByte(':')
+				appendClickHouseString(&s.buf, t.Value)
+			}
+			s.buf.WriteString("})")
+		})
+	}
+	if rows == 0 {
+		return nil
+	}
+	return s.exec(ctx, s.buf.Bytes())
+}
+
+func (s *ClickHouseSink) Close() error { return nil }
+
+// exec posts one statement.
+func (s *ClickHouseSink) exec(ctx context.Context, stmt []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(stmt))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if s.user != "" {
+		req.Header.Set("X-ClickHouse-User", s.user)
+		req.Header.Set("X-ClickHouse-Key", s.password)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	// ClickHouse explains the problem (unknown table, bad type, ...) in the body.
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return fmt.Errorf("clickhouse status %d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+}
+
+var clickHouseEscaper = strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+
+// appendClickHouseString writes v as a quoted ClickHouse string literal.
+func appendClickHouseString(buf *bytes.Buffer, v string) {
+	buf.WriteByte('\'')
+	clickHouseEscaper.WriteString(buf, v)
+	buf.WriteByte('\'')
+}
@@ -0,0 +1,127 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultGraphiteTemplate names series like system_stats.cpu.
+const DefaultGraphiteTemplate = "{measurement}.{field}"
+
+const graphiteDialTimeout = 5 * time.Second
+
+// GraphiteSink writes batches as Carbon plaintext (`path value timestamp`) over TCP.
+// Paths come from a dotted template where {measurement} and {field} are the point's
+// name and field key and any other {name} is the value of that tag ("none" if unset).
+// A dropped connection is re-dialled and the batch resent once.
+type GraphiteSink struct {
+	addr     string
+	segments []string // template split on '.'
+
+	mu   sync.Mutex
+	conn net.Conn
+	buf  bytes.Buffer
+}
+
+// NewGraphiteSink targets a Carbon plaintext listener (e.g. carbon:2003).
+func NewGraphiteSink(addr, template string) (*GraphiteSink, error) {
+	if template == "" {
+		template = DefaultGraphiteTemplate
+	}
+	if !strings.Contains(template, "{field}") {
+		return nil, fmt.Errorf("sink: graphite template %q must contain {field}", template)
+	}
+	return &GraphiteSink{addr: addr, segments: strings.Split(template, ".")}, nil
+}
+
+var graphiteEscaper = strings.NewReplacer(".", "_", " ", "_", "/", "_")
+
+// appendPath renders the template for one field of p.
+func (s *GraphiteSink) appendPath(buf *bytes.Buffer, p *Point, field string) {
+	for i, seg := range s.segments {
+		if i > 0 {
+			buf.WriteByte('.')
+		}
+		name, ok := strings.CutPrefix(seg, "{")
+		if name, ok2 := strings.CutSuffix(name, "}"); ok && ok2 {
+			buf.WriteString(graphiteEscaper.Replace(s.lookup(p, name, field)))
+			continue
+		}
+		buf.WriteString(seg)
+	}
+}
+
+func (s *GraphiteSink) lookup(p *Point, name, field string) string {
+	switch name {
+	case "measurement":
+		return p.Name()
+	case "field":
+		return field
+	}
+	for _, t := range p.Tags {
+		if t.Key == name {
+			return t.Value
+		}
+	}
+	return "none"
+}
+
+func (s *GraphiteSink) WriteBatch(ctx context.Context, points []Point) error {
+	if len(points) == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.buf.Reset()
+	var ts, num [32]byte
+	for i := range points {
+		p := &points[i]
+		sec := strconv.AppendInt(ts[:0], p.TimeNano/1e9, 10)
+		p.EachField(func(key string, v float64) {
+			s.appendPath(&s.buf, p, key)
+			s.buf.WriteByte(' ')
+			s.buf.Write(strconv.AppendFloat(num[:0], v, 'f', -1, 64))
+			s.buf.WriteByte(' ')
+			s.buf.Write(sec)
+			s.buf.WriteByte('\n')
+		})
+	}
+	// A write to a connection Carbon has closed can fail only on the second attempt,
+	// so one retry on a fresh connection covers the common drop.
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if s.conn == nil {
+			var d net.Dialer
+			dctx, cancel := context.WithTimeout(ctx, graphiteDialTimeout)
+			s.conn, err = d.DialContext(dctx, "tcp", s.addr)
+			cancel()
+			if err != nil {
+				s.conn = nil
+				return fmt.Errorf("graphite dial: %w", err)
+			}
+		}
+		if _, err = s.conn.Write(s.buf.Bytes()); err == nil {
+			return nil
+		}
+		s.conn.Close()
+		s.conn = nil
+	}
+	return fmt.Errorf("graphite write: %w", err)
+}
+
+func (s *GraphiteSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
@@ -0,0 +1,258 @@
+package sink
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// InfluxContentType is what InfluxDB documents for line protocol; some compatible
+// endpoints and proxies only accept text/plain.
+const InfluxContentType = "application/vnd.influxdb.lineprotocol"
+
+// InfluxWriteURL returns the InfluxDB v2 write endpoint for org and bucket.
+func InfluxWriteURL(baseURL, org, bucket string) string {
+	return strings.TrimRight(baseURL, "/") + "/api/v2/write?org=" + url.QueryEscape(org) + "&bucket=" + url.QueryEscape(bucket)
+}
+
+// RetryPolicy says which failed Influx writes are retried and how long to wait in
+// between. Network errors and 5xx responses are retried up to Retries times, 429s up
+// to RateLimitRetries times; any other status means Influx will never accept the
+// batch, so it fails at once. The wait starts at Backoff and doubles with every retry
+// of the batch, up to MaxWait; a 429's Retry-After header replaces it.
+type RetryPolicy struct {
+	Retries          int
+	RateLimitRetries int
+	Backoff          time.Duration
+	MaxWait          time.Duration
+	// Jitter shortens each computed wait by a random fraction of up to this much, so
+	// writers that failed together don't all retry at the same moment.
+	Jitter float64
+}
+
+// DefaultRetryPolicy is the server's policy when no INFLUX_RETRY* variable is set.
+var DefaultRetryPolicy = RetryPolicy{
+	Retries:          3,
+	RateLimitRetries: 5,
+	Backoff:          time.Second,
+	MaxWait:          30 * time.Second,
+	Jitter:           0.2,
+}
+
+// wait returns how long to sleep before retry number n (0 for the first).
+func (p RetryPolicy) wait(n int, retryAfter time.Duration) time.Duration {
+	if retryAfter > 0 {
+		return min(retryAfter, p.MaxWait)
+	}
+	d := p.Backoff
+	for i := 0; i < n && d < p.MaxWait; i++ {
+		d *= 2
+	}
+	d = min(d, p.MaxWait)
+	return d - time.Duration(float64(d)*p.Jitter*rand.Float64())
+}
+
+// InfluxOptions are InfluxSink's optional settings.
+type InfluxOptions struct {
+	ContentType string // empty means InfluxContentType
+	Gzip        bool   // send Content-Encoding: gzip bodies
+	// Timeout bounds each attempt, response included; 0 means DefaultHTTPTimeout.
+	// Retries get a fresh one.
+	Timeout time.Duration
+	Retry   RetryPolicy
+	// Observe, if set, is called with the latency of every write Influx answered, from
+	// the goroutine that called WriteBatch.
+	Observe func(time.Duration)
+}
+
+var bufferPool = sync.Pool{
+	New: func() interface{} { return &bytes.Buffer{} },
+}
+
+var gzipPool = sync.Pool{
+	New: func() interface{} { return gzip.NewWriter(io.Discard) },
+}
+
+// InfluxSink writes batches to the InfluxDB v2 write API, retrying as its RetryPolicy
+// allows. WriteBatch is safe for concurrent use and blocks for the whole write,
+// retries included, so callers that mustn't wait run it from goroutines of their own.
+type InfluxSink struct {
+	writeURL string
+	token    string
+	prec     Precision
+	opts     InfluxOptions
+	client   *http.Client
+
+	rateLimited atomic.Uint64
+	retried     atomic.Uint64
+}
+
+// NewInfluxSink writes to writeURL (see InfluxWriteURL) with token.
+func NewInfluxSink(writeURL, token string, prec Precision, opts InfluxOptions) *InfluxSink {
+	if opts.ContentType == "" {
+		opts.ContentType = InfluxContentType
+	}
+	return &InfluxSink{writeURL: writeURL, token: token, prec: prec, opts: opts, client: newHTTPClient(opts.Timeout)}
+}
+
+// RateLimited returns how many 429 responses Influx has sent.
+func (s *InfluxSink) RateLimited() uint64 { return s.rateLimited.Load() }
+
+// Retried returns how many write attempts were retried, for any reason.
+func (s *InfluxSink) Retried() uint64 { return s.retried.Load() }
+
+// WriteBatch writes points as line protocol and returns why Influx didn't accept them
+// if it didn't.
+func (s *InfluxSink) WriteBatch(ctx context.Context, points []Point) error {
+	if len(points) == 0 {
+		return nil
+	}
+	buf := bufferPool.Get().(*bytes.Buffer)
+	defer bufferPool.Put(buf)
+	buf.Reset()
+	for _, p := range points {
+		AppendLineProtocol(buf, p, s.prec)
+	}
+	return s.WriteLines(ctx, buf.Bytes())
+}
+
+// WriteLines writes a body that is already line protocol, e.g. one kept by the
+// dead-letter queue.
+//
+// The body is gzipped once into a pooled buffer that stays checked out until the last
+// attempt returns; every retry re-sends the same bytes, and the buffer goes back to
+// the pool exactly once, via the defer.
+func (s *InfluxSink) WriteLines(ctx context.Context, body []byte) error {
+	if s.opts.Gzip {
+		zbuf := bufferPool.Get().(*bytes.Buffer)
+		defer bufferPool.Put(zbuf)
+		zbuf.Reset()
+		zw := gzipPool.Get().(*gzip.Writer)
+		zw.Reset(zbuf)
+		_, err := zw.Write(body)
+		if err == nil {
+			err = zw.Close()
+		}
+		gzipPool.Put(zw)
+		if err != nil {
+			return fmt.Errorf("gzip: %w", err)
+		}
+		body = zbuf.Bytes()
+	}
+
+	retry := s.opts.Retry
+	var transient, limited int // retries so far, by cause
+	for {
+		start := time.Now()
+		resp, err := s.post(ctx, body)
+		if err == nil && s.opts.Observe != nil {
+			s.opts.Observe(time.Since(start))
+		}
+		var reason string
+		switch {
+		case err != nil:
+			if transient >= retry.Retries || ctx.Err() != nil {
+				return fmt.Errorf("%w (after %d retries)", err, transient)
+			}
+			transient++
+			reason, resp.retryAfter = err.Error(), 0
+		case resp.status == http.StatusNoContent || resp.status == http.StatusOK:
+			return nil
+		case resp.status == http.StatusTooManyRequests:
+			s.rateLimited.Add(1)
+			if limited >= retry.RateLimitRetries {
+				return fmt.Errorf("rate limited (after %d retries)", limited)
+			}
+			limited++
+			reason = "rate limited"
+		case resp.status >= 500:
+			if transient >= retry.Retries {
+				return fmt.Errorf("%s (after %d retries)", resp, transient)
+			}
+			transient++
+			reason, resp.retryAfter = resp.String(), 0
+		default:
+			return errors.New(resp.String())
+		}
+		wait := retry.wait(transient+limited-1, resp.retryAfter)
+		s.retried.Add(1)
+		slog.Warn("Influx batch write failed; retrying", "reason", reason, "retry_in", wait.Round(time.Millisecond))
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%s; not retried: %w", reason, ctx.Err())
+		case <-time.After(wait):
+		}
+	}
+}
+
+// Close is a no-op; InfluxSink holds no connections of its own.
+func (s *InfluxSink) Close() error { return nil }
+
+// influxResponse is what post keeps of a write response.
+type influxResponse struct {
+	status     int
+	retryAfter time.Duration // from Retry-After; 0 if missing or unusable
+	message    string        // start of the body of an error response: Influx says what was wrong there
+}
+
+func (r influxResponse) String() string {
+	if r.message == "" {
+		return fmt.Sprintf("status %d", r.status)
+	}
+	return fmt.Sprintf("status %d: %s", r.status, r.message)
+}
+
+// maxErrorMessage is how much of an error response's body influxResponse keeps.
+const maxErrorMessage = 512
+
+// post sends one write request.
+func (s *InfluxSink) post(ctx context.Context, body []byte) (influxResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.writeURL, bytes.NewReader(body))
+	if err != nil {
+		return influxResponse{}, err
+	}
+	req.Header.Set("Authorization", "Token "+s.token)
+	req.Header.Set("Content-Type", s.opts.ContentType)
+	if s.opts.Gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return influxResponse{}, err
+	}
+	defer resp.Body.Close()
+	r := influxResponse{status: resp.StatusCode, retryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())}
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorMessage))
+		r.message = strings.TrimSpace(string(msg))
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return r, nil
+}
+
+// parseRetryAfter accepts either delay-seconds or an HTTP date; it returns 0 when
+// the header is missing or unusable.
+func parseRetryAfter(v string, now time.Time) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		return time.Duration(max(secs, 0)) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(t.Sub(now), 0)
+	}
+	return 0
+}
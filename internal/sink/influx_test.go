@@ -0,0 +1,34 @@
+package sink
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestInfluxWriteTimesOut(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select { // an Influx that stops answering
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	s := NewInfluxSink(srv.URL, "token", nil, InfluxOptions{Timeout: 20 * time.Millisecond, Retry: RetryPolicy{Retries: 1, Backoff: time.Millisecond, MaxWait: time.Millisecond}})
+	start := time.Now()
+	err := s.WriteBatch(context.Background(), []Point{{TimeNano: 1}})
+	if err == nil {
+		t.Fatal("write to a hung Influx succeeded")
+	}
+	if took := time.Since(start); took > 5*time.Second {
+		t.Errorf("write gave up after %v", took)
+	}
+	if s.Retried() != 1 {
+		t.Errorf("retried %d times, want 1: a timeout is retried", s.Retried())
+	}
+}
@@ -0,0 +1,257 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/compress"
+	"github.com/parquet-go/parquet-go/compress/gzip"
+	"github.com/parquet-go/parquet-go/compress/snappy"
+	"github.com/parquet-go/parquet-go/compress/uncompressed"
+	"github.com/parquet-go/parquet-go/compress/zstd"
+)
+
+// DefaultParquetFilename names a ParquetSink's files unless configured otherwise.
+const DefaultParquetFilename = "sentinel-{host}-{time}.parquet"
+
+// parquetRowGroupRows is how many rows a ParquetSink buffers before writing them out as
+// a row group. Row groups are what the size limit sees, and what readers skip over.
+const parquetRowGroupRows = 50000
+
+// parquetRotateCheck is how often a ParquetSink checks the age of its file when no
+// batches arrive, so a quiet period still ends with a complete file.
+const parquetRotateCheck = time.Second
+
+// parquetRow is one field of one point, as in SQLiteSink.
+type parquetRow struct {
+	Time        int64             `parquet:"time,timestamp(nanosecond)"`
+	Measurement string            `parquet:"measurement,dict"`
+	Field       string            `parquet:"field,dict"`
+	Value       float64           `parquet:"value"`
+	Tags        map[string]string `parquet:"tags"`
+}
+
+// appendParquetRows appends a row for every field of points.
+func appendParquetRows(rows []parquetRow, points []Point) []parquetRow {
+	for i := range points {
+		p := &points[i]
+		var tags map[string]string
+		if len(p.Tags) > 0 {
+			tags = make(map[string]string, len(p.Tags))
+			for _, t := range p.Tags {
+				tags[t.Key] = t.Value
+			}
+		}
+		name := p.Name()
+		p.EachField(func(key string, v float64) {
+			rows = append(rows, parquetRow{Time: p.TimeNano, Measurement: name, Field: key, Value: v, Tags: tags})
+		})
+	}
+	return rows
+}
+
+// ParseParquetCompression returns the codec called name: snappy, zstd, gzip or none.
+func ParseParquetCompression(name string) (compress.Codec, error) {
+	switch name {
+	case "snappy":
+		return &snappy.Codec{}, nil
+	case "zstd":
+		return &zstd.Codec{}, nil
+	case "gzip":
+		return &gzip.Codec{}, nil
+	case "none":
+		return &uncompressed.Codec{}, nil
+	default:
+		return nil, fmt.Errorf("unknown parquet compression %q (want snappy, zstd, gzip or none)", name)
+	}
+}
+
+// ParquetSink writes batches to Parquet files in a directory, one row per field, for
+// bulk loads into a data lake. A file is closed, and the next batch starts a new one,
+// once it reaches maxBytes on disk or is maxAge old. Files are written under a .tmp
+// suffix and renamed when complete, so a loader watching the directory only ever sees
+// finished files.
+//
+// The filename template may use {time} (when the file was started, UTC,
+// 20060102T150405Z) and {host} (the server's hostname); a name that is already taken
+// gets a -1, -2, ... suffix before the extension.
+type ParquetSink struct {
+	dir      string
+	template string
+	host     string
+	codec    compress.Codec
+	maxBytes int64
+	maxAge   time.Duration
+
+	mu      sync.Mutex
+	f       *os.File
+	w       *parquet.GenericWriter[parquetRow]
+	path    string // final name of the open file
+	opened  time.Time
+	pending int // rows not yet in a row group
+	rows    []parquetRow
+	closed  bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewParquetSink writes to dir, creating it if needed.
+func NewParquetSink(dir, template string, codec compress.Codec, maxBytes int64, maxAge time.Duration) (*ParquetSink, error) {
+	if maxBytes <= 0 || maxAge <= 0 {
+		return nil, errors.New("sink: parquet size and age limits must be positive")
+	}
+	if template == "" {
+		template = DefaultParquetFilename
+	}
+	if strings.ContainsRune(template, filepath.Separator) {
+		return nil, fmt.Errorf("sink: parquet filename template %q must not contain a path separator", template)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "unknown"
+	}
+	s := &ParquetSink{
+		dir:      dir,
+		template: template,
+		host:     host,
+		codec:    codec,
+		maxBytes: maxBytes,
+		maxAge:   maxAge,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go s.rotateLoop()
+	return s, nil
+}
+
+func (s *ParquetSink) WriteBatch(_ context.Context, points []Point) error {
+	if len(points) == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return errSinkClosed
+	}
+	if s.w == nil {
+		if err := s.open(); err != nil {
+			return err
+		}
+	}
+	s.rows = appendParquetRows(s.rows[:0], points)
+	if _, err := s.w.Write(s.rows); err != nil {
+		return fmt.Errorf("parquet write: %w", err)
+	}
+	s.pending += len(s.rows)
+	if s.pending >= parquetRowGroupRows {
+		if err := s.w.Flush(); err != nil {
+			return fmt.Errorf("parquet flush: %w", err)
+		}
+		s.pending = 0
+	}
+	if s.due(time.Now()) {
+		return s.finish()
+	}
+	return nil
+}
+
+// due reports whether the open file has reached a limit. Called with s.mu held.
+func (s *ParquetSink) due(now time.Time) bool {
+	if s.w == nil {
+		return false
+	}
+	if now.Sub(s.opened) >= s.maxAge {
+		return true
+	}
+	st, err := s.f.Stat()
+	return err == nil && st.Size() >= s.maxBytes
+}
+
+// open starts a new file. Called with s.mu held.
+func (s *ParquetSink) open() error {
+	now := time.Now().UTC()
+	name := strings.NewReplacer("{time}", now.Format("20060102T150405Z"), "{host}", s.host).Replace(s.template)
+	path := filepath.Join(s.dir, name)
+	ext := filepath.Ext(name)
+	for i := 1; ; i++ {
+		if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+			break
+		}
+		path = filepath.Join(s.dir, strings.TrimSuffix(name, ext)+"-"+strconv.Itoa(i)+ext)
+	}
+	f, err := os.OpenFile(path+".tmp", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	s.f, s.path, s.opened, s.pending = f, path, now, 0
+	s.w = parquet.NewGenericWriter[parquetRow](f, parquet.Compression(s.codec))
+	return nil
+}
+
+// finish writes the footer, closes the file and gives it its final name. Called with
+// s.mu held; the next write opens a new file.
+func (s *ParquetSink) finish() error {
+	w, f, path := s.w, s.f, s.path
+	s.w, s.f = nil, nil
+	err := w.Close()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("parquet close %s: %w", path, err)
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func (s *ParquetSink) rotateLoop() {
+	defer close(s.done)
+	t := time.NewTicker(parquetRotateCheck)
+	defer t.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case now := <-t.C:
+			s.mu.Lock()
+			if s.due(now) {
+				if err := s.finish(); err != nil {
+					slog.Error("Parquet sink rotate failed", "err", err)
+				}
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+// Close finishes the open file, if any.
+func (s *ParquetSink) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	s.mu.Unlock()
+	close(s.stop)
+	<-s.done
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.w == nil {
+		return nil
+	}
+	return s.finish()
+}
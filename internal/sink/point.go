@@ -0,0 +1,126 @@
+package sink
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Measurement is the line-protocol measurement for the core CPU/memory points.
+const Measurement = "system_stats"
+
+// Tag is a single key/value dimension on a point.
+type Tag struct {
+	Key   string
+	Value string
+}
+
+// Field is a single numeric value on a point.
+type Field struct {
+	Key   string
+	Value float64
+}
+
+// Point is one decoded metric on its way to storage. The hot path produces
+// system_stats points carrying CPU and Mem directly (no per-point field slice);
+// other collectors (e.g. GPU) set Measurement and put their values in Fields.
+type Point struct {
+	Measurement string // empty means Measurement with the cpu/mem fields
+	TimeNano    int64
+	CPU         float64
+	Mem         float64
+	Fields      []Field
+	Tags        []Tag
+}
+
+// Name returns the point's measurement.
+func (p *Point) Name() string {
+	if p.Measurement == "" {
+		return Measurement
+	}
+	return p.Measurement
+}
+
+// EachField calls fn for every field on the point: cpu and mem for system_stats
+// points, followed by any extra Fields.
+func (p *Point) EachField(fn func(key string, v float64)) {
+	if p.Measurement == "" {
+		fn("cpu", p.CPU)
+		fn("mem", p.Mem)
+	}
+	for _, f := range p.Fields {
+		fn(f.Key, f.Value)
+	}
+}
+
+// DefaultPrecision is the number of decimals written for a field with no override.
+const DefaultPrecision = 6
+
+// maxPrecision keeps overrides within what a float64 can meaningfully carry.
+const maxPrecision = 15
+
+// Precision maps a field key to the decimals written for it in line protocol; the
+// key "*" overrides the default for all other fields. A nil Precision writes every
+// field with DefaultPrecision.
+type Precision map[string]int
+
+// ParsePrecision reads a comma-separated list of field=decimals, e.g. "cpu=2,mem=2".
+func ParsePrecision(v string) (Precision, error) {
+	if strings.TrimSpace(v) == "" {
+		return nil, nil
+	}
+	prec := make(Precision)
+	for _, entry := range strings.Split(v, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		key, digits, ok := strings.Cut(entry, "=")
+		if !ok || strings.TrimSpace(key) == "" {
+			return nil, fmt.Errorf("sink: precision entry %q must be field=decimals", entry)
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(digits))
+		if err != nil || n < 0 || n > maxPrecision {
+			return nil, fmt.Errorf("sink: precision for %q must be between 0 and %d", key, maxPrecision)
+		}
+		prec[strings.TrimSpace(key)] = n
+	}
+	return prec, nil
+}
+
+func (pr Precision) digits(key string) int {
+	if n, ok := pr[key]; ok {
+		return n
+	}
+	if n, ok := pr["*"]; ok {
+		return n
+	}
+	return DefaultPrecision
+}
+
+var tagEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+
+// AppendLineProtocol writes p as one Influx line-protocol line (with trailing newline),
+// formatting each field with the decimals prec gives it.
+func AppendLineProtocol(buf *bytes.Buffer, p Point, prec Precision) {
+	buf.WriteString(p.Name())
+	for _, t := range p.Tags {
+		buf.WriteByte(',')
+		buf.WriteString(tagEscaper.Replace(t.Key))
+		buf.WriteByte('=')
+		buf.WriteString(tagEscaper.Replace(t.Value))
+	}
+	var num [32]byte
+	sep := byte(' ')
+	p.EachField(func(key string, v float64) {
+		buf.WriteByte(sep)
+		buf.WriteString(tagEscaper.Replace(key))
+		buf.WriteByte('=')
+		buf.Write(strconv.AppendFloat(num[:0], v, 'f', prec.digits(key), 64))
+		sep = ','
+	})
+	buf.WriteByte(' ')
+	buf.Write(strconv.AppendInt(num[:0], p.TimeNano, 10))
+	buf.WriteByte('\n')
+}
@@ -0,0 +1,97 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var postgresColumns = []string{"time", "measurement", "field", "value", "tags"}
+
+// PostgresSink writes batches to PostgreSQL with COPY, one COPY per batch. As in
+// SQLiteSink every field is a row (time, measurement, field, value, tags), with tags as
+// jsonb (tags->>'region'). At startup it creates the table if it doesn't exist and,
+// when the timescaledb extension is installed in the database, makes it a hypertable
+// partitioned on time; without it, it adds a (measurement, time) index instead.
+// timestamptz holds microseconds, so point times are truncated to those.
+type PostgresSink struct {
+	pool       *pgxpool.Pool
+	table      pgx.Identifier
+	hypertable bool
+}
+
+// NewPostgresSink connects with dsn (a postgres:// URL or key=value string) and
+// bootstraps table (name or schema.name).
+func NewPostgresSink(dsn, table string) (*PostgresSink, error) {
+	pool, err := pgxpool.New(context.Background(), dsn)
+	if err != nil {
+		return nil, fmt.Errorf("postgres: %w", err)
+	}
+	s := &PostgresSink{pool: pool, table: pgx.Identifier(strings.Split(table, "."))}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := s.bootstrap(ctx); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("postgres init: %w", err)
+	}
+	return s, nil
+}
+
+func (s *PostgresSink) bootstrap(ctx context.Context) error {
+	name := s.table.Sanitize()
+	if _, err := s.pool.Exec(ctx, `CREATE TABLE IF NOT EXISTS `+name+` (
+	time        timestamptz      NOT NULL,
+	measurement text             NOT NULL,
+	field       text             NOT NULL,
+	value       double precision NOT NULL,
+	tags        jsonb            NOT NULL DEFAULT '{}'
+)`); err != nil {
+		return err
+	}
+	if err := s.pool.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'timescaledb')`).Scan(&s.hypertable); err != nil {
+		return err
+	}
+	if s.hypertable {
+		// Only converts an empty table; if_not_exists makes restarts a no-op.
+		_, err := s.pool.Exec(ctx, `SELECT create_hypertable($1::regclass, 'time', if_not_exists => TRUE)`, name)
+		return err
+	}
+	index := pgx.Identifier{s.table[len(s.table)-1] + "_measurement_time"}.Sanitize()
+	_, err := s.pool.Exec(ctx, `CREATE INDEX IF NOT EXISTS `+index+` ON `+name+` (measurement, time)`)
+	return err
+}
+
+// Hypertable reports whether the table is a TimescaleDB hypertable.
+func (s *PostgresSink) Hypertable() bool { return s.hypertable }
+
+func (s *PostgresSink) WriteBatch(ctx context.Context, points []Point) error {
+	if len(points) == 0 {
+		return nil
+	}
+	rows := make([][]any, 0, len(points)*2)
+	for i := range points {
+		p := &points[i]
+		tags, err := tagsJSON(p.Tags)
+		if err != nil {
+			return err
+		}
+		t := time.Unix(0, p.TimeNano)
+		name := p.Name()
+		p.EachField(func(key string, v float64) {
+			rows = append(rows, []any{t, name, key, v, tags})
+		})
+	}
+	if _, err := s.pool.CopyFrom(ctx, s.table, postgresColumns, pgx.CopyFromRows(rows)); err != nil {
+		return fmt.Errorf("postgres copy: %w", err)
+	}
+	return nil
+}
+
+func (s *PostgresSink) Close() error {
+	s.pool.Close()
+	return nil
+}
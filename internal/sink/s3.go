@@ -0,0 +1,359 @@
+package sink
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/compress"
+	"github.com/parquet-go/parquet-go/compress/snappy"
+)
+
+// Archive object formats for S3Sink.
+const (
+	ArchiveFormatLineProtocol = "lp"      // gzipped line protocol, .lp.gz
+	ArchiveFormatParquet      = "parquet" // Parquet with ParquetSink's rows, .parquet
+)
+
+// DefaultS3Prefix is where S3Sink puts objects unless configured otherwise.
+const DefaultS3Prefix = "sentinel/{date}/"
+
+// s3UploadTimeout bounds one object upload, retries included.
+const s3UploadTimeout = 10 * time.Minute
+
+// S3Options configure an S3Sink.
+type S3Options struct {
+	Endpoint string // host[:port]; empty means AWS S3
+	Region   string // empty lets the client discover it
+	Bucket   string
+	// AccessKey and SecretKey are static credentials; without them the usual
+	// AWS_ACCESS_KEY_ID/AWS_SECRET_ACCESS_KEY variables and then an instance role are
+	// tried.
+	AccessKey, SecretKey string
+	Insecure             bool // plain HTTP, e.g. a local MinIO
+
+	// Prefix is prepended to every object name; it may use {date} (2006-01-02),
+	// {hour} (15) and {host}, all from when the object was started, in UTC.
+	Prefix string
+	Format string         // ArchiveFormatLineProtocol or ArchiveFormatParquet
+	Codec  compress.Codec // Parquet column compression; nil means snappy
+	Prec   Precision      // line protocol timestamps
+
+	// An object is uploaded once it holds FlushBytes of compressed data or is
+	// FlushEvery old, whichever comes first.
+	FlushBytes int64
+	FlushEvery time.Duration
+	// Objects larger than PartSize are sent as a multipart upload of PartSize parts
+	// (at least 5 MiB).
+	PartSize uint64
+}
+
+// S3Sink archives batches to an S3-compatible bucket. Batches are encoded and
+// compressed into an object in memory, which is uploaded by a background goroutine
+// when it is big or old enough, so WriteBatch only waits for S3 when two uploads are
+// already behind. Objects are named <prefix><host>-<start time>.lp.gz (or .parquet).
+// A failed upload is logged, and returned by the next WriteBatch; the client has
+// already retried it by then, so the object is lost.
+type S3Sink struct {
+	client *minio.Client
+	opts   S3Options
+	host   string
+
+	mu      sync.Mutex
+	obj     archiveObject // nil until the first batch after an upload
+	started time.Time
+	closed  bool
+
+	// failMu guards failed on its own: the uploader must never wait for mu, which a
+	// WriteBatch blocked on a full upload queue holds.
+	failMu sync.Mutex
+	failed error // last upload failure, not yet reported
+
+	uploads chan s3Upload
+	stop    chan struct{}
+	done    chan struct{} // closed when both goroutines have exited
+	wg      sync.WaitGroup
+}
+
+type s3Upload struct {
+	key, contentType string
+	body             []byte
+}
+
+// NewS3Sink checks that the bucket exists and starts the uploader.
+func NewS3Sink(opts S3Options) (*S3Sink, error) {
+	if opts.Bucket == "" {
+		return nil, fmt.Errorf("sink: S3 bucket is required")
+	}
+	if opts.Format != ArchiveFormatLineProtocol && opts.Format != ArchiveFormatParquet {
+		return nil, fmt.Errorf("unknown archive format %q (want lp or parquet)", opts.Format)
+	}
+	if opts.FlushBytes <= 0 || opts.FlushEvery <= 0 {
+		return nil, fmt.Errorf("sink: S3 flush size and interval must be positive")
+	}
+	if opts.PartSize < 5<<20 {
+		return nil, fmt.Errorf("sink: S3 part size must be at least 5 MiB")
+	}
+	if opts.Endpoint == "" {
+		opts.Endpoint = "s3.amazonaws.com"
+	}
+	if opts.Prefix == "" {
+		opts.Prefix = DefaultS3Prefix
+	}
+	if opts.Codec == nil {
+		opts.Codec = &snappy.Codec{}
+	}
+	creds := credentials.NewStaticV4(opts.AccessKey, opts.SecretKey, "")
+	if opts.AccessKey == "" {
+		creds = credentials.NewChainCredentials([]credentials.Provider{
+			&credentials.EnvAWS{},
+			&credentials.IAM{Client: &http.Client{Transport: http.DefaultTransport}},
+		})
+	}
+	client, err := minio.New(opts.Endpoint, &minio.Options{Creds: creds, Secure: !opts.Insecure, Region: opts.Region})
+	if err != nil {
+		return nil, fmt.Errorf("s3: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	ok, err := client.BucketExists(ctx, opts.Bucket)
+	if err != nil {
+		return nil, fmt.Errorf("s3 bucket %s: %w", opts.Bucket, err)
+	}
+	if !ok {
+		return nil, fmt.Errorf("s3 bucket %s does not exist", opts.Bucket)
+	}
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "unknown"
+	}
+	s := &S3Sink{
+		client:  client,
+		opts:    opts,
+		host:    host,
+		uploads: make(chan s3Upload, 2),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	s.wg.Add(2)
+	go s.uploadLoop()
+	go s.flushLoop()
+	go func() { s.wg.Wait(); close(s.done) }()
+	return s, nil
+}
+
+func (s *S3Sink) WriteBatch(_ context.Context, points []Point) error {
+	if len(points) == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return errSinkClosed
+	}
+	if err := s.takeFailure(); err != nil {
+		return err
+	}
+	if s.obj == nil {
+		s.obj = s.newObject()
+		s.started = time.Now()
+	}
+	if err := s.obj.write(points); err != nil {
+		return err
+	}
+	if s.obj.size() >= s.opts.FlushBytes {
+		return s.flushLocked()
+	}
+	return nil
+}
+
+func (s *S3Sink) newObject() archiveObject {
+	if s.opts.Format == ArchiveFormatParquet {
+		return newParquetObject(s.opts.Codec)
+	}
+	return newLineProtocolObject(s.opts.Prec)
+}
+
+// flushLocked finishes the current object and queues it for upload. Called with s.mu
+// held; blocks while the upload queue is full.
+func (s *S3Sink) flushLocked() error {
+	obj, started := s.obj, s.started
+	s.obj = nil
+	body, err := obj.finish()
+	if err != nil {
+		return err
+	}
+	t := started.UTC()
+	prefix := strings.NewReplacer("{date}", t.Format("2006-01-02"), "{hour}", t.Format("15"), "{host}", s.host).Replace(s.opts.Prefix)
+	key := prefix + s.host + "-" + t.Format("20060102T150405.000Z") + obj.extension()
+	s.uploads <- s3Upload{key: key, contentType: obj.contentType(), body: body}
+	return nil
+}
+
+func (s *S3Sink) flushLoop() {
+	defer s.wg.Done()
+	// Check often enough that an object is never much older than FlushEvery.
+	t := time.NewTicker(min(s.opts.FlushEvery/10+time.Millisecond, time.Second))
+	defer t.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case now := <-t.C:
+			s.mu.Lock()
+			var err error
+			if s.obj != nil && now.Sub(s.started) >= s.opts.FlushEvery {
+				err = s.flushLocked()
+			}
+			s.mu.Unlock()
+			if err != nil {
+				slog.Error("S3 sink flush failed", "err", err)
+			}
+		}
+	}
+}
+
+func (s *S3Sink) uploadLoop() {
+	defer s.wg.Done()
+	for u := range s.uploads {
+		ctx, cancel := context.WithTimeout(context.Background(), s3UploadTimeout)
+		_, err := s.client.PutObject(ctx, s.opts.Bucket, u.key, bytes.NewReader(u.body), int64(len(u.body)), minio.PutObjectOptions{
+			ContentType: u.contentType,
+			PartSize:    s.opts.PartSize,
+		})
+		cancel()
+		if err != nil {
+			err = fmt.Errorf("s3 upload %s (%d bytes): %w", u.key, len(u.body), err)
+			slog.Error("S3 sink upload failed", "err", err)
+			s.failMu.Lock()
+			s.failed = err
+			s.failMu.Unlock()
+		}
+	}
+}
+
+// Close uploads the current object and waits for every queued upload to finish.
+func (s *S3Sink) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	s.mu.Unlock()
+	close(s.stop)
+
+	s.mu.Lock()
+	var err error
+	if s.obj != nil {
+		err = s.flushLocked()
+	}
+	s.mu.Unlock()
+	close(s.uploads)
+	<-s.done
+
+	if err == nil {
+		err = s.takeFailure()
+	}
+	return err
+}
+
+// takeFailure returns the last upload failure once.
+func (s *S3Sink) takeFailure() error {
+	s.failMu.Lock()
+	defer s.failMu.Unlock()
+	err := s.failed
+	s.failed = nil
+	return err
+}
+
+// archiveObject is an S3 object being built in memory.
+type archiveObject interface {
+	write(points []Point) error
+	size() int64 // compressed bytes so far
+	finish() ([]byte, error)
+	extension() string
+	contentType() string
+}
+
+type lineProtocolObject struct {
+	prec Precision
+	buf  bytes.Buffer
+	line bytes.Buffer
+	zw   *gzip.Writer
+}
+
+func newLineProtocolObject(prec Precision) *lineProtocolObject {
+	o := &lineProtocolObject{prec: prec}
+	o.zw = gzip.NewWriter(&o.buf)
+	return o
+}
+
+func (o *lineProtocolObject) write(points []Point) error {
+	o.line.Reset()
+	for _, p := range points {
+		AppendLineProtocol(&o.line, p, o.prec)
+	}
+	_, err := o.zw.Write(o.line.Bytes())
+	return err
+}
+
+func (o *lineProtocolObject) size() int64 { return int64(o.buf.Len()) }
+
+func (o *lineProtocolObject) finish() ([]byte, error) {
+	if err := o.zw.Close(); err != nil {
+		return nil, err
+	}
+	return o.buf.Bytes(), nil
+}
+
+func (o *lineProtocolObject) extension() string   { return ".lp.gz" }
+func (o *lineProtocolObject) contentType() string { return "application/gzip" }
+
+type parquetObject struct {
+	buf     bytes.Buffer
+	w       *parquet.GenericWriter[parquetRow]
+	rows    []parquetRow
+	pending int
+}
+
+func newParquetObject(codec compress.Codec) *parquetObject {
+	o := &parquetObject{}
+	o.w = parquet.NewGenericWriter[parquetRow](&o.buf, parquet.Compression(codec))
+	return o
+}
+
+func (o *parquetObject) write(points []Point) error {
+	o.rows = appendParquetRows(o.rows[:0], points)
+	if _, err := o.w.Write(o.rows); err != nil {
+		return fmt.Errorf("parquet write: %w", err)
+	}
+	o.pending += len(o.rows)
+	if o.pending >= parquetRowGroupRows {
+		o.pending = 0
+		return o.w.Flush()
+	}
+	return nil
+}
+
+func (o *parquetObject) size() int64 { return int64(o.buf.Len()) }
+
+func (o *parquetObject) finish() ([]byte, error) {
+	if err := o.w.Close(); err != nil {
+		return nil, err
+	}
+	return o.buf.Bytes(), nil
+}
+
+func (o *parquetObject) extension() string   { return ".parquet" }
+func (o *parquetObject) contentType() string { return "application/vnd.apache.parquet" }
@@ -0,0 +1,106 @@
+package sink
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"sync/atomic"
+	"time"
+)
+
+// Defaults for NewShadowSink.
+const (
+	DefaultShadowQueue   = 16 // batches
+	DefaultShadowTimeout = 10 * time.Second
+)
+
+// ShadowSink mirrors every batch to a shadow backend alongside the primary, so a new
+// backend can be fed production traffic before it is trusted. Primary errors are
+// returned; shadow errors are only logged and counted. Primary may be nil when the
+// production write happens elsewhere (the server's InfluxDB writer, for example).
+//
+// The shadow is written from its own goroutine through a bounded queue, each write
+// with its own timeout, so a slow or hung shadow never holds up the caller: when the
+// queue is full the batch is dropped for the shadow and counted.
+type ShadowSink struct {
+	Primary Sink
+	Shadow  Sink
+	Name    string // shadow's name in log messages
+
+	timeout time.Duration
+	queue   chan []Point
+	done    chan struct{} // closed when run has returned
+
+	errors  atomic.Uint64
+	dropped atomic.Uint64
+}
+
+// NewShadowSink wraps primary (may be nil) and shadow, queueing up to queue batches
+// for the shadow and giving each shadow write timeout.
+func NewShadowSink(primary, shadow Sink, name string, queue int, timeout time.Duration) *ShadowSink {
+	s := &ShadowSink{
+		Primary: primary,
+		Shadow:  shadow,
+		Name:    name,
+		timeout: timeout,
+		queue:   make(chan []Point, queue),
+		done:    make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// WriteBatch writes points to the primary and queues a copy for the shadow.
+func (s *ShadowSink) WriteBatch(ctx context.Context, points []Point) error {
+	var err error
+	if s.Primary != nil {
+		err = s.Primary.WriteBatch(ctx, points)
+	}
+	select {
+	case s.queue <- slices.Clone(points): // the caller reuses points
+	default:
+		s.dropped.Add(1)
+		slog.Warn("Shadow sink queue full; dropping the batch for it", "sink", s.Name, "points", len(points))
+	}
+	return err
+}
+
+func (s *ShadowSink) run() {
+	defer close(s.done)
+	for points := range s.queue {
+		ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+		err := s.Shadow.WriteBatch(ctx, points)
+		cancel()
+		if err != nil {
+			s.errors.Add(1)
+			slog.Warn("Shadow sink write failed", "sink", s.Name, "err", err)
+		}
+	}
+}
+
+// Errors returns how many shadow writes have failed.
+func (s *ShadowSink) Errors() uint64 { return s.errors.Load() }
+
+// Dropped returns how many batches the shadow missed because its queue was full.
+func (s *ShadowSink) Dropped() uint64 { return s.dropped.Load() }
+
+// Close closes the primary, then gives the shadow one write timeout to finish the
+// queued batches before closing it. A shadow that doesn't finish in time is left
+// open, since a write may still be using it.
+func (s *ShadowSink) Close() error {
+	var err error
+	if s.Primary != nil {
+		err = s.Primary.Close()
+	}
+	close(s.queue)
+	select {
+	case <-s.done:
+	case <-time.After(s.timeout):
+		slog.Warn("Shadow sink still writing; not waiting for it", "sink", s.Name, "queued", len(s.queue))
+		return err
+	}
+	if serr := s.Shadow.Close(); serr != nil {
+		slog.Warn("Shadow sink close failed", "sink", s.Name, "err", serr)
+	}
+	return err
+}
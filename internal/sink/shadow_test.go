@@ -0,0 +1,111 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// hungSink blocks every write until its context is done or release is closed, and
+// counts the points it was given.
+type hungSink struct {
+	release chan struct{}
+	mu      sync.Mutex
+	points  int
+	closed  bool
+}
+
+func (s *hungSink) WriteBatch(ctx context.Context, points []Point) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-s.release:
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.points += len(points)
+	return nil
+}
+
+func (s *hungSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+func (s *hungSink) state() (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.points, s.closed
+}
+
+func TestShadowDoesNotBlockOnHungShadow(t *testing.T) {
+	shadow := &hungSink{release: make(chan struct{})}
+	s := NewShadowSink(nil, shadow, "hung", 2, time.Hour)
+	batch := []Point{{TimeNano: 1}}
+
+	start := time.Now()
+	// One batch is taken by the writer and hangs there, two fill the queue, and the
+	// rest are dropped.
+	for i := 0; i < 6; i++ {
+		if err := s.WriteBatch(context.Background(), batch); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if took := time.Since(start); took > time.Second {
+		t.Errorf("writes took %v with a hung shadow", took)
+	}
+	if d := s.Dropped(); d < 3 || d > 4 {
+		t.Errorf("dropped %d batches, want 3 or 4", d)
+	}
+
+	close(shadow.release)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	points, closed := shadow.state()
+	if want := 6 - int(s.Dropped()); points != want || !closed {
+		t.Errorf("shadow got %d points, closed %v; want %d, closed", points, closed, want)
+	}
+}
+
+func TestShadowWriteTimeout(t *testing.T) {
+	shadow := &hungSink{release: make(chan struct{})}
+	s := NewShadowSink(nil, shadow, "hung", 1, 10*time.Millisecond)
+	if err := s.WriteBatch(context.Background(), []Point{{TimeNano: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for s.Errors() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("shadow write never timed out")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	s.Close()
+}
+
+// failingSink fails every write.
+type failingSink struct{}
+
+func (failingSink) WriteBatch(context.Context, []Point) error { return errors.New("down") }
+func (failingSink) Close() error                              { return nil }
+
+func TestShadowReturnsPrimaryErrorOnly(t *testing.T) {
+	s := NewShadowSink(failingSink{}, &hungSink{release: make(chan struct{})}, "hung", 1, time.Millisecond)
+	defer s.Close()
+	if err := s.WriteBatch(context.Background(), []Point{{TimeNano: 1}}); err == nil {
+		t.Error("primary error not returned")
+	}
+	s2 := NewShadowSink(nil, failingSink{}, "failing", 1, time.Second)
+	if err := s2.WriteBatch(context.Background(), []Point{{TimeNano: 1}}); err != nil {
+		t.Errorf("shadow error returned: %v", err)
+	}
+	s2.Close()
+	if s2.Errors() != 1 {
+		t.Errorf("shadow errors = %d, want 1", s2.Errors())
+	}
+}
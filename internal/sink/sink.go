@@ -0,0 +1,27 @@
+package sink
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// DefaultHTTPTimeout bounds each request of the HTTP sinks (InfluxDB, VictoriaMetrics,
+// ClickHouse), so a backend that stops answering fails the write instead of holding
+// the writer, and shutdown, indefinitely.
+const DefaultHTTPTimeout = 10 * time.Second
+
+// newHTTPClient returns a client whose requests time out after timeout, or
+// DefaultHTTPTimeout when timeout is 0. It shares http.DefaultTransport's connections.
+func newHTTPClient(timeout time.Duration) *http.Client {
+	if timeout <= 0 {
+		timeout = DefaultHTTPTimeout
+	}
+	return &http.Client{Timeout: timeout}
+}
+
+// Sink is a storage backend that accepts batches of points.
+type Sink interface {
+	WriteBatch(ctx context.Context, points []Point) error
+	Close() error
+}
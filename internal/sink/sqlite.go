@@ -0,0 +1,106 @@
+package sink
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	_ "modernc.org/sqlite" // pure-Go driver, keeps the server a static binary
+)
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS metrics (
+	time_ns     INTEGER NOT NULL,
+	measurement TEXT    NOT NULL,
+	field       TEXT    NOT NULL,
+	value       REAL    NOT NULL,
+	tags        TEXT    NOT NULL DEFAULT '{}'
+);
+CREATE INDEX IF NOT EXISTS metrics_measurement_time ON metrics (measurement, time_ns);`
+
+// SQLiteSink stores batches in a local SQLite database for setups without a TSDB. Every
+// field becomes one row of the metrics table (time_ns, measurement, field, value, tags),
+// with tags as a JSON object so they can be queried with json_extract. Each batch is
+// one transaction, and the database runs in WAL mode so readers don't block the writer.
+type SQLiteSink struct {
+	mu     sync.Mutex
+	db     *sql.DB
+	insert *sql.Stmt
+}
+
+// NewSQLiteSink opens (or creates) the database at path and its schema.
+func NewSQLiteSink(path string) (*SQLiteSink, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1) // one writer; WAL readers use their own connections
+	for _, stmt := range []string{"PRAGMA journal_mode=WAL", "PRAGMA synchronous=NORMAL", sqliteSchema} {
+		if _, err := db.Exec(stmt); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("sqlite init: %w", err)
+		}
+	}
+	insert, err := db.Prepare(`INSERT INTO metrics (time_ns, measurement, field, value, tags) VALUES (?, ?, ?, ?, ?)`)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &SQLiteSink{db: db, insert: insert}, nil
+}
+
+func (s *SQLiteSink) WriteBatch(ctx context.Context, points []Point) error {
+	if len(points) == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("sqlite begin: %w", err)
+	}
+	defer tx.Rollback() // no-op after Commit
+	insert := tx.StmtContext(ctx, s.insert)
+	for i := range points {
+		p := &points[i]
+		tags, err := tagsJSON(p.Tags)
+		if err != nil {
+			return err
+		}
+		name := p.Name()
+		p.EachField(func(key string, v float64) {
+			if err == nil {
+				_, err = insert.ExecContext(ctx, p.TimeNano, name, key, v, tags)
+			}
+		})
+		if err != nil {
+			return fmt.Errorf("sqlite insert: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("sqlite commit: %w", err)
+	}
+	return nil
+}
+
+// tagsJSON renders tags as a JSON object.
+func tagsJSON(tags []Tag) (string, error) {
+	if len(tags) == 0 {
+		return "{}", nil
+	}
+	m := make(map[string]string, len(tags))
+	for _, t := range tags {
+		m[t.Key] = t.Value
+	}
+	b, err := json.Marshal(m)
+	return string(b), err
+}
+
+func (s *SQLiteSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.insert.Close()
+	return s.db.Close()
+}
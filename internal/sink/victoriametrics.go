@@ -0,0 +1,108 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// VictoriaMetrics import formats.
+const (
+	VMFormatInflux     = "influx"     // line protocol to /write
+	VMFormatPrometheus = "prometheus" // exposition text to /api/v1/import/prometheus
+)
+
+// VictoriaMetricsSink writes batches to a VictoriaMetrics instance. In influx mode VM
+// stores each field as <measurement>_<field> (system_stats_cpu, system_stats_mem, ...);
+// prometheus mode writes those same series names directly.
+type VictoriaMetricsSink struct {
+	url    string
+	format string
+	prec   Precision // influx format only
+	client *http.Client
+
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+// NewVictoriaMetricsSink targets baseURL (e.g. http://victoria:8428) using the given format.
+func NewVictoriaMetricsSink(baseURL, format string, prec Precision) (*VictoriaMetricsSink, error) {
+	baseURL = strings.TrimRight(baseURL, "/")
+	var path string
+	switch format {
+	case VMFormatInflux:
+		path = "/write"
+	case VMFormatPrometheus:
+		path = "/api/v1/import/prometheus"
+	default:
+		return nil, fmt.Errorf("sink: unknown VictoriaMetrics format %q (want influx or prometheus)", format)
+	}
+	return &VictoriaMetricsSink{url: baseURL + path, format: format, prec: prec, client: newHTTPClient(0)}, nil
+}
+
+func (s *VictoriaMetricsSink) WriteBatch(ctx context.Context, points []Point) error {
+	if len(points) == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.buf.Reset()
+	for _, p := range points {
+		if s.format == VMFormatInflux {
+			AppendLineProtocol(&s.buf, p, s.prec)
+		} else {
+			name := p.Name()
+			p.EachField(func(key string, v float64) {
+				appendPrometheus(&s.buf, name+"_"+key, p.Tags, v, p.TimeNano)
+			})
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(s.buf.Bytes()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("victoriametrics write status: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (s *VictoriaMetricsSink) Close() error { return nil }
+
+var promLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// appendPrometheus writes `name{k="v",...} value timestamp_ms`.
+func appendPrometheus(buf *bytes.Buffer, name string, tags []Tag, v float64, tsNano int64) {
+	buf.WriteString(name)
+	if len(tags) > 0 {
+		buf.WriteByte('{')
+		for i, t := range tags {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			buf.WriteString(t.Key)
+			buf.WriteString(`="`)
+			buf.WriteString(promLabelEscaper.Replace(t.Value))
+			buf.WriteByte('"')
+		}
+		buf.WriteByte('}')
+	}
+	var num [32]byte
+	buf.WriteByte(' ')
+	buf.Write(strconv.AppendFloat(num[:0], v, 'g', -1, 64))
+	buf.WriteByte(' ')
+	buf.Write(strconv.AppendInt(num[:0], tsNano/1e6, 10))
+	buf.WriteByte('\n')
+}
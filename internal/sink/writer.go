@@ -0,0 +1,38 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+)
+
+// WriterSink writes each batch as line protocol to an io.Writer in a single Write,
+// e.g. os.Stdout, to pipe the server into another tool or watch what it stores.
+type WriterSink struct {
+	mu   sync.Mutex
+	w    io.Writer
+	prec Precision
+	buf  bytes.Buffer
+}
+
+// NewWriterSink writes to w. Close does not close it.
+func NewWriterSink(w io.Writer, prec Precision) *WriterSink {
+	return &WriterSink{w: w, prec: prec}
+}
+
+func (s *WriterSink) WriteBatch(_ context.Context, points []Point) error {
+	if len(points) == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.buf.Reset()
+	for _, p := range points {
+		AppendLineProtocol(&s.buf, p, s.prec)
+	}
+	_, err := s.w.Write(s.buf.Bytes())
+	return err
+}
+
+func (s *WriterSink) Close() error { return nil }
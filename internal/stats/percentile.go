@@ -0,0 +1,41 @@
+package stats
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// Percentiles holds the latency quantiles we report everywhere (server logs, bench summary).
+type Percentiles struct {
+	P50 time.Duration
+	P90 time.Duration
+	P99 time.Duration
+}
+
+// Summarize sorts a copy of samples and returns its P50/P90/P99.
+func Summarize(samples []time.Duration) Percentiles {
+	sorted := append([]time.Duration(nil), samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return Percentiles{
+		P50: Percentile(sorted, 0.50),
+		P90: Percentile(sorted, 0.90),
+		P99: Percentile(sorted, 0.99),
+	}
+}
+
+// Percentile returns the nearest-rank percentile p (0..1) of an already sorted slice.
+func Percentile(durations []time.Duration, p float64) time.Duration {
+	n := len(durations)
+	if n == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p*float64(n))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= n {
+		rank = n - 1
+	}
+	return durations[rank]
+}
@@ -0,0 +1,49 @@
+package stats
+
+import "time"
+
+// Window is a fixed-capacity sample buffer. Once full, each Add evicts the oldest
+// sample, so memory stays bounded no matter how long it goes between resets.
+type Window struct {
+	buf  []time.Duration
+	next int
+	full bool
+}
+
+// NewWindow returns a Window holding at most capacity samples (minimum 1).
+func NewWindow(capacity int) *Window {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &Window{buf: make([]time.Duration, 0, capacity)}
+}
+
+// Add records d, overwriting the oldest sample when the window is full.
+func (w *Window) Add(d time.Duration) {
+	if !w.full {
+		w.buf = append(w.buf, d)
+		if len(w.buf) == cap(w.buf) {
+			w.full = true
+		}
+		return
+	}
+	w.buf[w.next] = d
+	w.next = (w.next + 1) % len(w.buf)
+}
+
+// Len is the number of samples currently held.
+func (w *Window) Len() int { return len(w.buf) }
+
+// Samples returns a copy of the held samples, oldest first.
+func (w *Window) Samples() []time.Duration {
+	out := make([]time.Duration, 0, len(w.buf))
+	out = append(out, w.buf[w.next:]...)
+	return append(out, w.buf[:w.next]...)
+}
+
+// Reset drops all samples but keeps the allocated capacity.
+func (w *Window) Reset() {
+	w.buf = w.buf[:0]
+	w.next = 0
+	w.full = false
+}
@@ -0,0 +1,105 @@
+// Package tracing sets up OpenTelemetry tracing for the agent and server, exported over
+// OTLP/HTTP, and carries a sample's trace context in its envelope as a W3C traceparent
+// (transport.Metric.TraceParent). The agent starts a trace per sampled sample with the
+// collect span; the server only continues those traces, so the agent's sample ratio
+// decides what is traced end to end.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/buildinfo"
+)
+
+// EndpointEnv is the standard OpenTelemetry variable for the OTLP endpoint, such as
+// http://localhost:4318. The server reads it; the agent's -otlp-endpoint defaults to it.
+const EndpointEnv = "OTEL_EXPORTER_OTLP_ENDPOINT"
+
+const tracesPath = "/v1/traces"
+
+var propagator = propagation.TraceContext{}
+
+// Setup installs a tracer provider exporting to endpoint, the OTLP/HTTP base URL, under
+// the service name service. New traces are sampled at ratio (0 to 1); spans in a trace
+// that an incoming traceparent marks as sampled are always recorded, so the server
+// passes 0 to only continue the agents' traces. The returned function flushes pending
+// spans and must be called before exit. With an empty endpoint tracing stays off:
+// every span is a no-op and Inject returns "".
+func Setup(ctx context.Context, service, endpoint string, ratio float64) (func(context.Context) error, error) {
+	if endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+	if ratio < 0 || ratio > 1 {
+		return nil, fmt.Errorf("sample ratio %g: want 0 to 1", ratio)
+	}
+	exp, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(strings.TrimSuffix(endpoint, "/")+tracesPath))
+	if err != nil {
+		return nil, err
+	}
+	attrs := []attribute.KeyValue{
+		attribute.String("service.name", service),
+		attribute.String("service.version", buildinfo.Get().Version),
+	}
+	if host, err := os.Hostname(); err == nil {
+		attrs = append(attrs, attribute.String("host.name", host))
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(attrs...))
+	if err != nil {
+		return nil, err
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+	)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagator)
+	return tp.Shutdown, nil
+}
+
+// Tracer returns the tracer for spans of the named component ("agent" or "server").
+func Tracer(component string) trace.Tracer {
+	return otel.Tracer("github.com/thomas-sabu-cs/sentinel-stream/" + component)
+}
+
+// Inject returns the traceparent of the span in ctx, or "" when it isn't sampled, so
+// unsampled samples carry nothing extra.
+func Inject(ctx context.Context) string {
+	if !trace.SpanContextFromContext(ctx).IsSampled() {
+		return ""
+	}
+	carrier := propagation.MapCarrier{}
+	propagator.Inject(ctx, carrier)
+	return carrier.Get("traceparent")
+}
+
+// Extract returns ctx with the remote span context from traceparent, and whether that
+// trace is sampled.
+func Extract(ctx context.Context, traceparent string) (context.Context, bool) {
+	if traceparent == "" {
+		return ctx, false
+	}
+	ctx = propagator.Extract(ctx, propagation.MapCarrier{"traceparent": traceparent})
+	return ctx, trace.SpanContextFromContext(ctx).IsSampled()
+}
+
+// End ends span, marking it as failed with err unless err is nil.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
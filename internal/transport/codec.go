@@ -0,0 +1,761 @@
+package transport
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative metricpb/metric.proto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"sync"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/thomas-sabu-cs/sentinel-stream/internal/transport/metricpb"
+)
+
+// Metric is the sample published by the agent and the bench tool and decoded by the server.
+type Metric struct {
+	Timestamp        int64   `json:"timestamp"`
+	CPUUsage         float64 `json:"cpu_usage"`
+	MemUsage         float64 `json:"mem_usage"`
+	SendTimeUnixNano int64   `json:"send_time_unix_nano,omitempty"`
+	// Host and Seq identify a sample so the server can drop duplicates from agent
+	// retries; the server also writes Host as the host tag. The fixed binary layout
+	// does not carry them, the tagged binary frame does.
+	Host string `json:"host,omitempty"`
+	Seq  uint64 `json:"seq,omitempty"`
+	// GPUs and Disks are filled by the agent's optional collectors (not in the binary layout).
+	GPUs  []GPU    `json:"gpus,omitempty"`
+	Disks []DiskIO `json:"disks,omitempty"`
+	// Tags are extra dimensions the server writes as tags on every point from the sample.
+	// Like Host they are in the tagged binary frame but not the fixed layout.
+	Tags map[string]string `json:"tags,omitempty"`
+	// Samples are readings from collectors that have no field of their own above
+	// (not in the binary layouts).
+	Samples []Sample `json:"samples,omitempty"`
+	// TraceParent is the W3C traceparent of the agent's collect span when tracing is
+	// on, so the server's spans join the sample's trace (not in the binary layouts).
+	TraceParent string `json:"traceparent,omitempty"`
+}
+
+// Sample is one reading from an agent collector, written by the server as its own
+// point: Measurement, tagged with Tags as well as the Metric's tags, with Fields.
+type Sample struct {
+	Measurement string             `json:"measurement"`
+	Tags        map[string]string  `json:"tags,omitempty"`
+	Fields      map[string]float64 `json:"fields"`
+}
+
+// GPU is one device's utilization and memory.
+type GPU struct {
+	Index       int     `json:"index"`
+	UtilPercent float64 `json:"util_percent"`
+	MemUsedMB   float64 `json:"mem_used_mb"`
+	MemTotalMB  float64 `json:"mem_total_mb"`
+}
+
+// DiskIO is one block device's read/write throughput and operations since the previous
+// sample, and how busy it was.
+type DiskIO struct {
+	Device           string  `json:"device"`
+	ReadBytesPerSec  float64 `json:"read_bytes_per_sec"`
+	WriteBytesPerSec float64 `json:"write_bytes_per_sec"`
+	ReadsPerSec      float64 `json:"reads_per_sec"`
+	WritesPerSec     float64 `json:"writes_per_sec"`
+	BusyPercent      float64 `json:"busy_percent"`
+}
+
+// Wire formats selectable with -format.
+const (
+	FormatJSON    = "json"
+	FormatBinary  = "binary"
+	FormatProto   = "proto"
+	FormatMsgpack = "msgpack"
+)
+
+// Formats lists every wire format, in the order stats are reported.
+var Formats = []string{FormatBinary, FormatJSON, FormatProto, FormatMsgpack}
+
+// BinarySize is the length of the fixed little-endian binary layout:
+// timestamp, cpu, mem, send time (8 bytes each).
+const BinarySize = 32
+
+// Every encoder wraps its payload in an envelope: the magic bytes frameMagic and
+// frameMagic2, the envelope version, a codec byte naming the format of the body, and a
+// flags byte. Frames from agents that predate the envelope carry only frameMagic and
+// the codec byte, which is never frameMagic2, and are still read. Bare JSON from those
+// agents and the unframed binary layout of even older ones carry no header at all.
+const (
+	frameMagic      byte = 0xA7
+	frameMagic2     byte = 0x5E
+	envelopeVersion byte = 1
+
+	formatProto   byte = 0x01
+	formatMsgpack byte = 0x02
+	formatBatch   byte = 0x03 // followed by N fixed binary records
+	formatCompact byte = 0x04 // binary layout without the send time
+	formatBinary  byte = 0x05 // one fixed binary record
+	formatTagged  byte = 0x06 // compact record plus send time, seq, host and tags
+	formatMulti   byte = 0x07 // followed by N length-prefixed payloads in any other format
+	formatJSON    byte = 0x08 // a JSON object
+
+	frameHeaderSize    = 5
+	oldFrameHeaderSize = 2 // frameMagic and the codec byte, before the envelope
+)
+
+// BinaryFrameSize is the length of a framed binary sample: the header plus one record.
+const BinaryFrameSize = frameHeaderSize + BinarySize
+
+// ErrNotProtoFrame is returned by DecodeProto when the payload lacks the proto frame header.
+var ErrNotProtoFrame = errors.New("transport: payload is not a proto frame")
+
+// ErrBadBatchFrame is returned by BinaryBatchRecords for a truncated or empty batch frame.
+var ErrBadBatchFrame = errors.New("transport: batch frame is not a whole number of binary records")
+
+// ErrNotMsgpackFrame is returned by DecodeMsgpack when the payload lacks the msgpack frame header.
+var ErrNotMsgpackFrame = errors.New("transport: payload is not a msgpack frame")
+
+// ErrNotBinary is returned by DecodeMetricAs for a binary payload of the wrong length.
+var ErrNotBinary = errors.New("transport: payload is not a binary record")
+
+// ErrUnknownVersion is returned by DecodeMetric for an envelope version this build
+// doesn't know, e.g. from a newer agent.
+var ErrUnknownVersion = errors.New("transport: unknown frame format version")
+
+// ErrUnknownCodec is returned by DecodeMetric for a frame whose codec byte this build
+// doesn't know.
+var ErrUnknownCodec = errors.New("transport: unknown frame codec")
+
+// ErrUnknownFlags is returned by DecodeMetric for an envelope with flags set. None are
+// defined yet, so such a frame needs a newer build to read it.
+var ErrUnknownFlags = errors.New("transport: unknown frame flags")
+
+// ErrTruncatedFrame is returned by DecodeMetric when a fixed-size frame has the wrong
+// length for its declared format.
+var ErrTruncatedFrame = errors.New("transport: frame length does not match its format")
+
+// ErrBatchFrame is returned by DecodeMetric for a batch frame, which holds several
+// metrics; split it with BinaryBatchRecords.
+var ErrBatchFrame = errors.New("transport: batch frame holds several metrics")
+
+// ErrMultiFrame is returned by DecodeMetric for a multi frame, which holds several
+// payloads; split it with MultiFramePayloads.
+var ErrMultiFrame = errors.New("transport: multi frame holds several payloads")
+
+// ErrBadMultiFrame is returned by MultiFramePayloads for a truncated, empty or nested
+// multi frame.
+var ErrBadMultiFrame = errors.New("transport: malformed multi frame")
+
+// errNotFramed is returned by parseFrame for a payload without frameMagic.
+var errNotFramed = errors.New("transport: payload is not framed")
+
+// appendHeader appends the envelope header for a body in the given codec.
+func appendHeader(buf []byte, codec byte) []byte {
+	return append(buf, frameMagic, frameMagic2, envelopeVersion, codec, 0)
+}
+
+// parseFrame splits payload into its codec byte and body. It reads the envelope and
+// the older 2-byte header, and fails for a header that is cut short or has an unknown
+// version or flags. The codec byte is left to the caller.
+func parseFrame(payload []byte) (codec byte, body []byte, err error) {
+	switch {
+	case len(payload) == 0 || payload[0] != frameMagic:
+		return 0, nil, errNotFramed
+	case len(payload) < oldFrameHeaderSize:
+		return 0, nil, ErrTruncatedFrame
+	case payload[1] != frameMagic2:
+		return payload[1], payload[oldFrameHeaderSize:], nil
+	case len(payload) < frameHeaderSize:
+		return 0, nil, ErrTruncatedFrame
+	case payload[2] != envelopeVersion:
+		return 0, nil, fmt.Errorf("%w: %d", ErrUnknownVersion, payload[2])
+	case payload[4] != 0:
+		return 0, nil, fmt.Errorf("%w: 0x%02x", ErrUnknownFlags, payload[4])
+	}
+	return payload[3], payload[frameHeaderSize:], nil
+}
+
+// frameBody returns the body of payload if it is a frame in the given codec.
+func frameBody(payload []byte, codec byte) ([]byte, bool) {
+	c, body, err := parseFrame(payload)
+	return body, err == nil && c == codec
+}
+
+// Encoder turns a Metric into a wire payload.
+type Encoder interface {
+	Encode(m *Metric) ([]byte, error)
+}
+
+// NewEncoder returns the Encoder for the given -format value.
+func NewEncoder(format string) (Encoder, error) {
+	switch format {
+	case FormatJSON:
+		return JSONEncoder{}, nil
+	case FormatBinary:
+		return BinaryEncoder{}, nil
+	case FormatProto:
+		return ProtoEncoder{}, nil
+	case FormatMsgpack:
+		return MsgpackEncoder{}, nil
+	default:
+		return nil, fmt.Errorf("transport: unknown format %q (want json, binary, proto or msgpack)", format)
+	}
+}
+
+// JSONEncoder encodes metrics as JSON objects in the envelope. With Bare it writes the
+// object alone, which servers from before the envelope also read. It uses jsoniter,
+// like the server's decoder, which measured faster than encoding/json on both sides
+// (see BenchmarkJSON).
+type JSONEncoder struct {
+	Bare bool
+}
+
+func (e JSONEncoder) Encode(m *Metric) ([]byte, error) {
+	obj, err := jsoniter.Marshal(m)
+	if err != nil || e.Bare {
+		return obj, err
+	}
+	buf := appendHeader(make([]byte, 0, frameHeaderSize+len(obj)), formatJSON)
+	return append(buf, obj...), nil
+}
+
+// compactRecordSize is the length of the compact layout: timestamp, cpu and mem.
+const compactRecordSize = 24
+
+// CompactBinarySize is the length of a compact binary frame: the header, then
+// timestamp, cpu and mem as in the fixed layout, with no send time.
+const CompactBinarySize = frameHeaderSize + compactRecordSize
+
+// BinaryEncoder encodes metrics as a framed fixed binary record (BinaryFrameSize bytes).
+// With Compact it drops the send time and writes a CompactBinarySize frame instead.
+// With Tagged it writes a tagged frame (see EncodeTaggedBinary), which also carries
+// Host, Seq and Tags; Compact then only leaves the send time out of it.
+type BinaryEncoder struct {
+	Compact bool
+	Tagged  bool
+}
+
+func (e BinaryEncoder) Encode(m *Metric) ([]byte, error) {
+	if e.Tagged {
+		if e.Compact && m.SendTimeUnixNano != 0 {
+			c := *m
+			c.SendTimeUnixNano = 0
+			m = &c
+		}
+		return EncodeTaggedBinary(m), nil
+	}
+	if e.Compact {
+		buf := appendHeader(make([]byte, 0, CompactBinarySize), formatCompact)
+		return appendCompactRecord(buf, m), nil
+	}
+	return EncodeMetric(m), nil
+}
+
+// EncodeMetric encodes m as a framed binary record: the header followed by the fixed
+// layout. It is what agents and the bench send with -format=binary.
+func EncodeMetric(m *Metric) []byte {
+	buf := appendHeader(make([]byte, 0, BinaryFrameSize), formatBinary)
+	return appendBinaryRecord(buf, m)
+}
+
+// appendCompactRecord appends timestamp, cpu and mem in the fixed little-endian layout.
+func appendCompactRecord(buf []byte, m *Metric) []byte {
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(m.Timestamp))
+	buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(m.CPUUsage))
+	return binary.LittleEndian.AppendUint64(buf, math.Float64bits(m.MemUsage))
+}
+
+// appendBinaryRecord appends m in the fixed little-endian layout.
+func appendBinaryRecord(buf []byte, m *Metric) []byte {
+	return binary.LittleEndian.AppendUint64(appendCompactRecord(buf, m), uint64(m.SendTimeUnixNano))
+}
+
+// IsBinaryFrame reports whether payload is a framed binary record.
+func IsBinaryFrame(payload []byte) bool {
+	_, ok := BinaryFrameRecord(payload)
+	return ok
+}
+
+// BinaryFrameRecord returns the BinarySize record in a framed binary record, without
+// copying, and whether payload is one.
+func BinaryFrameRecord(payload []byte) ([]byte, bool) {
+	rec, ok := frameBody(payload, formatBinary)
+	return rec, ok && len(rec) == BinarySize
+}
+
+// IsLegacyBinary reports whether payload looks like an unframed binary record from an
+// agent that predates framing. Only BinarySize payloads can be one. A payload of that
+// length that starts like a known frame isn't, and neither is one that starts with '{':
+// that is JSON, and if it doesn't parse (a truncated message, say) it must fail as JSON
+// rather than decode as numbers. DecodeMetric never guesses at these; the server reads
+// them only when started with -legacy-binary, or -input-format=binary.
+func IsLegacyBinary(payload []byte) bool {
+	return len(payload) == BinarySize && payload[0] != '{' && !isKnownFrame(payload)
+}
+
+func isKnownFrame(payload []byte) bool {
+	codec, _, err := parseFrame(payload)
+	if err != nil {
+		return err != errNotFramed && err != ErrTruncatedFrame
+	}
+	return knownCodec(codec)
+}
+
+func knownCodec(codec byte) bool {
+	return codec >= formatProto && codec <= formatJSON
+}
+
+// DecodeBinary fills m from a BinarySize record (unframed). The caller checks the length.
+func DecodeBinary(payload []byte, m *Metric) {
+	m.Timestamp = int64(binary.LittleEndian.Uint64(payload[0:8]))
+	m.CPUUsage = math.Float64frombits(binary.LittleEndian.Uint64(payload[8:16]))
+	m.MemUsage = math.Float64frombits(binary.LittleEndian.Uint64(payload[16:24]))
+	m.SendTimeUnixNano = int64(binary.LittleEndian.Uint64(payload[24:32]))
+}
+
+// IsCompactBinaryFrame reports whether payload is a compact binary frame.
+func IsCompactBinaryFrame(payload []byte) bool {
+	rec, ok := frameBody(payload, formatCompact)
+	return ok && len(rec) == compactRecordSize
+}
+
+// DecodeCompactBinary fills m from a compact binary frame; SendTimeUnixNano is left 0.
+// The caller checks IsCompactBinaryFrame.
+func DecodeCompactBinary(payload []byte, m *Metric) {
+	rec, _ := frameBody(payload, formatCompact)
+	decodeCompactRecord(rec, m)
+}
+
+func decodeCompactRecord(rec []byte, m *Metric) {
+	*m = Metric{}
+	m.Timestamp = int64(binary.LittleEndian.Uint64(rec[0:8]))
+	m.CPUUsage = math.Float64frombits(binary.LittleEndian.Uint64(rec[8:16]))
+	m.MemUsage = math.Float64frombits(binary.LittleEndian.Uint64(rec[16:24]))
+}
+
+// EncodeTaggedBinary encodes m as a tagged binary frame: the header; timestamp, cpu and
+// mem as in the fixed layout; then the send time (0 when unset) and Seq as uvarints;
+// Host as a uvarint length and its bytes; and the number of tags as a uvarint followed
+// by each key and value, length-prefixed like Host and sorted by key. Without send
+// time, seq, host or tags that is 33 bytes.
+func EncodeTaggedBinary(m *Metric) []byte {
+	n := frameHeaderSize + compactRecordSize + 3*binary.MaxVarintLen64 + len(m.Host)
+	keys := make([]string, 0, len(m.Tags))
+	for k, v := range m.Tags {
+		keys = append(keys, k)
+		n += 2*binary.MaxVarintLen64 + len(k) + len(v)
+	}
+	slices.Sort(keys)
+	buf := appendHeader(make([]byte, 0, n+binary.MaxVarintLen64), formatTagged)
+	buf = appendCompactRecord(buf, m)
+	buf = binary.AppendUvarint(buf, uint64(m.SendTimeUnixNano))
+	buf = binary.AppendUvarint(buf, m.Seq)
+	buf = appendString(buf, m.Host)
+	buf = binary.AppendUvarint(buf, uint64(len(keys)))
+	for _, k := range keys {
+		buf = appendString(appendString(buf, k), m.Tags[k])
+	}
+	return buf
+}
+
+func appendString(buf []byte, s string) []byte {
+	return append(binary.AppendUvarint(buf, uint64(len(s))), s...)
+}
+
+// IsTaggedBinaryFrame reports whether payload carries the tagged binary frame header.
+func IsTaggedBinaryFrame(payload []byte) bool {
+	_, ok := frameBody(payload, formatTagged)
+	return ok
+}
+
+// DecodeTaggedBinary fills m from a frame produced by EncodeTaggedBinary. A frame that
+// ends early or has bytes left over is ErrTruncatedFrame.
+func DecodeTaggedBinary(payload []byte, m *Metric) error {
+	body, ok := frameBody(payload, formatTagged)
+	if !ok {
+		*m = Metric{}
+		return ErrTruncatedFrame
+	}
+	return decodeTaggedBody(body, m)
+}
+
+func decodeTaggedBody(body []byte, m *Metric) error {
+	if len(body) < compactRecordSize {
+		*m = Metric{}
+		return ErrTruncatedFrame
+	}
+	decodeCompactRecord(body, m)
+	r := taggedReader{buf: body[compactRecordSize:]}
+	m.SendTimeUnixNano = int64(r.uvarint())
+	m.Seq = r.uvarint()
+	m.Host = r.string()
+	n := r.uvarint()
+	if n > uint64(len(r.buf)) { // each tag takes at least 2 bytes; don't trust n for the allocation
+		return ErrTruncatedFrame
+	}
+	if n > 0 {
+		m.Tags = make(map[string]string, n)
+		for i := uint64(0); i < n && !r.bad; i++ {
+			k := r.string()
+			m.Tags[k] = r.string()
+		}
+	}
+	if r.bad || len(r.buf) != 0 {
+		return ErrTruncatedFrame
+	}
+	return nil
+}
+
+// taggedReader reads the variable part of a tagged frame. After the first short read
+// bad is set and every read returns zero.
+type taggedReader struct {
+	buf []byte
+	bad bool
+}
+
+func (r *taggedReader) uvarint() uint64 {
+	if r.bad {
+		return 0
+	}
+	v, n := binary.Uvarint(r.buf)
+	if n <= 0 {
+		r.bad = true
+		return 0
+	}
+	r.buf = r.buf[n:]
+	return v
+}
+
+func (r *taggedReader) string() string {
+	n := r.uvarint()
+	if r.bad || n > uint64(len(r.buf)) {
+		r.bad = true
+		return ""
+	}
+	s := string(r.buf[:n])
+	r.buf = r.buf[n:]
+	return s
+}
+
+// EncodeBinaryBatch packs ms into one frame of fixed binary records, so a single
+// PUBLISH carries many samples.
+func EncodeBinaryBatch(ms []*Metric) []byte {
+	buf := appendHeader(make([]byte, 0, frameHeaderSize+len(ms)*BinarySize), formatBatch)
+	for _, m := range ms {
+		buf = appendBinaryRecord(buf, m)
+	}
+	return buf
+}
+
+// IsBinaryBatchFrame reports whether payload carries the binary batch frame header.
+func IsBinaryBatchFrame(payload []byte) bool {
+	_, ok := frameBody(payload, formatBatch)
+	return ok
+}
+
+// BinaryBatchRecords returns the BinarySize records in a batch frame, without copying.
+func BinaryBatchRecords(payload []byte) ([]byte, int, error) {
+	recs, ok := frameBody(payload, formatBatch)
+	if !ok || len(recs) == 0 || len(recs)%BinarySize != 0 {
+		return nil, 0, ErrBadBatchFrame
+	}
+	return recs, len(recs) / BinarySize, nil
+}
+
+// EncodeMultiFrame packs already encoded payloads, in any format but another multi
+// frame, into one frame: each is written as a uvarint length and its bytes. It is how
+// agents batch samples that carry more than the fixed binary record (host, tags,
+// collector samples) into a single publish.
+func EncodeMultiFrame(payloads [][]byte) []byte {
+	size := frameHeaderSize
+	for _, p := range payloads {
+		size += binary.MaxVarintLen64 + len(p)
+	}
+	buf := appendHeader(make([]byte, 0, size), formatMulti)
+	for _, p := range payloads {
+		buf = binary.AppendUvarint(buf, uint64(len(p)))
+		buf = append(buf, p...)
+	}
+	return buf
+}
+
+// IsMultiFrame reports whether payload carries the multi frame header.
+func IsMultiFrame(payload []byte) bool {
+	_, ok := frameBody(payload, formatMulti)
+	return ok
+}
+
+// MultiFramePayloads returns the payloads in a multi frame, without copying.
+func MultiFramePayloads(payload []byte) ([][]byte, error) {
+	rest, ok := frameBody(payload, formatMulti)
+	if !ok {
+		return nil, ErrBadMultiFrame
+	}
+	var out [][]byte
+	for len(rest) > 0 {
+		n, k := binary.Uvarint(rest)
+		if k <= 0 || n > uint64(len(rest)-k) {
+			return nil, ErrBadMultiFrame
+		}
+		p := rest[k : k+int(n)]
+		if IsMultiFrame(p) {
+			return nil, ErrBadMultiFrame
+		}
+		out = append(out, p)
+		rest = rest[k+int(n):]
+	}
+	if len(out) == 0 {
+		return nil, ErrBadMultiFrame
+	}
+	return out, nil
+}
+
+// ProtoEncoder encodes metrics as a framed metricpb.Metric.
+type ProtoEncoder struct{}
+
+func (ProtoEncoder) Encode(m *Metric) ([]byte, error) {
+	pm := &metricpb.Metric{
+		Timestamp: m.Timestamp,
+		CpuUsage:  m.CPUUsage,
+		MemUsage:  m.MemUsage,
+	}
+	if m.SendTimeUnixNano != 0 {
+		pm.SendTimeUnixNano = proto.Int64(m.SendTimeUnixNano)
+	}
+	if m.Host != "" {
+		pm.Host = proto.String(m.Host)
+	}
+	if m.Seq != 0 {
+		pm.Seq = proto.Uint64(m.Seq)
+	}
+	if m.TraceParent != "" {
+		pm.Traceparent = proto.String(m.TraceParent)
+	}
+	for _, g := range m.GPUs {
+		pm.Gpus = append(pm.Gpus, &metricpb.Gpu{
+			Index:       uint32(g.Index),
+			UtilPercent: g.UtilPercent,
+			MemUsedMb:   g.MemUsedMB,
+			MemTotalMb:  g.MemTotalMB,
+		})
+	}
+	for _, d := range m.Disks {
+		pm.Disks = append(pm.Disks, &metricpb.DiskIo{
+			Device:           d.Device,
+			ReadBytesPerSec:  d.ReadBytesPerSec,
+			WriteBytesPerSec: d.WriteBytesPerSec,
+			ReadsPerSec:      d.ReadsPerSec,
+			WritesPerSec:     d.WritesPerSec,
+			BusyPercent:      d.BusyPercent,
+		})
+	}
+	pm.Tags = m.Tags
+	for _, smp := range m.Samples {
+		pm.Samples = append(pm.Samples, &metricpb.Sample{Measurement: smp.Measurement, Tags: smp.Tags, Fields: smp.Fields})
+	}
+	buf := appendHeader(make([]byte, 0, frameHeaderSize+proto.Size(pm)), formatProto)
+	return proto.MarshalOptions{}.MarshalAppend(buf, pm)
+}
+
+var protoPool = sync.Pool{
+	New: func() interface{} { return &metricpb.Metric{} },
+}
+
+// IsProtoFrame reports whether payload carries the proto frame header.
+func IsProtoFrame(payload []byte) bool {
+	_, ok := frameBody(payload, formatProto)
+	return ok
+}
+
+// DecodeProto fills m from a frame produced by ProtoEncoder.
+func DecodeProto(payload []byte, m *Metric) error {
+	body, ok := frameBody(payload, formatProto)
+	if !ok {
+		return ErrNotProtoFrame
+	}
+	return decodeProtoBody(body, m)
+}
+
+func decodeProtoBody(body []byte, m *Metric) error {
+	pm := protoPool.Get().(*metricpb.Metric)
+	defer protoPool.Put(pm)
+	pm.Reset()
+	if err := proto.Unmarshal(body, pm); err != nil {
+		return err
+	}
+	*m = Metric{GPUs: m.GPUs[:0], Disks: m.Disks[:0], Samples: m.Samples[:0]} // keep the slices for reuse
+	m.Timestamp = pm.GetTimestamp()
+	m.CPUUsage = pm.GetCpuUsage()
+	m.MemUsage = pm.GetMemUsage()
+	m.SendTimeUnixNano = pm.GetSendTimeUnixNano()
+	m.Host = pm.GetHost()
+	m.Seq = pm.GetSeq()
+	m.TraceParent = pm.GetTraceparent()
+	for _, g := range pm.GetGpus() {
+		m.GPUs = append(m.GPUs, GPU{
+			Index:       int(g.GetIndex()),
+			UtilPercent: g.GetUtilPercent(),
+			MemUsedMB:   g.GetMemUsedMb(),
+			MemTotalMB:  g.GetMemTotalMb(),
+		})
+	}
+	for _, d := range pm.GetDisks() {
+		m.Disks = append(m.Disks, DiskIO{
+			Device:           d.GetDevice(),
+			ReadBytesPerSec:  d.GetReadBytesPerSec(),
+			WriteBytesPerSec: d.GetWriteBytesPerSec(),
+			ReadsPerSec:      d.GetReadsPerSec(),
+			WritesPerSec:     d.GetWritesPerSec(),
+			BusyPercent:      d.GetBusyPercent(),
+		})
+	}
+	if len(pm.GetTags()) > 0 {
+		m.Tags = pm.GetTags() // pm is reset before reuse, which replaces its map
+	}
+	for _, smp := range pm.GetSamples() {
+		m.Samples = append(m.Samples, Sample{Measurement: smp.GetMeasurement(), Tags: smp.GetTags(), Fields: smp.GetFields()})
+	}
+	return nil
+}
+
+// MsgpackEncoder encodes metrics as a framed msgpack map keyed by the JSON field
+// names, so fields can be added without codegen or a layout change.
+type MsgpackEncoder struct{}
+
+func (MsgpackEncoder) Encode(m *Metric) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Write(appendHeader(nil, formatMsgpack))
+	enc := msgpack.GetEncoder()
+	defer msgpack.PutEncoder(enc)
+	enc.Reset(&buf)
+	enc.SetCustomStructTag("json")
+	enc.UseCompactInts(true)
+	if err := enc.Encode(m); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// IsMsgpackFrame reports whether payload carries the msgpack frame header.
+func IsMsgpackFrame(payload []byte) bool {
+	_, ok := frameBody(payload, formatMsgpack)
+	return ok
+}
+
+// DecodeMsgpack fills m from a frame produced by MsgpackEncoder.
+func DecodeMsgpack(payload []byte, m *Metric) error {
+	body, ok := frameBody(payload, formatMsgpack)
+	if !ok {
+		return ErrNotMsgpackFrame
+	}
+	return decodeMsgpackBody(body, m)
+}
+
+func decodeMsgpackBody(body []byte, m *Metric) error {
+	*m = Metric{}
+	dec := msgpack.GetDecoder()
+	defer msgpack.PutDecoder(dec)
+	dec.Reset(bytes.NewReader(body))
+	dec.SetCustomStructTag("json")
+	return dec.Decode(m)
+}
+
+// DecodeMetric fills m from a payload in any supported wire format and returns the
+// format it detected. Framed payloads dispatch on their codec byte, with fixed-size
+// frames checked for length; an unknown version, codec or flags is an error rather
+// than a guess. Payloads without the magic byte are bare JSON. Unframed binary records
+// from agents that predate framing are not detected here (see IsLegacyBinary), so they
+// fail as JSON. It is the server's decode path.
+func DecodeMetric(payload []byte, m *Metric) (string, error) {
+	codec, body, err := parseFrame(payload)
+	switch {
+	case err == errNotFramed:
+		return FormatJSON, decodeJSON(payload, m)
+	case err != nil:
+		return "", err
+	}
+	switch codec {
+	case formatBinary:
+		if len(body) != BinarySize {
+			return FormatBinary, ErrTruncatedFrame
+		}
+		*m = Metric{}
+		DecodeBinary(body, m)
+		return FormatBinary, nil
+	case formatCompact:
+		if len(body) != compactRecordSize {
+			return FormatBinary, ErrTruncatedFrame
+		}
+		decodeCompactRecord(body, m)
+		return FormatBinary, nil
+	case formatTagged:
+		return FormatBinary, decodeTaggedBody(body, m)
+	case formatProto:
+		if err := decodeProtoBody(body, m); err != nil {
+			return FormatProto, fmt.Errorf("proto: %w", err)
+		}
+		return FormatProto, nil
+	case formatMsgpack:
+		if err := decodeMsgpackBody(body, m); err != nil {
+			return FormatMsgpack, fmt.Errorf("msgpack: %w", err)
+		}
+		return FormatMsgpack, nil
+	case formatJSON:
+		return FormatJSON, decodeJSON(body, m)
+	case formatBatch:
+		return FormatBinary, ErrBatchFrame
+	case formatMulti:
+		return "", ErrMultiFrame
+	default:
+		return "", fmt.Errorf("%w: 0x%02x", ErrUnknownCodec, codec)
+	}
+}
+
+func decodeJSON(obj []byte, m *Metric) error {
+	*m = Metric{}
+	if err := jsoniter.Unmarshal(obj, m); err != nil {
+		return fmt.Errorf("json: %w", err)
+	}
+	return nil
+}
+
+// DecodeMetricAs fills m from a payload that must be in the given format. Unlike
+// DecodeMetric nothing is detected, so a payload in any other format is an error
+// rather than being decoded as whatever it happens to look like.
+func DecodeMetricAs(format string, payload []byte, m *Metric) error {
+	switch format {
+	case FormatBinary:
+		*m = Metric{}
+		codec, body, err := parseFrame(payload)
+		switch {
+		case err == nil && codec == formatBinary && len(body) == BinarySize:
+			DecodeBinary(body, m)
+		case err == nil && codec == formatCompact && len(body) == compactRecordSize:
+			decodeCompactRecord(body, m)
+		case err == nil && codec == formatTagged:
+			return decodeTaggedBody(body, m)
+		case len(payload) == BinarySize: // unframed, from an agent that predates framing
+			DecodeBinary(payload, m)
+		default:
+			return ErrNotBinary
+		}
+		return nil
+	case FormatProto:
+		return DecodeProto(payload, m)
+	case FormatMsgpack:
+		return DecodeMsgpack(payload, m)
+	case FormatJSON:
+		if obj, ok := frameBody(payload, formatJSON); ok {
+			payload = obj
+		}
+		*m = Metric{}
+		return jsoniter.Unmarshal(payload, m)
+	default:
+		return fmt.Errorf("transport: unknown format %q", format)
+	}
+}
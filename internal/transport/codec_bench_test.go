@@ -0,0 +1,131 @@
+package transport
+
+import (
+	"encoding/json"
+	"testing"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+// Codec micro-benchmarks: encode and decode in isolation, no broker or network. Run
+// them before and after touching the parsing code:
+//
+//	go test ./internal/transport -run '^$' -bench . -benchmem
+
+var benchSample = Metric{
+	Timestamp:        1740520000,
+	CPUUsage:         37.512345,
+	MemUsage:         61.204871,
+	SendTimeUnixNano: 1740520000123456789,
+}
+
+// BenchmarkJSON compares encoding/json with jsoniter, which agent, bench and server use.
+func BenchmarkJSON(b *testing.B) {
+	payload, err := json.Marshal(&benchSample)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Run("decode/encoding-json", func(b *testing.B) {
+		b.ReportAllocs()
+		var m Metric
+		for i := 0; i < b.N; i++ {
+			if err := json.Unmarshal(payload, &m); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("decode/jsoniter", func(b *testing.B) {
+		b.ReportAllocs()
+		var m Metric
+		for i := 0; i < b.N; i++ {
+			if err := jsoniter.Unmarshal(payload, &m); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("encode/encoding-json", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := json.Marshal(&benchSample); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("encode/jsoniter", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := jsoniter.Marshal(&benchSample); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// benchPayloads encodes benchSample in every wire format.
+func benchPayloads(b *testing.B) map[string][]byte {
+	payloads := make(map[string][]byte)
+	for _, format := range Formats {
+		enc, err := NewEncoder(format)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if payloads[format], err = enc.Encode(&benchSample); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return payloads
+}
+
+// BenchmarkEncode is the agent's and bench's encode cost per wire format.
+func BenchmarkEncode(b *testing.B) {
+	for _, format := range Formats {
+		enc, err := NewEncoder(format)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(format, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := enc.Encode(&benchSample); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkDecodeMetric is the server's decode path, format detection included, for
+// each wire format. payload-bytes is the message size.
+func BenchmarkDecodeMetric(b *testing.B) {
+	payloads := benchPayloads(b)
+	for _, format := range Formats {
+		payload := payloads[format]
+		b.Run(format, func(b *testing.B) {
+			b.ReportAllocs()
+			b.ReportMetric(float64(len(payload)), "payload-bytes")
+			var m Metric
+			for i := 0; i < b.N; i++ {
+				if _, err := DecodeMetric(payload, &m); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkDecodeMetricAs is the same with -input-format set, which skips detection.
+func BenchmarkDecodeMetricAs(b *testing.B) {
+	payloads := benchPayloads(b)
+	for _, format := range Formats {
+		payload := payloads[format]
+		b.Run(format, func(b *testing.B) {
+			b.ReportAllocs()
+			var m Metric
+			for i := 0; i < b.N; i++ {
+				if err := DecodeMetricAs(format, payload, &m); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
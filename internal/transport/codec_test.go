@@ -0,0 +1,246 @@
+package transport
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func fullMetric() *Metric {
+	return &Metric{
+		Timestamp:        1_700_000_000_000_000_000,
+		CPUUsage:         42.5,
+		MemUsage:         61.25,
+		SendTimeUnixNano: 1_700_000_000_000_500_000,
+		Host:             "web-1",
+		Seq:              7,
+		GPUs:             []GPU{{Index: 0, UtilPercent: 90, MemUsedMB: 1024, MemTotalMB: 8192}},
+		Disks:            []DiskIO{{Device: "sda", ReadBytesPerSec: 4096, WritesPerSec: 3, BusyPercent: 12.5}},
+		Tags:             map[string]string{"env": "prod", "role": "web"},
+		Samples:          []Sample{{Measurement: "net", Tags: map[string]string{"iface": "eth0"}, Fields: map[string]float64{"rx_bytes": 10}}},
+		TraceParent:      "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01",
+	}
+}
+
+// binaryFields is the part of m the fixed binary layout carries.
+func binaryFields(m *Metric) Metric {
+	return Metric{Timestamp: m.Timestamp, CPUUsage: m.CPUUsage, MemUsage: m.MemUsage, SendTimeUnixNano: m.SendTimeUnixNano}
+}
+
+func TestDecodeMetricRoundTrip(t *testing.T) {
+	full := fullMetric()
+	tagged := Metric{
+		Timestamp: full.Timestamp, CPUUsage: full.CPUUsage, MemUsage: full.MemUsage,
+		SendTimeUnixNano: full.SendTimeUnixNano, Host: full.Host, Seq: full.Seq, Tags: full.Tags,
+	}
+	encode := func(enc Encoder) []byte {
+		p, err := enc.Encode(full)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	preEnvelope := append([]byte{frameMagic, formatBinary}, appendBinaryRecord(nil, full)...)
+	tests := []struct {
+		name    string
+		payload []byte
+		format  string
+		want    Metric
+	}{
+		{"json", encode(JSONEncoder{}), FormatJSON, *full},
+		{"bare json", encode(JSONEncoder{Bare: true}), FormatJSON, *full},
+		{"proto", encode(ProtoEncoder{}), FormatProto, *full},
+		{"msgpack", encode(MsgpackEncoder{}), FormatMsgpack, *full},
+		{"binary frame", EncodeMetric(full), FormatBinary, binaryFields(full)},
+		{"compact binary", encode(BinaryEncoder{Compact: true}), FormatBinary, Metric{Timestamp: full.Timestamp, CPUUsage: full.CPUUsage, MemUsage: full.MemUsage}},
+		{"tagged binary", EncodeTaggedBinary(full), FormatBinary, tagged},
+		{"binary frame without envelope", preEnvelope, FormatBinary, binaryFields(full)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Decode over a used Metric: nothing from it may survive.
+			m := *fullMetric()
+			m.Host, m.Seq = "stale", 99
+			format, err := DecodeMetric(tt.payload, &m)
+			if err != nil {
+				t.Fatalf("DecodeMetric: %v", err)
+			}
+			if format != tt.format {
+				t.Errorf("format = %q, want %q", format, tt.format)
+			}
+			if !reflect.DeepEqual(m, tt.want) {
+				t.Errorf("decoded %+v\nwant    %+v", m, tt.want)
+			}
+
+			var as Metric
+			if err := DecodeMetricAs(tt.format, tt.payload, &as); err != nil {
+				t.Fatalf("DecodeMetricAs: %v", err)
+			}
+			if !reflect.DeepEqual(as, tt.want) {
+				t.Errorf("DecodeMetricAs decoded %+v\nwant    %+v", as, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeMetricErrors(t *testing.T) {
+	frame := EncodeMetric(fullMetric())
+	tagged := EncodeTaggedBinary(fullMetric())
+	jsonFrame, err := JSONEncoder{}.Encode(fullMetric())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		payload []byte
+		want    error
+	}{
+		{"binary frame truncated to 32 bytes", frame[:BinarySize], ErrTruncatedFrame},
+		{"JSON truncated to 32 bytes", []byte(`{"timestamp":1700000000,"cpu_usa`), nil},
+		{"binary frame with a byte too many", append(frame[:len(frame):len(frame)], 0), ErrTruncatedFrame},
+		{"compact frame truncated", appendHeader(nil, formatCompact), ErrTruncatedFrame},
+		{"tagged frame truncated", tagged[:len(tagged)-1], ErrTruncatedFrame},
+		{"magic only", []byte{frameMagic}, ErrTruncatedFrame},
+		{"envelope truncated", []byte{frameMagic, frameMagic2, envelopeVersion, formatBinary}, ErrTruncatedFrame},
+		{"unknown version", []byte{frameMagic, frameMagic2, 0x7f, formatBinary, 0}, ErrUnknownVersion},
+		{"unknown version on a whole frame", withHeaderByte(frame, 2, 0x7f), ErrUnknownVersion},
+		{"unknown codec", []byte{frameMagic, frameMagic2, envelopeVersion, 0x7f, 0}, ErrUnknownCodec},
+		{"unknown codec without envelope", []byte{frameMagic, 0x7f, 1, 2, 3}, ErrUnknownCodec},
+		{"flags set", []byte{frameMagic, frameMagic2, envelopeVersion, formatBinary, 0x01}, ErrUnknownFlags},
+		{"flags set on a whole frame", withHeaderByte(frame, 4, 0x80), ErrUnknownFlags},
+		{"flags set on JSON", withHeaderByte(jsonFrame, 4, 0x01), ErrUnknownFlags},
+		{"batch frame", EncodeBinaryBatch([]*Metric{fullMetric(), fullMetric()}), ErrBatchFrame},
+		{"multi frame", EncodeMultiFrame([][]byte{frame}), ErrMultiFrame},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var m Metric
+			format, err := DecodeMetric(tt.payload, &m)
+			if tt.want == nil { // any error will do
+				if len(tt.payload) != BinarySize {
+					t.Fatalf("payload is %d bytes, want %d", len(tt.payload), BinarySize)
+				}
+				if err == nil || format != FormatJSON {
+					t.Errorf("decoded as %q %+v, %v; want a JSON error", format, m, err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.want) {
+				t.Errorf("err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	var m Metric
+	if _, err := DecodeMetric(appendBinaryRecord(nil, fullMetric())[:BinarySize-1], &m); err == nil {
+		t.Error("a 31-byte unframed record decoded without error")
+	}
+}
+
+// withHeaderByte returns a copy of frame with header byte i set to b.
+func withHeaderByte(frame []byte, i int, b byte) []byte {
+	out := append([]byte(nil), frame...)
+	out[i] = b
+	return out
+}
+
+func TestEncodersWriteEnvelope(t *testing.T) {
+	m := fullMetric()
+	tests := []struct {
+		name  string
+		enc   Encoder
+		codec byte
+	}{
+		{"json", JSONEncoder{}, formatJSON},
+		{"binary", BinaryEncoder{}, formatBinary},
+		{"compact binary", BinaryEncoder{Compact: true}, formatCompact},
+		{"tagged binary", BinaryEncoder{Tagged: true}, formatTagged},
+		{"proto", ProtoEncoder{}, formatProto},
+		{"msgpack", MsgpackEncoder{}, formatMsgpack},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := tt.enc.Encode(m)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := []byte{frameMagic, frameMagic2, envelopeVersion, tt.codec, 0}
+			if !reflect.DeepEqual(p[:frameHeaderSize], want) {
+				t.Errorf("header = % x, want % x", p[:frameHeaderSize], want)
+			}
+		})
+	}
+	for name, p := range map[string][]byte{
+		"batch": EncodeBinaryBatch([]*Metric{m}),
+		"multi": EncodeMultiFrame([][]byte{EncodeMetric(m)}),
+	} {
+		if codec, _, err := parseFrame(p); err != nil || p[1] != frameMagic2 || !knownCodec(codec) {
+			t.Errorf("%s frame header = % x", name, p[:frameHeaderSize])
+		}
+	}
+	if p, _ := (JSONEncoder{Bare: true}).Encode(m); p[0] != '{' {
+		t.Errorf("bare JSON starts with %q", p[0])
+	}
+}
+
+func TestDecodeMetricLegacyJSON(t *testing.T) {
+	// What agents sent before host, seq and the collectors existed.
+	payload := []byte(`{"timestamp":1700000000,"cpu_usage":12.5,"mem_usage":40}`)
+	var m Metric
+	format, err := DecodeMetric(payload, &m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Metric{Timestamp: 1700000000, CPUUsage: 12.5, MemUsage: 40}
+	if format != FormatJSON || !reflect.DeepEqual(m, want) {
+		t.Errorf("got %q %+v, want json %+v", format, m, want)
+	}
+}
+
+func TestIsLegacyBinary(t *testing.T) {
+	plain := &Metric{Timestamp: 1_700_000_000_000_000_000, CPUUsage: 1, MemUsage: 2}
+	// A record whose timestamp's low byte is '{' can't be told from JSON, so it goes to
+	// the JSON decoder; -input-format=binary still decodes it.
+	brace := &Metric{Timestamp: 1_700_000_000_000_000_000&^0xff | '{', CPUUsage: 1, MemUsage: 2}
+	record := appendBinaryRecord(nil, brace)
+	if record[0] != '{' {
+		t.Fatalf("record starts with %q", record[0])
+	}
+	json32 := []byte(`{"timestamp":12,"cpu_usage":1.5}`)
+	if len(json32) != BinarySize {
+		t.Fatalf("JSON payload is %d bytes, want %d", len(json32), BinarySize)
+	}
+
+	if !IsLegacyBinary(appendBinaryRecord(nil, plain)) {
+		t.Error("IsLegacyBinary(record) = false")
+	}
+	if IsLegacyBinary(record) {
+		t.Error("IsLegacyBinary(record starting with '{') = true")
+	}
+	if IsLegacyBinary(json32) {
+		t.Error("IsLegacyBinary(32-byte JSON object) = true")
+	}
+	if IsLegacyBinary(record[:BinarySize-1]) {
+		t.Error("IsLegacyBinary(31 bytes) = true")
+	}
+
+	// Unframed records are never guessed at: the caller opts in with IsLegacyBinary
+	// (the server's -legacy-binary) or by asking for the binary format.
+	var m Metric
+	legacy := appendBinaryRecord(nil, plain)
+	if format, err := DecodeMetric(legacy, &m); err == nil {
+		t.Errorf("unframed record decoded as %q %+v without an opt-in", format, m)
+	}
+	if err := DecodeMetricAs(FormatBinary, legacy, &m); err != nil || !reflect.DeepEqual(m, binaryFields(plain)) {
+		t.Errorf("unframed record decoded strictly as %+v, %v", m, err)
+	}
+	if format, err := DecodeMetric(record, &m); err == nil || format != FormatJSON {
+		t.Errorf("record starting with '{' decoded as %q %+v, %v; want a JSON error", format, m, err)
+	}
+	if err := DecodeMetricAs(FormatBinary, record, &m); err != nil || !reflect.DeepEqual(m, binaryFields(brace)) {
+		t.Errorf("record decoded strictly as %+v, %v", m, err)
+	}
+	if format, err := DecodeMetric(json32, &m); err != nil || format != FormatJSON || m.Timestamp != 12 || m.CPUUsage != 1.5 {
+		t.Errorf("JSON decoded as %q %+v, %v", format, m, err)
+	}
+}
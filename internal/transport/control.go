@@ -0,0 +1,21 @@
+package transport
+
+import jsoniter "github.com/json-iterator/go"
+
+// DefaultControlChannel is where agents announce themselves to the server.
+const DefaultControlChannel = "sentinel:control"
+
+// Hello is the handshake an agent publishes on the control channel: the identity it
+// claims (its hostname, as sent in Metric.Host) and the pre-shared token for it. Pub/Sub
+// keeps no history, so agents repeat it periodically for servers that start later.
+type Hello struct {
+	Host  string `json:"host"`
+	Token string `json:"token"`
+}
+
+// DecodeHello parses a control-channel payload.
+func DecodeHello(payload []byte) (Hello, error) {
+	var h Hello
+	err := jsoniter.Unmarshal(payload, &h)
+	return h, err
+}
@@ -0,0 +1,21 @@
+package transport
+
+import jsoniter "github.com/json-iterator/go"
+
+// DefaultEchoChannel is where cmd/bench -measure listens for echoes by default.
+const DefaultEchoChannel = "sentinel:echo"
+
+// Echo is what a server with ECHO_CHANNEL publishes once it has written a batch: the
+// send times (Metric.SendTimeUnixNano) of the samples in it. The client that stamped
+// them can then measure the whole round trip on its own clock, without relying on the
+// server's or on clocks being in sync.
+type Echo struct {
+	SendTimes []int64 `json:"send_times"`
+}
+
+// DecodeEcho parses an echo-channel payload.
+func DecodeEcho(payload []byte) (Echo, error) {
+	var e Echo
+	err := jsoniter.Unmarshal(payload, &e)
+	return e, err
+}
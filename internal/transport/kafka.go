@@ -0,0 +1,121 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// kafkaBatchTimeout is how long the writer waits to fill a batch. kafka-go's 1s default
+// would add up to a second to every publish.
+const kafkaBatchTimeout = 5 * time.Millisecond
+
+// KafkaClient is the Kafka Transport. Channels map to topics with ':' replaced by '.',
+// since Kafka topic names can't contain ':' (so "metrics:binary" is the topic
+// "metrics.binary"). Publishes are keyed by Options.Key, which the agent sets to its
+// hostname, so a host's samples stay in order on one partition; with no key they are
+// spread round-robin. Subscriptions join the consumer group Options.Group, so server
+// replicas in one group share the partitions between them.
+type KafkaClient struct {
+	brokers []string
+	key     []byte
+	group   string
+	writer  *kafka.Writer
+}
+
+var _ Transport = (*KafkaClient)(nil)
+
+// NewKafkaClient takes one or more bootstrap brokers in addr, separated by ';' (REDIS_ADDR
+// already uses ',' between sources). Nothing is dialled until the first publish or subscribe.
+func NewKafkaClient(addr string, opts Options) *KafkaClient {
+	brokers := strings.Split(addr, ";")
+	c := &KafkaClient{brokers: brokers, group: opts.group()}
+	if opts.Key != "" {
+		c.key = []byte(opts.Key)
+	}
+	c.writer = &kafka.Writer{
+		Addr:                   kafka.TCP(brokers...),
+		Balancer:               &kafka.Hash{},
+		BatchTimeout:           kafkaBatchTimeout,
+		RequiredAcks:           kafka.RequireOne,
+		AllowAutoTopicCreation: true,
+	}
+	return c
+}
+
+// kafkaTopic is the topic for channel.
+func kafkaTopic(channel string) string {
+	return strings.ReplaceAll(channel, ":", ".")
+}
+
+func (c *KafkaClient) Publish(ctx context.Context, channel string, payload []byte) error {
+	return c.writer.WriteMessages(ctx, kafka.Message{Topic: kafkaTopic(channel), Key: c.key, Value: payload})
+}
+
+func (c *KafkaClient) PublishBatch(ctx context.Context, msgs []Message) []error {
+	kmsgs := make([]kafka.Message, len(msgs))
+	for i, m := range msgs {
+		kmsgs[i] = kafka.Message{Topic: kafkaTopic(m.Channel), Key: c.key, Value: m.Payload}
+	}
+	errs := make([]error, len(msgs))
+	err := c.writer.WriteMessages(ctx, kmsgs...)
+	var werrs kafka.WriteErrors
+	switch {
+	case err == nil:
+	case errors.As(err, &werrs):
+		copy(errs, werrs)
+	default:
+		for i := range errs {
+			errs[i] = err
+		}
+	}
+	return errs
+}
+
+// Subscribe joins the consumer group for channels' topics. New groups start at the
+// latest offset, like a Pub/Sub subscriber that only sees what is published after it
+// joins; after that, committed offsets let a restarted server pick up where it left off.
+func (c *KafkaClient) Subscribe(ctx context.Context, channels ...string) Subscription {
+	s := &kafkaSubscription{channels: make(map[string]string, len(channels))}
+	topics := make([]string, len(channels))
+	for i, ch := range channels {
+		topics[i] = kafkaTopic(ch)
+		s.channels[topics[i]] = ch
+	}
+	s.reader = kafka.NewReader(kafka.ReaderConfig{
+		Brokers:     c.brokers,
+		GroupID:     c.group,
+		GroupTopics: topics,
+		StartOffset: kafka.LastOffset,
+		// Range assignment gives a member the same partition numbers of every topic,
+		// so with equal partition counts a host's hellos on the control topic reach
+		// the replica that gets its samples.
+		GroupBalancers: []kafka.GroupBalancer{kafka.RangeGroupBalancer{}},
+		CommitInterval: time.Second,
+	})
+	return s
+}
+
+type kafkaSubscription struct {
+	reader   *kafka.Reader
+	channels map[string]string // topic -> channel
+}
+
+func (s *kafkaSubscription) Receive(ctx context.Context) (Delivery, error) {
+	m, err := s.reader.ReadMessage(ctx)
+	if err != nil {
+		return Delivery{}, err
+	}
+	return Delivery{Channel: s.channels[m.Topic], Payload: string(m.Value)}, nil
+}
+
+func (s *kafkaSubscription) Close() error {
+	return s.reader.Close()
+}
+
+func (c *KafkaClient) Close() error {
+	return c.writer.Close()
+}
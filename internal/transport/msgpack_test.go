@@ -0,0 +1,42 @@
+package transport
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func TestMsgpackSelectedAndDetected(t *testing.T) {
+	want := Metric{
+		Timestamp: 1_700_000_000_000_000_000,
+		CPUUsage:  42.5,
+		MemUsage:  61.25,
+		Host:      "web-1",
+		Seq:       7,
+		GPUs:      []GPU{{Index: 1, UtilPercent: 90, MemUsedMB: 1024, MemTotalMB: 8192}},
+		Tags:      map[string]string{"env": "prod"},
+	}
+	enc, err := NewEncoder(FormatMsgpack) // -format=msgpack, or format: msgpack in -config
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := enc.Encode(&want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m Metric
+	if format, err := DecodeMetric(payload, &m); err != nil || format != FormatMsgpack || !reflect.DeepEqual(m, want) {
+		t.Errorf("detected %q %+v, %v", format, m, err)
+	}
+
+	// Self-describing: keys this build doesn't know are skipped and missing ones stay zero.
+	body, err := msgpack.Marshal(map[string]any{"timestamp": 5, "cpu_usage": 1.5, "added_later": []string{"x"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m = Metric{}
+	if err := DecodeMetricAs(FormatMsgpack, append([]byte{frameMagic, formatMsgpack}, body...), &m); err != nil || !reflect.DeepEqual(m, Metric{Timestamp: 5, CPUUsage: 1.5}) {
+		t.Errorf("with an unknown key: %+v, %v", m, err)
+	}
+}
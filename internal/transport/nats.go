@@ -0,0 +1,129 @@
+package transport
+
+import (
+	"context"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	// natsFlushTimeout bounds how long PublishBatch waits for the server to confirm it
+	// has read a batch.
+	natsFlushTimeout = 5 * time.Second
+	// natsSubBuffer is how many received messages a subscription holds before NATS
+	// counts it as a slow consumer and drops messages.
+	natsSubBuffer = 4096
+)
+
+// NATSClient is the NATS core Transport; channels are subjects. The client reconnects
+// on its own, and subscriptions survive a reconnect. Publishes are not buffered while
+// disconnected, so they fail like Redis publishes do and the agent's retry buffer
+// (rather than the NATS client's) holds samples through an outage.
+type NATSClient struct {
+	conn *nats.Conn
+	errs chan error // disconnects, reported once each by a subscription's Receive
+}
+
+var _ Transport = (*NATSClient)(nil)
+
+// NewNATSClient connects to the NATS server at addr ("host:port" or a nats:// URL). A
+// server that isn't up yet is retried in the background rather than failing here.
+func NewNATSClient(addr string) (*NATSClient, error) {
+	c := &NATSClient{errs: make(chan error, 1)}
+	conn, err := nats.Connect(addr,
+		nats.Name("sentinel-stream"),
+		nats.RetryOnFailedConnect(true),
+		nats.MaxReconnects(-1),
+		nats.ReconnectBufSize(-1),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			if err == nil {
+				return // closed on purpose
+			}
+			select {
+			case c.errs <- err:
+			default:
+			}
+		}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	c.conn = conn
+	return c, nil
+}
+
+func (c *NATSClient) Publish(ctx context.Context, channel string, payload []byte) error {
+	return c.conn.Publish(channel, payload)
+}
+
+// PublishBatch writes msgs to the connection's buffer and flushes it once. A failed
+// flush fails every message, since NATS can't say which of them the server read.
+func (c *NATSClient) PublishBatch(ctx context.Context, msgs []Message) []error {
+	errs := make([]error, len(msgs))
+	written := false
+	for i, m := range msgs {
+		errs[i] = c.conn.Publish(m.Channel, m.Payload)
+		written = written || errs[i] == nil
+	}
+	if !written {
+		return errs // disconnected: nothing to flush
+	}
+	ctx, cancel := context.WithTimeout(ctx, natsFlushTimeout)
+	defer cancel()
+	if err := c.conn.FlushWithContext(ctx); err != nil {
+		for i := range errs {
+			if errs[i] == nil {
+				errs[i] = err
+			}
+		}
+	}
+	return errs
+}
+
+// Subscribe subscribes to each channel as a subject, all feeding one subscription.
+func (c *NATSClient) Subscribe(ctx context.Context, channels ...string) Subscription {
+	s := &natsSubscription{msgs: make(chan *nats.Msg, natsSubBuffer), errs: c.errs}
+	for _, ch := range channels {
+		sub, err := c.conn.ChanSubscribe(ch, s.msgs)
+		if err != nil {
+			s.err = err
+			break
+		}
+		s.subs = append(s.subs, sub)
+	}
+	return s
+}
+
+type natsSubscription struct {
+	msgs chan *nats.Msg
+	errs <-chan error
+	subs []*nats.Subscription
+	err  error // from Subscribe, returned by every Receive
+}
+
+func (s *natsSubscription) Receive(ctx context.Context) (Delivery, error) {
+	if s.err != nil {
+		return Delivery{}, s.err
+	}
+	select {
+	case m := <-s.msgs:
+		return Delivery{Channel: m.Subject, Payload: string(m.Data)}, nil
+	case err := <-s.errs:
+		return Delivery{}, err
+	case <-ctx.Done():
+		return Delivery{}, ctx.Err()
+	}
+}
+
+func (s *natsSubscription) Close() error {
+	for _, sub := range s.subs {
+		sub.Unsubscribe()
+	}
+	return nil
+}
+
+func (c *NATSClient) Close() error {
+	c.conn.Close()
+	return nil
+}
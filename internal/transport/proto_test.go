@@ -0,0 +1,65 @@
+package transport
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// protoSample has every field the proto schema carries.
+func protoSample() *Metric {
+	return &Metric{
+		Timestamp:        1_700_000_000_000_000_000,
+		CPUUsage:         42.5,
+		MemUsage:         61.25,
+		SendTimeUnixNano: 1_700_000_000_000_500_000,
+		Host:             "web-1",
+		Seq:              7,
+		GPUs:             []GPU{{Index: 0, UtilPercent: 90, MemUsedMB: 1024, MemTotalMB: 8192}},
+		Disks:            []DiskIO{{Device: "sda", ReadBytesPerSec: 4096, WritesPerSec: 3, BusyPercent: 12.5}},
+		Tags:             map[string]string{"env": "prod", "role": "web"},
+		Samples:          []Sample{{Measurement: "net", Tags: map[string]string{"iface": "eth0"}, Fields: map[string]float64{"rx_bytes": 10}}},
+	}
+}
+
+func TestProtoRoundTrip(t *testing.T) {
+	enc, err := NewEncoder(FormatProto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := enc.Encode(protoSample())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m Metric
+	if format, err := DecodeMetric(payload, &m); err != nil || format != FormatProto || !reflect.DeepEqual(m, *protoSample()) {
+		t.Errorf("decoded %q %+v, %v", format, m, err)
+	}
+}
+
+func TestProtoFieldCompatibility(t *testing.T) {
+	payload, err := ProtoEncoder{}.Encode(protoSample())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A newer agent's sample: a field this build doesn't know is skipped.
+	newer := protowire.AppendTag(slices.Clone(payload), 999, protowire.BytesType)
+	newer = protowire.AppendString(newer, "added later")
+	var m Metric
+	if format, err := DecodeMetric(newer, &m); err != nil || format != FormatProto || !reflect.DeepEqual(m, *protoSample()) {
+		t.Errorf("with an unknown field: %q %+v, %v", format, m, err)
+	}
+
+	// An older agent's sample: the fields it predates are left zero.
+	want := Metric{Timestamp: 1_700_000_000_000_000_000, CPUUsage: 42.5, MemUsage: 61.25}
+	old, err := ProtoEncoder{}.Encode(&want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m = Metric{}
+	if format, err := DecodeMetric(old, &m); err != nil || format != FormatProto || !reflect.DeepEqual(m, want) {
+		t.Errorf("without host or tags: %q %+v, %v", format, m, err)
+	}
+}
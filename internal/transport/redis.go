@@ -2,35 +2,109 @@ package transport
 
 import (
 	"context"
-	"encoding/json"
+	"strings"
+
 	"github.com/redis/go-redis/v9"
 )
 
-// RedisClient wraps the official redis client to add our custom logic
+// RedisClient is the Redis Pub/Sub Transport, wrapping the official redis client.
 type RedisClient struct {
-	client *redis.Client
+	client redis.UniversalClient
+}
+
+var _ Transport = (*RedisClient)(nil)
+
+// NewRedisClient connects to Redis at addr, or through Sentinel or to a Cluster as
+// opts say (see NewRedisUniversalClient).
+func NewRedisClient(addr string, opts Options) *RedisClient {
+	return &RedisClient{client: NewRedisUniversalClient(addr, opts)}
 }
 
-// NewRedisClient initializes a connection to the Docker container
-func NewRedisClient(addr string) *RedisClient {
-	rdb := redis.NewClient(&redis.Options{
-		Addr: addr, // Usually "localhost:6379"
+// NewRedisUniversalClient connects to the Redis server at addr (usually
+// "localhost:6379"). With opts.RedisMaster, addr lists Sentinels instead, separated by
+// ';', and the client asks them for that master and follows it through failovers. With
+// opts.RedisCluster, addr lists one or more Cluster nodes, separated by ';', from which
+// the rest of the cluster is discovered. Credentials and TLS come from opts too.
+func NewRedisUniversalClient(addr string, opts Options) redis.UniversalClient {
+	if opts.RedisMaster == "" && !opts.RedisCluster {
+		return redis.NewClient(&redis.Options{
+			Addr:      addr,
+			Username:  opts.RedisUsername,
+			Password:  opts.RedisPassword,
+			TLSConfig: opts.RedisTLS,
+		})
+	}
+	var addrs []string
+	for _, a := range strings.Split(addr, ";") {
+		if a = strings.TrimSpace(a); a != "" {
+			addrs = append(addrs, a)
+		}
+	}
+	return redis.NewUniversalClient(&redis.UniversalOptions{
+		Addrs:         addrs,
+		MasterName:    opts.RedisMaster,
+		IsClusterMode: opts.RedisCluster,
+		Username:      opts.RedisUsername,
+		Password:      opts.RedisPassword,
+		TLSConfig:     opts.RedisTLS,
 	})
-	return &RedisClient{client: rdb}
 }
 
-// PublishMetric converts our struct to JSON and sends it to a Redis channel
-func (r *RedisClient) PublishMetric(ctx context.Context, channel string, data interface{}) error {
-	payload, err := json.Marshal(data)
+// Publish sends a raw payload to a Redis channel.
+func (r *RedisClient) Publish(ctx context.Context, channel string, payload []byte) error {
+	return r.client.Publish(ctx, channel, payload).Err()
+}
+
+// PublishBatch sends msgs in a single pipeline.
+func (r *RedisClient) PublishBatch(ctx context.Context, msgs []Message) []error {
+	pipe := r.client.Pipeline()
+	cmds := make([]redis.Cmder, len(msgs))
+	for i, m := range msgs {
+		cmds[i] = pipe.Publish(ctx, m.Channel, m.Payload)
+	}
+	_, err := pipe.Exec(ctx)
+	return pipelineErrs(cmds, err)
+}
+
+// pipelineErrs returns one error per command of a pipeline that Exec returned err for.
+// A command that failed has its own error. If none has but Exec failed, the pipeline
+// never ran (go-redis leaves the commands alone when it can't get a connection), so
+// every command failed with err.
+func pipelineErrs(cmds []redis.Cmder, err error) []error {
+	errs := make([]error, len(cmds))
+	ran := err == nil
+	for i, c := range cmds {
+		errs[i] = c.Err()
+		ran = ran || errs[i] != nil
+	}
+	if !ran {
+		for i := range errs {
+			errs[i] = err
+		}
+	}
+	return errs
+}
+
+// Subscribe subscribes to channels. go-redis re-dials and re-subscribes on the next
+// Receive after a connection error.
+func (r *RedisClient) Subscribe(ctx context.Context, channels ...string) Subscription {
+	return redisSubscription{r.client.Subscribe(ctx, channels...)}
+}
+
+type redisSubscription struct {
+	pubsub *redis.PubSub
+}
+
+func (s redisSubscription) Receive(ctx context.Context) (Delivery, error) {
+	msg, err := s.pubsub.ReceiveMessage(ctx)
 	if err != nil {
-		return err
+		return Delivery{}, err
 	}
-	return r.client.Publish(ctx, channel, payload).Err()
+	return Delivery{Channel: msg.Channel, Payload: msg.Payload}, nil
 }
 
-// PublishBytes sends a raw payload to a Redis channel (e.g. for binary protocol).
-func (r *RedisClient) PublishBytes(ctx context.Context, channel string, payload []byte) error {
-	return r.client.Publish(ctx, channel, payload).Err()
+func (s redisSubscription) Close() error {
+	return s.pubsub.Close()
 }
 
 // Close cleans up the connection
@@ -0,0 +1,78 @@
+package transport
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// LoadTLSConfig builds the TLS config for a broker connection. caFile, if set, holds
+// the PEM certificates that verify the server instead of the system roots; certFile
+// and keyFile, if set, are the client certificate for mutual TLS. The server name is
+// taken from each address dialled.
+func LoadTLSConfig(caFile, certFile, keyFile string) (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: no PEM certificates", caFile)
+		}
+		cfg.RootCAs = pool
+	}
+	if (certFile == "") != (keyFile == "") {
+		return nil, errors.New("a client certificate needs both a cert and a key file")
+	}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// RedisFlags are the Redis connection flags the agent, bench, cmd/top and cmd/dlq
+// share: Sentinel or Cluster, ACL credentials and TLS. The server reads the same
+// settings from REDIS_* env vars.
+type RedisFlags struct {
+	master, user, password *string
+	cluster, tls           *bool
+	ca, cert, key          *string
+}
+
+// AddRedisFlags defines the Redis connection flags on fs. addrFlag names the binary's
+// broker address flag, for the help text.
+func AddRedisFlags(fs *flag.FlagSet, addrFlag string) *RedisFlags {
+	return &RedisFlags{
+		master:   fs.String("redis-master", "", "Redis Sentinel master name; -"+addrFlag+" then lists Sentinels separated by ';' and the client follows failovers"),
+		cluster:  fs.Bool("redis-cluster", false, "-"+addrFlag+" is a Redis Cluster: one or more of its nodes, separated by ';'"),
+		user:     fs.String("redis-user", "", "Redis ACL user to authenticate as (default the default user)"),
+		password: fs.String("redis-password", os.Getenv("REDIS_PASSWORD"), "Redis password, for the ACL user or requirepass (default $REDIS_PASSWORD)"),
+		tls:      fs.Bool("redis-tls", false, "connect to Redis over TLS, verified against the system roots unless -redis-tls-ca is set"),
+		ca:       fs.String("redis-tls-ca", "", "PEM CA certificates that verify the Redis server; implies -redis-tls"),
+		cert:     fs.String("redis-tls-cert", "", "PEM client certificate for mutual TLS, with -redis-tls-key; implies -redis-tls"),
+		key:      fs.String("redis-tls-key", "", "PEM key for -redis-tls-cert"),
+	}
+}
+
+// Apply sets the Redis fields of opts from the flags. It must be called after fs.Parse.
+func (f *RedisFlags) Apply(opts *Options) error {
+	opts.RedisMaster, opts.RedisCluster = *f.master, *f.cluster
+	opts.RedisUsername, opts.RedisPassword = *f.user, *f.password
+	if *f.tls || *f.ca != "" || *f.cert != "" || *f.key != "" {
+		cfg, err := LoadTLSConfig(*f.ca, *f.cert, *f.key)
+		if err != nil {
+			return fmt.Errorf("-redis-tls: %w", err)
+		}
+		opts.RedisTLS = cfg
+	}
+	return nil
+}
@@ -0,0 +1,233 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// streamMaxLen caps each stream (approximately, so trimming stays cheap). Entries
+	// are only needed until the consumer group has acked them.
+	streamMaxLen = 1_000_000
+	// streamField is the entry field holding the payload.
+	streamField = "p"
+	// streamReadCount and streamBlock bound one XREADGROUP; the block is short so a
+	// cancelled context is noticed promptly.
+	streamReadCount = 256
+	streamBlock     = time.Second
+)
+
+// RedisStreamClient is the Redis Streams Transport: channels are stream keys, publishes
+// are XADDs and subscriptions read with XREADGROUP in a consumer group. Unlike Pub/Sub,
+// entries published while no server is running wait in the stream, and entries a
+// consumer has read stay pending until it acks them (see Acker), so a server that
+// crashes before writing them gets them again when it restarts under the same consumer
+// name, or another consumer claims them once they have been idle for ClaimIdle.
+type RedisStreamClient struct {
+	client    redis.UniversalClient
+	group     string
+	consumer  string
+	claimIdle time.Duration
+}
+
+var _ Transport = (*RedisStreamClient)(nil)
+
+// NewRedisStreamClient connects to Redis at addr, or through Sentinel or to a Cluster as
+// opts say (see NewRedisUniversalClient). Subscriptions join opts.Group (default
+// DefaultGroup) as opts.Consumer (default the hostname).
+func NewRedisStreamClient(addr string, opts Options) *RedisStreamClient {
+	return &RedisStreamClient{
+		client:    NewRedisUniversalClient(addr, opts),
+		group:     opts.group(),
+		consumer:  opts.consumer(),
+		claimIdle: opts.claimIdle(),
+	}
+}
+
+func (r *RedisStreamClient) xadd(channel string, payload []byte) *redis.XAddArgs {
+	return &redis.XAddArgs{Stream: channel, MaxLen: streamMaxLen, Approx: true, Values: []any{streamField, payload}}
+}
+
+func (r *RedisStreamClient) Publish(ctx context.Context, channel string, payload []byte) error {
+	return r.client.XAdd(ctx, r.xadd(channel, payload)).Err()
+}
+
+// PublishBatch sends msgs as XADDs in a single pipeline.
+func (r *RedisStreamClient) PublishBatch(ctx context.Context, msgs []Message) []error {
+	pipe := r.client.Pipeline()
+	cmds := make([]redis.Cmder, len(msgs))
+	for i, m := range msgs {
+		cmds[i] = pipe.XAdd(ctx, r.xadd(m.Channel, m.Payload))
+	}
+	_, err := pipe.Exec(ctx)
+	return pipelineErrs(cmds, err)
+}
+
+// Subscribe reads channels in the consumer group, creating the streams and the group
+// (starting at new entries) if needed. It first re-reads this consumer's pending
+// entries, the ones it read but never acked before it last stopped. Then, before new
+// entries and again every ClaimIdle, it claims entries that have been pending for
+// ClaimIdle with any consumer in the group (XAUTOCLAIM): those of a server that was
+// replaced under another name, and its own whose write failed.
+func (r *RedisStreamClient) Subscribe(ctx context.Context, channels ...string) Subscription {
+	after := make(map[string]string, len(channels))
+	for _, ch := range channels {
+		after[ch] = "0"
+	}
+	return &redisStreamSubscription{r: r, streams: channels, after: after}
+}
+
+type redisStreamSubscription struct {
+	r       *RedisStreamClient
+	streams []string
+	ready   bool              // groups exist
+	after   map[string]string // while replaying pending entries, the last ID read per stream; nil after
+	claim   map[string]string // while claiming, the XAUTOCLAIM cursor per stream not yet done
+	claimAt time.Time         // when to claim next
+	buf     []Delivery
+}
+
+func (s *redisStreamSubscription) Receive(ctx context.Context) (Delivery, error) {
+	for len(s.buf) == 0 {
+		if err := s.read(ctx); err != nil {
+			return Delivery{}, err
+		}
+	}
+	d := s.buf[0]
+	s.buf = s.buf[1:]
+	return d, nil
+}
+
+// read fills buf with the next entries, blocking up to streamBlock.
+func (s *redisStreamSubscription) read(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if !s.ready {
+		for _, st := range s.streams {
+			err := s.r.client.XGroupCreateMkStream(ctx, st, s.r.group, "$").Err()
+			if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+				return err
+			}
+		}
+		s.ready = true
+	}
+	if s.after == nil && !time.Now().Before(s.claimAt) {
+		return s.claimIdle(ctx)
+	}
+	args := make([]string, 0, 2*len(s.streams))
+	args = append(args, s.streams...)
+	block := streamBlock
+	for _, st := range s.streams {
+		if s.after != nil {
+			args = append(args, s.after[st])
+			block = -1 // pending entries are there already; don't wait
+		} else {
+			args = append(args, ">")
+		}
+	}
+	res, err := s.r.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    s.r.group,
+		Consumer: s.r.consumer,
+		Streams:  args,
+		Count:    streamReadCount,
+		Block:    block,
+	}).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil // block timed out
+	}
+	if err != nil {
+		return s.readErr(err)
+	}
+	n := 0
+	for _, st := range res {
+		for _, m := range st.Messages {
+			n++
+			payload, _ := m.Values[streamField].(string)
+			s.buf = append(s.buf, Delivery{Channel: st.Stream, Payload: payload, ID: m.ID})
+			if s.after != nil {
+				s.after[st.Stream] = m.ID
+			}
+		}
+	}
+	if s.after != nil && n == 0 {
+		s.after = nil // replay done; read new entries from now on
+	}
+	return nil
+}
+
+// claimIdle takes over one page of idle pending entries per stream. Once every stream's
+// cursor is back at the start, the next claim is ClaimIdle away.
+func (s *redisStreamSubscription) claimIdle(ctx context.Context) error {
+	if s.claim == nil {
+		s.claim = make(map[string]string, len(s.streams))
+		for _, st := range s.streams {
+			s.claim[st] = "0-0"
+		}
+	}
+	for _, st := range s.streams {
+		start, ok := s.claim[st]
+		if !ok {
+			continue
+		}
+		msgs, next, err := s.r.client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+			Stream:   st,
+			Group:    s.r.group,
+			Consumer: s.r.consumer,
+			MinIdle:  s.r.claimIdle,
+			Start:    start,
+			Count:    streamReadCount,
+		}).Result()
+		if err != nil {
+			return s.readErr(err)
+		}
+		for _, m := range msgs {
+			payload, _ := m.Values[streamField].(string)
+			s.buf = append(s.buf, Delivery{Channel: st, Payload: payload, ID: m.ID})
+		}
+		if next == "0-0" {
+			delete(s.claim, st)
+		} else {
+			s.claim[st] = next
+		}
+	}
+	if len(s.claim) == 0 {
+		s.claim, s.claimAt = nil, time.Now().Add(s.r.claimIdle)
+	}
+	return nil
+}
+
+// readErr handles an error reading or claiming: a deleted stream makes the next read
+// create it and the group again.
+func (s *redisStreamSubscription) readErr(err error) error {
+	if strings.HasPrefix(err.Error(), "NOGROUP") {
+		s.ready = false
+	}
+	return err
+}
+
+// Ack acknowledges ds in the consumer group, one XACK per stream in a single pipeline.
+func (s *redisStreamSubscription) Ack(ctx context.Context, ds ...Delivery) error {
+	ids := make(map[string][]string)
+	for _, d := range ds {
+		ids[d.Channel] = append(ids[d.Channel], d.ID)
+	}
+	pipe := s.r.client.Pipeline()
+	for st, stIDs := range ids {
+		pipe.XAck(ctx, st, s.r.group, stIDs...)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (s *redisStreamSubscription) Close() error {
+	return nil
+}
+
+func (r *RedisStreamClient) Close() error {
+	return r.client.Close()
+}
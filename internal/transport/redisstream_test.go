@@ -0,0 +1,109 @@
+package transport
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func receiveN(t *testing.T, sub Subscription, n int) []Delivery {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var ds []Delivery
+	for len(ds) < n {
+		d, err := sub.Receive(ctx)
+		if err != nil {
+			t.Fatalf("after %d of %d deliveries: %v", len(ds), n, err)
+		}
+		ds = append(ds, d)
+	}
+	return ds
+}
+
+func payloads(ds []Delivery) []string {
+	out := make([]string, len(ds))
+	for i, d := range ds {
+		out[i] = d.Payload
+	}
+	return out
+}
+
+// A server that dies without acking and comes back under a new name (a rescheduled pod
+// gets a new hostname) must not leave its pending entries behind.
+func TestStreamClaimsEntriesOfAReplacedConsumer(t *testing.T) {
+	m := miniredis.RunT(t)
+	start := time.Now()
+	m.SetTime(start)
+	ctx := context.Background()
+
+	old := NewRedisStreamClient(m.Addr(), Options{Consumer: "pod-a"})
+	defer old.Close()
+	oldSub := old.Subscribe(ctx, "metrics")
+	// Creates the group, so the entries below are delivered to it.
+	if err := oldSub.(*redisStreamSubscription).read(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{"1", "2"} {
+		if err := old.Publish(ctx, "metrics", []byte(p)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := payloads(receiveN(t, oldSub, 2)); got[0] != "1" || got[1] != "2" {
+		t.Fatalf("pod-a read %q", got)
+	}
+	// pod-a dies here, before writing or acking.
+
+	m.SetTime(start.Add(DefaultClaimIdle))
+	repl := NewRedisStreamClient(m.Addr(), Options{Consumer: "pod-b"})
+	defer repl.Close()
+	sub := repl.Subscribe(ctx, "metrics")
+	ds := receiveN(t, sub, 2)
+	if got := payloads(ds); got[0] != "1" || got[1] != "2" {
+		t.Fatalf("pod-b claimed %q, want [1 2]", got)
+	}
+	if err := sub.(Acker).Ack(ctx, ds...); err != nil {
+		t.Fatal(err)
+	}
+	pending, err := repl.client.XPending(ctx, "metrics", DefaultGroup).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pending.Count != 0 {
+		t.Errorf("%d entries still pending after pod-b acked", pending.Count)
+	}
+}
+
+func TestStreamLeavesRecentlyReadEntriesAlone(t *testing.T) {
+	m := miniredis.RunT(t)
+	start := time.Now()
+	m.SetTime(start)
+	ctx := context.Background()
+
+	a := NewRedisStreamClient(m.Addr(), Options{Consumer: "pod-a"})
+	defer a.Close()
+	subA := a.Subscribe(ctx, "metrics")
+	if err := subA.(*redisStreamSubscription).read(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Publish(ctx, "metrics", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	receiveN(t, subA, 1)
+
+	// pod-a is still writing the entry: pod-b must not take it.
+	m.SetTime(start.Add(DefaultClaimIdle / 2))
+	b := NewRedisStreamClient(m.Addr(), Options{Consumer: "pod-b"})
+	defer b.Close()
+	subB := b.Subscribe(ctx, "metrics").(*redisStreamSubscription)
+	for i := 0; i < 2; i++ { // its own (empty) pending entries, then the claim
+		if err := subB.read(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(subB.buf) != 0 {
+		t.Errorf("pod-b claimed %q from a live consumer", payloads(subB.buf))
+	}
+}
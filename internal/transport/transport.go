@@ -0,0 +1,171 @@
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"os"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+// Transport kinds accepted by New. Redis Pub/Sub is the default.
+const (
+	KindRedis        = "redis"
+	KindRedisStreams = "redis-streams"
+	KindNATS         = "nats"
+	KindKafka        = "kafka"
+)
+
+// DefaultGroup is the consumer group servers join when none is configured.
+const DefaultGroup = "sentinel-stream"
+
+// DefaultClaimIdle is Options.ClaimIdle's default: well beyond a flush interval plus
+// Influx retries, so entries a live server is still writing are left alone.
+const DefaultClaimIdle = time.Minute
+
+// Transport is the message broker between agents (and bench) and the server. cmd/*
+// only talk to brokers through it, so adding one means implementing it here and
+// adding a case to New.
+type Transport interface {
+	// Publish sends one payload to channel.
+	Publish(ctx context.Context, channel string, payload []byte) error
+	// PublishBatch sends msgs, in one round-trip where the broker allows it. It
+	// returns one error per message (nil if it was published), so callers can retry
+	// exactly the ones that failed.
+	PublishBatch(ctx context.Context, msgs []Message) []error
+	// Subscribe starts receiving from channels. Connection errors surface on
+	// Subscription.Receive.
+	Subscribe(ctx context.Context, channels ...string) Subscription
+	Close() error
+}
+
+// Subscription delivers messages from the channels passed to Transport.Subscribe.
+type Subscription interface {
+	// Receive blocks for the next message. After an error the next call tries to
+	// reconnect, so callers back off and call it again rather than resubscribing.
+	Receive(ctx context.Context) (Delivery, error)
+	Close() error
+}
+
+// Message is one payload bound for a channel.
+type Message struct {
+	Channel string
+	Payload []byte
+}
+
+// Delivery is one received message. The payload is a string, as brokers' clients
+// hand it over, so the server's receive loop doesn't copy it.
+type Delivery struct {
+	Channel string
+	Payload string
+	ID      string // broker's message ID; only set by transports whose subscriptions are Ackers
+}
+
+// Acker is implemented by subscriptions whose deliveries stay pending until they are
+// acknowledged, and are delivered again if the subscriber restarts first. Only the
+// Channel and ID of each delivery are used.
+type Acker interface {
+	Ack(ctx context.Context, ds ...Delivery) error
+}
+
+// Options are settings only some transports use; the others ignore them.
+type Options struct {
+	// Key is the partition key for every publish (Kafka). Agents set their hostname.
+	Key string
+	// Group is the consumer group subscriptions join (Kafka, Redis Streams); empty
+	// means DefaultGroup.
+	Group string
+	// Consumer names this subscriber within Group (Redis Streams); empty means the
+	// hostname. It should be stable across restarts, so unacked entries come straight
+	// back; entries left under another name are claimed after ClaimIdle.
+	Consumer string
+	// ClaimIdle is how long an entry may stay pending with another consumer in Group
+	// before a subscriber takes it over (Redis Streams); 0 means DefaultClaimIdle.
+	ClaimIdle time.Duration
+	// RedisMaster is the master name to ask Redis Sentinel for (redis, redis-streams);
+	// the address then lists Sentinels, separated by ';'.
+	RedisMaster string
+	// RedisCluster connects to a Redis Cluster (redis, redis-streams); the address then
+	// lists one or more of its nodes, separated by ';'.
+	RedisCluster bool
+	// RedisUsername and RedisPassword authenticate to Redis (redis, redis-streams): the
+	// password alone for requirepass or the default user, both for an ACL user.
+	// Sentinels are connected to without them.
+	RedisUsername string
+	RedisPassword string
+	// RedisTLS, if set, encrypts the connections to Redis, and to its Sentinels or
+	// Cluster nodes (redis, redis-streams).
+	RedisTLS *tls.Config
+}
+
+func (o Options) group() string {
+	if o.Group == "" {
+		return DefaultGroup
+	}
+	return o.Group
+}
+
+func (o Options) claimIdle() time.Duration {
+	if o.ClaimIdle <= 0 {
+		return DefaultClaimIdle
+	}
+	return o.ClaimIdle
+}
+
+func (o Options) consumer() string {
+	if o.Consumer != "" {
+		return o.Consumer
+	}
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		return "sentinel-server"
+	}
+	return host
+}
+
+// New connects to the broker of the given kind at addr.
+func New(kind, addr string, opts Options) (Transport, error) {
+	if opts.RedisMaster != "" && opts.RedisCluster {
+		return nil, fmt.Errorf("transport: Redis Sentinel (master %q) and Redis Cluster can't be combined", opts.RedisMaster)
+	}
+	switch kind {
+	case KindRedis:
+		return NewRedisClient(addr, opts), nil
+	case KindRedisStreams:
+		return NewRedisStreamClient(addr, opts), nil
+	case KindNATS:
+		c, err := NewNATSClient(addr)
+		if err != nil {
+			return nil, err
+		}
+		return c, nil
+	case KindKafka:
+		return NewKafkaClient(addr, opts), nil
+	default:
+		return nil, fmt.Errorf("transport: unknown kind %q (want redis, redis-streams, nats or kafka)", kind)
+	}
+}
+
+// DefaultAddr is the address New's callers use for kind when none is configured: the
+// broker's standard port on localhost.
+func DefaultAddr(kind string) string {
+	switch kind {
+	case KindNATS:
+		return "localhost:4222"
+	case KindKafka:
+		return "localhost:9092"
+	default:
+		return "localhost:6379"
+	}
+}
+
+// PublishJSON encodes v as JSON and publishes it to channel.
+func PublishJSON(ctx context.Context, t Transport, channel string, v any) error {
+	payload, err := jsoniter.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return t.Publish(ctx, channel, payload)
+}
@@ -25,7 +25,7 @@ sleep 15
 echo ">>> Running load generator: workers=${WORKERS} duration=${DURATION} redis=${REDIS_ADDR} ${BINARY_FLAG}"
 mkdir -p profiles
 
-go run ./cmd/bench/main.go -workers "${WORKERS}" -duration "${DURATION}" -redis "${REDIS_ADDR}" ${BINARY_FLAG} &
+go run ./cmd/bench -workers "${WORKERS}" -duration "${DURATION}" -redis "${REDIS_ADDR}" ${BINARY_FLAG} &
 BENCH_PID=$!
 
 echo ">>> Collecting 30s CPU profile from pprof while load is running..."